@@ -2,13 +2,48 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/northwindman/testREST-autentification/internal/config"
+	notificationslist "github.com/northwindman/testREST-autentification/internal/http-server/handlers/admin/notifications/list"
+	notificationsreplay "github.com/northwindman/testREST-autentification/internal/http-server/handlers/admin/notifications/replay"
+	"github.com/northwindman/testREST-autentification/internal/http-server/handlers/jwks"
+	"github.com/northwindman/testREST-autentification/internal/http-server/handlers/token/introspect"
+	"github.com/northwindman/testREST-autentification/internal/http-server/handlers/token/revoke"
 	"github.com/northwindman/testREST-autentification/internal/http-server/handlers/user/auth"
+	"github.com/northwindman/testREST-autentification/internal/http-server/handlers/user/login"
+	"github.com/northwindman/testREST-autentification/internal/http-server/handlers/user/logout"
+	"github.com/northwindman/testREST-autentification/internal/http-server/handlers/user/me"
+	passwordforgot "github.com/northwindman/testREST-autentification/internal/http-server/handlers/user/password/forgot"
+	passwordreset "github.com/northwindman/testREST-autentification/internal/http-server/handlers/user/password/reset"
 	"github.com/northwindman/testREST-autentification/internal/http-server/handlers/user/refresh"
+	sessionslist "github.com/northwindman/testREST-autentification/internal/http-server/handlers/user/sessions/list"
+	sessionsrevoke "github.com/northwindman/testREST-autentification/internal/http-server/handlers/user/sessions/revoke"
+	sessionsrevokeall "github.com/northwindman/testREST-autentification/internal/http-server/handlers/user/sessions/revokeall"
+	sessionsrevokelink "github.com/northwindman/testREST-autentification/internal/http-server/handlers/user/sessions/revokelink"
+	"github.com/northwindman/testREST-autentification/internal/http-server/handlers/user/verifyemail"
+	verifyemailresend "github.com/northwindman/testREST-autentification/internal/http-server/handlers/user/verifyemail/resend"
+	"github.com/northwindman/testREST-autentification/internal/http-server/middleware/bearerauth"
+	"github.com/northwindman/testREST-autentification/internal/http-server/middleware/clientauth"
+	"github.com/northwindman/testREST-autentification/internal/lib/background"
+	"github.com/northwindman/testREST-autentification/internal/lib/emailverify"
 	slogpretty "github.com/northwindman/testREST-autentification/internal/lib/logger"
 	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/outbox"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/sink"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/smtp"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/templates"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/webhook"
+	"github.com/northwindman/testREST-autentification/internal/lib/password"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
+	myjwt "github.com/northwindman/testREST-autentification/internal/lib/tokens/jwt"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/keys"
+	refreshtoken "github.com/northwindman/testREST-autentification/internal/lib/tokens/refresh"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"github.com/northwindman/testREST-autentification/internal/storage/memory"
 	"github.com/northwindman/testREST-autentification/internal/storage/postgres"
+	"github.com/northwindman/testREST-autentification/internal/storage/sqlite"
 	"log/slog"
 	"net/http"
 	"os"
@@ -28,28 +63,185 @@ func main() {
 	log := setupLogger(cfg.Env)
 
 	log.Info(
-		"starting url-shortener",
+		"starting testREST-authentication",
 		slog.String("env", cfg.Env),
 	)
 
-	storage, err := postgres.New(cfg.StoragePath)
+	storage, err := setupStorage(cfg.Storage)
 	if err != nil {
 		log.Error("failed to initialize storage", sl.Err(err))
 		panic(err)
 	}
 
-	log.Debug("storage INIT complete")
+	log.Debug("storage INIT complete", slog.String("driver", cfg.Storage.Driver))
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		defer storage.Close()
+
+		if err := runMigrate(log, storage, os.Args[2:]); err != nil {
+			log.Error("failed to migrate", sl.Err(err))
+			os.Exit(1)
+		}
+
+		return
+	}
+
+	if err := migrateUp(log, storage); err != nil {
+		log.Error("failed to apply migrations", sl.Err(err))
+		panic(err)
+	}
+
+	keyRing, err := setupKeyRing(log, cfg.SigningKey)
+	if err != nil {
+		log.Error("failed to load signing keys", sl.Err(err))
+		panic(err)
+	}
+
+	log.Info("signing keys loaded", slog.String("kid", keyRing.Active().ID))
+
+	if cfg.RetireAfter < cfg.AccessTTL {
+		log.Warn("retired signing keys expire before access tokens they signed")
+	}
+
+	refreshKey, err := setupRefreshKey(log, cfg.RefreshKey)
+	if err != nil {
+		log.Error("failed to set up refresh token key", sl.Err(err))
+		panic(err)
+	}
+
+	tokenManager := tokens.NewManager(myjwt.Options{
+		Issuer:   cfg.Tokens.Issuer,
+		Audience: cfg.Tokens.Audience,
+		TTL:      cfg.AccessTTL,
+		Leeway:   cfg.Leeway,
+		ClientID: cfg.Tokens.ClientID,
+		Scope:    cfg.Scope,
+	}, cfg.RefreshTTL, keyRing, refreshKey)
+
+	passwordPolicy, err := setupPasswordPolicy(log, cfg.Password)
+	if err != nil {
+		log.Error("failed to load password policy", sl.Err(err))
+		panic(err)
+	}
+
+	passwordHasher, err := setupPasswordHasher(cfg.Password.Hashing)
+	if err != nil {
+		log.Error("failed to set up password hashing", sl.Err(err))
+		panic(err)
+	}
+
+	notifier, err := setupNotifier(log, cfg.Notifications)
+	if err != nil {
+		log.Error("failed to set up notifications", sl.Err(err))
+		panic(err)
+	}
+
+	log.Debug("notifications INIT complete", slog.String("driver", cfg.Notifications.Driver))
+
+	outboxPool := outbox.New(log, storage, notifier, outbox.Options{
+		Workers:      cfg.Notifications.Outbox.Workers,
+		PollInterval: cfg.Notifications.Outbox.PollInterval,
+		MaxAttempts:  cfg.Notifications.Outbox.MaxAttempts,
+		MinBackoff:   cfg.Notifications.Outbox.MinBackoff,
+		MaxBackoff:   cfg.Notifications.Outbox.MaxBackoff,
+		Lease:        cfg.Notifications.Outbox.Lease,
+	})
+	outboxPool.Start()
+
+	// Emails which would tell registered addresses by the response time are sent from here
+	backgroundQueue := background.New(log, background.Options{})
+	backgroundQueue.Start()
+
+	emails, err := templates.New(templates.Links{
+		RevokeSession: cfg.Notifications.Links.RevokeSession,
+		VerifyEmail:   cfg.Notifications.Links.VerifyEmail,
+		ResetPassword: cfg.Notifications.Links.ResetPassword,
+	})
+	if err != nil {
+		log.Error("failed to load email templates", sl.Err(err))
+		panic(err)
+	}
+
+	if cfg.EmailVerification.Required && cfg.Notifications.Links.VerifyEmail == "" {
+		log.Error("email verification is required, but its link is not configured")
+		panic("notifications.links.verify_email is not set")
+	}
+	verificationMailer := emailverify.New(tokenManager, emails, cfg.EmailVerification.TTL)
+	requireVerifiedEmail := cfg.EmailVerification.Required
 
 	router := chi.NewRouter()
 
-	router.Post("/auth", auth.New(log, storage))
-	router.Patch("/refresh", refresh.New(log, storage))
+	router.Post("/auth", auth.New(log, storage, tokenManager, passwordPolicy, passwordHasher, emails, verificationMailer, requireVerifiedEmail))
+	router.Post("/login", login.New(log, storage, tokenManager, passwordHasher, requireVerifiedEmail))
+	router.Patch("/refresh", refresh.New(log, storage, tokenManager, emails, requireVerifiedEmail, cfg.ReuseGracePeriod))
+	router.Post("/verify-email", verifyemail.New(log, storage, tokenManager))
+	router.Post("/verify-email/resend", verifyemailresend.New(log, storage, verificationMailer, backgroundQueue, verifyemailresend.Options{
+		Limit:  cfg.EmailVerification.ResendLimit,
+		Window: cfg.EmailVerification.ResendWindow,
+	}))
+	// Without the link there is no way to get a reset token, so the whole flow is off
+	if cfg.Notifications.Links.ResetPassword != "" {
+		router.Post("/password/forgot", passwordforgot.New(log, storage, tokenManager, emails, backgroundQueue, passwordforgot.Options{
+			TTL:    cfg.Password.Reset.TTL,
+			Limit:  cfg.Password.Reset.Limit,
+			Window: cfg.Password.Reset.Window,
+		}))
+		router.Post("/password/reset", passwordreset.New(log, storage, tokenManager, passwordPolicy, passwordHasher, emails))
+	} else {
+		log.Warn("password reset link is not configured, password reset is disabled")
+	}
+	router.Post("/sessions/revoke-link", sessionsrevokelink.New(log, storage, tokenManager))
+	router.Get("/.well-known/jwks.json", jwks.New(log, keyRing))
+
+	router.Group(func(r chi.Router) {
+		r.Use(bearerauth.New(log, storage, tokenManager))
+
+		r.Post("/logout", logout.New(log, storage))
+		r.With(bearerauth.RequireScope("profile")).Get("/me", me.New(log, storage))
+
+		r.Route("/sessions", func(r chi.Router) {
+			r.Use(bearerauth.RequireScope("sessions"))
+
+			r.Get("/", sessionslist.New(log, storage))
+			r.Delete("/", sessionsrevokeall.New(log, storage))
+			r.Delete("/{id}", sessionsrevoke.New(log, storage))
+		})
+	})
+
+	router.Group(func(r chi.Router) {
+		r.Use(clientauth.New(log, clientSecrets(cfg.Clients)))
+
+		r.Post("/introspect", introspect.New(log, storage, tokenManager))
+		r.Post("/revoke", revoke.New(log, storage, tokenManager))
+	})
+
+	router.Route("/admin", func(r chi.Router) {
+		r.Use(clientauth.New(log, clientSecrets(cfg.Admins)))
+
+		r.Get("/notifications/dead", notificationslist.New(log, storage))
+		r.Post("/notifications/{id}/replay", notificationsreplay.New(log, storage))
+	})
 
 	log.Info("starting server", slog.String("address", cfg.Address))
 
 	done := make(chan os.Signal, 1)
 	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
 
+	rotate := make(chan os.Signal, 1)
+	signal.Notify(rotate, syscall.SIGHUP)
+
+	go func() {
+		for range rotate {
+			key, err := rotateSigningKey(keyRing, cfg.SigningKey)
+			if err != nil {
+				log.Error("failed to rotate signing key", sl.Err(err))
+				continue
+			}
+
+			log.Info("signing key rotated", slog.String("kid", key.ID))
+		}
+	}()
+
 	srv := &http.Server{
 		Addr:         cfg.Address,
 		Handler:      router,
@@ -72,13 +264,24 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), cfg.GracePeriod)
 	defer cancel()
 
+	// The steps after it still run, so claims are released and the storage is closed
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Error("failed to stop server", sl.Err(err))
+	}
 
-		return
+	// Handlers may queue jobs until the server stops, and the jobs enqueue notifications
+	if err := backgroundQueue.Shutdown(ctx); err != nil {
+		log.Error("failed to stop background queue", sl.Err(err))
+	}
+
+	// Handlers may enqueue until the server stops, the rest is delivered after restart
+	if err := outboxPool.Shutdown(ctx); err != nil {
+		log.Error("failed to stop outbox", sl.Err(err))
 	}
 
-	// TODO: close storage
+	if err := storage.Close(); err != nil {
+		log.Error("failed to close storage", sl.Err(err))
+	}
 
 	log.Info("server stopped")
 
@@ -107,6 +310,172 @@ func setupLogger(env string) *slog.Logger {
 
 }
 
+// clientSecrets maps ids of the configured clients to their secrets
+func clientSecrets(clients []config.Client) map[string]string {
+	secrets := make(map[string]string, len(clients))
+	for _, client := range clients {
+		secrets[client.ID] = client.Secret
+	}
+
+	return secrets
+}
+
+// setupStorage opens the storage backend selected by the driver
+func setupStorage(cfg config.Storage) (storage.Storage, error) {
+	switch cfg.Driver {
+	case "postgres":
+		return postgres.New(context.Background(), cfg.Path, postgres.Options{
+			MaxConns:          cfg.MaxConns,
+			MinConns:          cfg.MinConns,
+			MaxConnLifetime:   cfg.MaxConnLifetime,
+			MaxConnIdleTime:   cfg.MaxConnIdleTime,
+			HealthCheckPeriod: cfg.HealthCheckPeriod,
+			QueryTimeout:      cfg.QueryTimeout,
+		})
+	case "sqlite":
+		return sqlite.New(cfg.Path, cfg.QueryTimeout)
+	case "memory":
+		return memory.New(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", cfg.Driver)
+	}
+}
+
+func setupPasswordPolicy(log *slog.Logger, cfg config.Password) (*password.Policy, error) {
+	policy := &password.Policy{
+		MinLength:   cfg.MinLength,
+		MaxLength:   cfg.MaxLength,
+		MinClasses:  cfg.MinClasses,
+		ForbidEmail: cfg.ForbidEmail,
+	}
+
+	if cfg.BreachedList != "" {
+		list, err := password.LoadBreachedList(cfg.BreachedList)
+		if err != nil {
+			return nil, err
+		}
+		policy.Breached = list
+
+		log.Info("breached passwords loaded", slog.Int("count", list.Len()))
+	}
+
+	return policy, nil
+}
+
+func setupPasswordHasher(cfg config.Hashing) (*password.Hasher, error) {
+	var algorithm password.Algorithm
+
+	switch cfg.Algorithm {
+	case "argon2id":
+		algorithm = &password.Argon2id{
+			Memory:      cfg.Argon2id.Memory,
+			Iterations:  cfg.Argon2id.Iterations,
+			Parallelism: cfg.Argon2id.Parallelism,
+		}
+	case "scrypt":
+		algorithm = &password.Scrypt{N: cfg.Scrypt.N, R: cfg.Scrypt.R, P: cfg.Scrypt.P}
+	case "bcrypt":
+		algorithm = &password.Bcrypt{Cost: cfg.Bcrypt.Cost}
+	default:
+		return nil, fmt.Errorf("unknown password hashing algorithm %q", cfg.Algorithm)
+	}
+
+	// Fail on startup rather than on the first registration
+	if _, err := algorithm.Hash("check"); err != nil {
+		return nil, err
+	}
+
+	return password.NewHasher(algorithm), nil
+}
+
+func setupRefreshKey(log *slog.Logger, key string) ([]byte, error) {
+	if key == "" {
+		log.Warn("refresh token key is not set, refresh tokens won't survive restart")
+		return refreshtoken.NewKey()
+	}
+
+	if len(key) < refreshtoken.MinKeyLength {
+		return nil, refreshtoken.ErrShortKey
+	}
+
+	return []byte(key), nil
+}
+
+func setupNotifier(log *slog.Logger, cfg config.Notifications) (notifications.Notifier, error) {
+	switch cfg.Driver {
+	case "smtp":
+		return smtp.New(smtp.Options{
+			Host:     cfg.SMTP.Host,
+			Port:     cfg.SMTP.Port,
+			Username: cfg.SMTP.Username,
+			Password: cfg.SMTP.Password,
+			From:     cfg.SMTP.From,
+			TLS:      cfg.SMTP.TLS,
+			Timeout:  cfg.SMTP.Timeout,
+		})
+	case "webhook":
+		return webhook.New(webhook.Options{
+			URL:     cfg.Webhook.URL,
+			Secret:  cfg.Webhook.Secret,
+			Timeout: cfg.Webhook.Timeout,
+		})
+	case "file":
+		if cfg.File == "" {
+			return nil, fmt.Errorf("notifications file is not set")
+		}
+		return sink.NewFile(cfg.File), nil
+	case "log":
+		return sink.NewLog(log), nil
+	default:
+		return nil, fmt.Errorf("unknown notifications driver %q", cfg.Driver)
+	}
+}
+
+func setupKeyRing(log *slog.Logger, cfg config.SigningKey) (*keys.Ring, error) {
+	if cfg.Dir != "" {
+		return keys.LoadDir(cfg.Dir, cfg.Algorithm, cfg.RetireAfter)
+	}
+
+	var (
+		key *keys.Key
+		err error
+	)
+
+	if cfg.Path != "" {
+		key, err = keys.LoadOrGenerate(cfg.Path, cfg.Algorithm)
+	} else {
+		log.Warn("signing key path is not set, generating ephemeral key")
+		key, err = keys.Generate(cfg.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return keys.NewRing(key), nil
+}
+
+// rotateSigningKey makes a new key active. With a key directory the key is saved there,
+// otherwise it lives in memory only until restart
+func rotateSigningKey(ring *keys.Ring, cfg config.SigningKey) (*keys.Key, error) {
+	var (
+		key *keys.Key
+		err error
+	)
+
+	if cfg.Dir != "" {
+		key, err = keys.GenerateToDir(cfg.Dir, cfg.Algorithm)
+	} else {
+		key, err = keys.Generate(cfg.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ring.Rotate(key, cfg.RetireAfter)
+
+	return key, nil
+}
+
 func setupPrettySlog() *slog.Logger {
 	opts := slogpretty.PrettyHandlerOptions{
 		SlogOpts: &slog.HandlerOptions{
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"github.com/northwindman/testREST-autentification/internal/storage/migrate"
+	"log/slog"
+	"os"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+const migrateUsage = "usage: testREST-authentication migrate up | down [steps] | status"
+
+// migratable is a storage backend with schema migrations, the in-memory one has none
+type migratable interface {
+	Migrator() (*migrate.Migrator, error)
+}
+
+// migrateUp applies pending migrations of the storage on start
+func migrateUp(log *slog.Logger, s storage.Storage) error {
+	m, ok := s.(migratable)
+	if !ok {
+		return nil
+	}
+
+	migrator, err := m.Migrator()
+	if err != nil {
+		return err
+	}
+
+	applied, err := migrator.Up(context.Background())
+	if err != nil {
+		return err
+	}
+
+	log.Debug("migrations applied", slog.Int("count", applied))
+
+	return nil
+}
+
+// runMigrate handles the migrate subcommand: up applies all pending migrations,
+// down rolls back the given number of migrations (one by default), status prints them
+func runMigrate(log *slog.Logger, s storage.Storage, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	m, ok := s.(migratable)
+	if !ok {
+		return errors.New("storage driver has no migrations")
+	}
+
+	migrator, err := m.Migrator()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		if err != nil {
+			return err
+		}
+
+		log.Info("migrations applied", slog.Int("count", applied))
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n <= 0 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+			steps = n
+		}
+
+		rolledBack, err := migrator.Down(ctx, steps)
+		if err != nil {
+			return err
+		}
+
+		log.Info("migrations rolled back", slog.Int("count", rolledBack))
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+
+		return w.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	return nil
+}
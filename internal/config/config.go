@@ -8,9 +8,47 @@ import (
 )
 
 type Config struct {
-	Env         string `yaml:"env" env-default:"local"`
-	StoragePath string `yaml:"storage_path"`
-	HTTPServer  `yaml:"http_server"`
+	Env        string  `yaml:"env" env-default:"local"`
+	Storage    Storage `yaml:"storage"`
+	HTTPServer `yaml:"http_server"`
+	Tokens     `yaml:"tokens"`
+	Clients    []Client `yaml:"clients"`
+	// Admins may inspect and replay dead notifications
+	Admins   []Client `yaml:"admins"`
+	Password Password `yaml:"password"`
+	// EmailVerification confirms new users own their email addresses
+	EmailVerification EmailVerification `yaml:"email_verification"`
+	// Notifications are security alerts to users, e.g. a sign-in from a new IP
+	Notifications Notifications `yaml:"notifications"`
+}
+
+type EmailVerification struct {
+	// Required blocks login and refresh until the email is verified
+	Required bool `yaml:"required" env-default:"false"`
+	// TTL is how long the link of a verification email is valid
+	TTL time.Duration `yaml:"ttl" env-default:"24h"`
+	// ResendLimit of verification emails sent again to one account per ResendWindow,
+	// further requests are ignored without telling the caller
+	ResendLimit  int           `yaml:"resend_limit" env-default:"3"`
+	ResendWindow time.Duration `yaml:"resend_window" env-default:"1h"`
+}
+
+type Storage struct {
+	Driver string `yaml:"driver" env-default:"postgres"` // postgres, sqlite, memory
+	// Path is the connection string of postgres or the file of sqlite, memory needs none
+	Path string `yaml:"path"`
+	// QueryTimeout limits every query, so a slow database doesn't hold requests forever
+	QueryTimeout time.Duration `yaml:"query_timeout" env-default:"3s"`
+	Pool         `yaml:"pool"`
+}
+
+// Pool configures connections to postgres
+type Pool struct {
+	MaxConns          int32         `yaml:"max_conns" env-default:"10"`
+	MinConns          int32         `yaml:"min_conns" env-default:"0"`
+	MaxConnLifetime   time.Duration `yaml:"max_conn_lifetime" env-default:"1h"`
+	MaxConnIdleTime   time.Duration `yaml:"max_conn_idle_time" env-default:"30m"`
+	HealthCheckPeriod time.Duration `yaml:"health_check_period" env-default:"1m"`
 }
 
 type HTTPServer struct {
@@ -20,6 +58,136 @@ type HTTPServer struct {
 	GracePeriod time.Duration `yaml:"grace_period" env-default:"10s"`
 }
 
+type Tokens struct {
+	AccessTTL  time.Duration `yaml:"access_ttl" env-default:"15m"`
+	RefreshTTL time.Duration `yaml:"refresh_ttl" env-default:"720h"`
+	Issuer     string        `yaml:"issuer" env-default:"testREST-authentication"`
+	Audience   string        `yaml:"audience" env-default:"testREST-authentication"`
+	Leeway     time.Duration `yaml:"leeway" env-default:"30s"`
+	ClientID   string        `yaml:"client_id" env-default:"web"`
+	Scope      string        `yaml:"scope" env-default:"profile sessions"`
+	SigningKey `yaml:"signing_key"`
+	// RefreshKey is the HMAC key refresh tokens are stored with, at least 32 bytes.
+	// Changing it invalidates all refresh tokens, with empty key a new one is generated on every start
+	RefreshKey string `yaml:"refresh_key" env:"REFRESH_TOKEN_KEY"`
+	// ReuseGracePeriod is how long after the rotation a replay of the previous refresh token from
+	// the same IP and user agent is refused as a retry instead of revoking the session, 0 disables it
+	ReuseGracePeriod time.Duration `yaml:"reuse_grace_period" env-default:"10s"`
+}
+
+type SigningKey struct {
+	Algorithm string `yaml:"algorithm" env-default:"ES256"` // RS256, ES256, EdDSA
+	// Path to PEM encoded private key, the key is generated there if the file does not exist.
+	// With empty path and dir an ephemeral key is generated on every start
+	Path string `yaml:"path"`
+	// Dir with PEM encoded keys, the newest one signs tokens. Takes precedence over Path
+	Dir string `yaml:"dir"`
+	// RetireAfter is how long a rotated key keeps verifying tokens, must outlive AccessTTL
+	RetireAfter time.Duration `yaml:"retire_after" env-default:"24h"`
+}
+
+// Password is the policy new passwords must satisfy
+type Password struct {
+	MinLength int `yaml:"min_length" env-default:"10"`
+	// MaxLength is in bytes, bcrypt ignores everything after 72 bytes
+	MaxLength   int  `yaml:"max_length" env-default:"72"`
+	MinClasses  int  `yaml:"min_classes" env-default:"2"` // of lower, upper case letters, digits, symbols
+	ForbidEmail bool `yaml:"forbid_email" env-default:"true"`
+	// BreachedList is a file of SHA-1 hashes of breached passwords, one "HASH[:count]" per line
+	BreachedList string  `yaml:"breached_list"`
+	Hashing      Hashing `yaml:"hashing"`
+	Reset        Reset   `yaml:"reset"`
+}
+
+// Reset configures the forgot password flow
+type Reset struct {
+	// TTL is how long the link of a reset email is valid, keep it short
+	TTL time.Duration `yaml:"ttl" env-default:"30m"`
+	// Limit of reset emails to one account per Window, further requests are ignored without telling the caller
+	Limit  int           `yaml:"limit" env-default:"3"`
+	Window time.Duration `yaml:"window" env-default:"1h"`
+}
+
+// Hashing configures how passwords are stored. Hashes made with another algorithm
+// or parameters still verify and are upgraded on the next successful login
+type Hashing struct {
+	Algorithm string   `yaml:"algorithm" env-default:"argon2id"` // argon2id, scrypt, bcrypt
+	Argon2id  Argon2id `yaml:"argon2id"`
+	Scrypt    Scrypt   `yaml:"scrypt"`
+	Bcrypt    Bcrypt   `yaml:"bcrypt"`
+}
+
+type Argon2id struct {
+	Memory      uint32 `yaml:"memory" env-default:"65536"` // KiB
+	Iterations  uint32 `yaml:"iterations" env-default:"3"`
+	Parallelism uint8  `yaml:"parallelism" env-default:"2"`
+}
+
+type Scrypt struct {
+	N int `yaml:"n" env-default:"32768"` // power of two
+	R int `yaml:"r" env-default:"8"`
+	P int `yaml:"p" env-default:"1"`
+}
+
+type Bcrypt struct {
+	Cost int `yaml:"cost" env-default:"10"`
+}
+
+type Notifications struct {
+	Driver  string  `yaml:"driver" env-default:"log"` // smtp, webhook, file, log
+	SMTP    SMTP    `yaml:"smtp"`
+	Webhook Webhook `yaml:"webhook"`
+	// File is where the file driver appends messages as JSON lines
+	File   string `yaml:"file"`
+	Outbox Outbox `yaml:"outbox"`
+	Links  Links  `yaml:"links"`
+}
+
+// Links are URL patterns of links in emails, "{token}" is replaced by the token.
+// They usually lead to the frontend, which calls the API
+type Links struct {
+	// RevokeSession is the "this wasn't me" link of sign-in alerts, it is left out when empty
+	RevokeSession string `yaml:"revoke_session"`
+	// VerifyEmail is the link of email verification emails, they are not sent when it is empty
+	VerifyEmail string `yaml:"verify_email"`
+	// ResetPassword is the link of password reset emails, password reset is disabled when it is empty
+	ResetPassword string `yaml:"reset_password"`
+}
+
+// Outbox configures background delivery of notifications
+type Outbox struct {
+	Workers      int           `yaml:"workers" env-default:"4"`
+	PollInterval time.Duration `yaml:"poll_interval" env-default:"1s"`
+	MaxAttempts  int           `yaml:"max_attempts" env-default:"8"` // then the notification is dead
+	MinBackoff   time.Duration `yaml:"min_backoff" env-default:"30s"`
+	MaxBackoff   time.Duration `yaml:"max_backoff" env-default:"1h"`
+	// Lease must outlive a delivery, otherwise a slow notification may be claimed and sent again
+	Lease time.Duration `yaml:"lease" env-default:"1m"`
+}
+
+type SMTP struct {
+	Host     string        `yaml:"host"`
+	Port     int           `yaml:"port" env-default:"587"`
+	Username string        `yaml:"username"`
+	Password string        `yaml:"password" env:"SMTP_PASSWORD"`
+	From     string        `yaml:"from"`
+	TLS      string        `yaml:"tls" env-default:"starttls"` // starttls, implicit, none
+	Timeout  time.Duration `yaml:"timeout" env-default:"10s"`
+}
+
+type Webhook struct {
+	URL string `yaml:"url"`
+	// Secret signs requests with HMAC-SHA256 when set
+	Secret  string        `yaml:"secret" env:"WEBHOOK_SECRET"`
+	Timeout time.Duration `yaml:"timeout" env-default:"5s"`
+}
+
+// Client is an OAuth client, e.g. API gateway, allowed to introspect and revoke tokens
+type Client struct {
+	ID     string `yaml:"id"`
+	Secret string `yaml:"secret"`
+}
+
 func MustLoad() *Config {
 	configPath := os.Getenv("CONFIG_PATH")
 	if configPath == "" {
@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// Statuses of a notification in the outbox
+const (
+	NotificationPending = "pending"
+	// NotificationDead ran out of attempts or failed permanently, it waits for a replay
+	NotificationDead = "dead"
+)
+
+// Notification is a message to the user waiting in the outbox for delivery.
+// Delivered notifications are deleted, so only pending and dead ones are stored
+type Notification struct {
+	ID            int64
+	To            string
+	Subject       string
+	Body          string
+	HTML          string
+	Status        string
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+}
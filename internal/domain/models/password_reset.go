@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// PasswordReset is a pending request to reset the password of the user.
+// Only the hash of its token is stored, the token itself is sent to the user's email
+type PasswordReset struct {
+	TokenHash []byte
+	UID       int64
+	CreatedAt time.Time
+	ExpiresAt time.Time
+}
@@ -0,0 +1,17 @@
+package models
+
+import "time"
+
+// Session is a single signed in device of the user with its own refresh token.
+// Every session is a family of refresh tokens, each of them rotated from the previous one
+type Session struct {
+	ID          string
+	UID         int64
+	RefreshHash []byte
+	IP          string
+	UserAgent   string
+	CreatedAt   time.Time
+	LastUsedAt  time.Time
+	ExpiresAt   time.Time
+	RevokedAt   *time.Time
+}
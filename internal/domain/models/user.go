@@ -5,6 +5,8 @@ type User struct {
 	IP       string
 	Email    string
 	PassHash []byte
-	Secret   string
-	Token
+	// Locale is the language of emails to the user, empty for the default one
+	Locale string
+	// EmailVerified is set once the user opens the link of the verification email
+	EmailVerified bool
 }
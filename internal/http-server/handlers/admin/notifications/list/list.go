@@ -0,0 +1,86 @@
+package list
+
+import (
+	"context"
+	"github.com/go-chi/render"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultLimit = 50
+	maxLimit     = 500
+)
+
+// Notification leaves out the body, it may carry links meant for the user only
+type Notification struct {
+	ID        int64     `json:"id"`
+	To        string    `json:"to"`
+	Subject   string    `json:"subject"`
+	Attempts  int       `json:"attempts"`
+	LastError string    `json:"last_error"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Response struct {
+	resp.Response
+	Notifications []Notification `json:"notifications"`
+}
+
+type NotificationProvider interface {
+	ListDeadNotifications(ctx context.Context, limit int) ([]models.Notification, error)
+}
+
+// New returns handler which lists dead notifications, recently created first. The number is limited by ?limit=
+func New(log *slog.Logger, notificationProvider NotificationProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.admin.notifications.list.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		limit := defaultLimit
+		if raw := r.URL.Query().Get("limit"); raw != "" {
+			var err error
+			limit, err = strconv.Atoi(raw)
+			if err != nil || limit < 1 || limit > maxLimit {
+				log.Warn("invalid limit", slog.String("limit", raw))
+				resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest,
+					"limit must be from 1 to "+strconv.Itoa(maxLimit)))
+				return
+			}
+		}
+
+		notifications, err := notificationProvider.ListDeadNotifications(r.Context(), limit)
+		if err != nil {
+			log.Error("failed to list dead notifications", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		}
+
+		response := Response{
+			Response:      resp.OK(),
+			Notifications: make([]Notification, 0, len(notifications)),
+		}
+		for _, notification := range notifications {
+			response.Notifications = append(response.Notifications, Notification{
+				ID:        notification.ID,
+				To:        notification.To,
+				Subject:   notification.Subject,
+				Attempts:  notification.Attempts,
+				LastError: notification.LastError,
+				CreatedAt: notification.CreatedAt,
+			})
+		}
+
+		log.Info("dead notifications listed", slog.Int("notifications", len(notifications)))
+
+		render.JSON(w, r, response)
+	}
+}
@@ -0,0 +1,55 @@
+package replay
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+type NotificationReplayer interface {
+	ReplayNotification(ctx context.Context, id int64) error
+}
+
+// New returns handler which puts the dead notification with the id from the URL back to delivery
+func New(log *slog.Logger, notificationReplayer NotificationReplayer) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.admin.notifications.replay.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		id, err := strconv.ParseInt(chi.URLParam(r, "id"), 10, 64)
+		if err != nil {
+			log.Warn("invalid notification id", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "invalid notification id"))
+			return
+		}
+
+		if err = notificationReplayer.ReplayNotification(r.Context(), id); err != nil {
+			switch {
+			case errors.Is(err, storage.ErrNotificationNotFound):
+				log.Warn("notification not found", slog.Int64("notification_id", id))
+				resp.WriteProblem(w, r, resp.NewProblem(http.StatusNotFound, resp.CodeNotFound, "notification not found"))
+			case errors.Is(err, storage.ErrNotificationNotDead):
+				log.Warn("notification is not dead", slog.Int64("notification_id", id))
+				resp.WriteProblem(w, r, resp.NewProblem(http.StatusConflict, resp.CodeConflict, "notification is not dead"))
+			default:
+				log.Error("failed to replay notification", sl.Err(err))
+				resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			}
+			return
+		}
+
+		log.Info("notification replayed", slog.Int64("notification_id", id))
+
+		render.JSON(w, r, resp.OK())
+	}
+}
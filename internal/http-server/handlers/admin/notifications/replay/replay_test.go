@@ -0,0 +1,52 @@
+package replay
+
+import (
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	dead, err := s.EnqueueNotification(ctx, models.Notification{To: "user@example.com", Subject: "subject", Body: "body"})
+	require.NoError(t, err)
+	_, err = s.ClaimNotifications(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, s.DeadLetterNotification(ctx, dead, "550 mailbox unavailable"))
+
+	pending, err := s.EnqueueNotification(ctx, models.Notification{To: "user@example.com", Subject: "subject", Body: "body"})
+	require.NoError(t, err)
+
+	router := chi.NewRouter()
+	router.Post("/notifications/{id}/replay", New(slog.New(slog.NewTextHandler(io.Discard, nil)), s))
+
+	replay := func(id string) int {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/notifications/"+id+"/replay", nil))
+
+		return w.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, replay("abc"))
+	assert.Equal(t, http.StatusNotFound, replay("999"))
+	assert.Equal(t, http.StatusConflict, replay(strconv.FormatInt(pending, 10)))
+
+	assert.Equal(t, http.StatusOK, replay(strconv.FormatInt(dead, 10)))
+	assert.Equal(t, http.StatusConflict, replay(strconv.FormatInt(dead, 10)), "already replayed")
+
+	left, err := s.ListDeadNotifications(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, left)
+}
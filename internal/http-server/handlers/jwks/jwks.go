@@ -0,0 +1,30 @@
+package jwks
+
+import (
+	"github.com/go-chi/render"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/keys"
+	"log/slog"
+	"net/http"
+)
+
+type KeySet interface {
+	JWKS() keys.JWKS
+}
+
+// New returns handler which serves public keys for offline verification of access tokens
+func New(log *slog.Logger, keySet KeySet) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.jwks.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		jwks := keySet.JWKS()
+
+		log.Debug("serving jwks", slog.Int("keys", len(jwks.Keys)))
+
+		w.Header().Set("Cache-Control", "public, max-age=300")
+		render.JSON(w, r, jwks)
+	}
+}
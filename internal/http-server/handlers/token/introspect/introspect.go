@@ -0,0 +1,160 @@
+package introspect
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/render"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/http-server/middleware/clientauth"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Response is the introspection response (RFC 7662, section 2.2)
+type Response struct {
+	Active    bool     `json:"active"`
+	Scope     string   `json:"scope,omitempty"`
+	ClientID  string   `json:"client_id,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	TokenType string   `json:"token_type,omitempty"`
+	Exp       int64    `json:"exp,omitempty"`
+	Iat       int64    `json:"iat,omitempty"`
+	Nbf       int64    `json:"nbf,omitempty"`
+	Sub       string   `json:"sub,omitempty"`
+	Aud       []string `json:"aud,omitempty"`
+	Iss       string   `json:"iss,omitempty"`
+	Jti       string   `json:"jti,omitempty"`
+	Email     string   `json:"email,omitempty"`
+	SessionID string   `json:"sid,omitempty"`
+}
+
+type SessionProvider interface {
+	tokens.SessionChecker
+	GetSessionByRefreshHash(ctx context.Context, hash []byte) (models.Session, error)
+	GetUserByID(ctx context.Context, uid int64) (models.User, error)
+}
+
+// New returns handler which tells authenticated clients if the token is active and whom it belongs to.
+// Access and refresh tokens are told apart by their format, so token_type_hint is ignored
+func New(log *slog.Logger, sessionProvider SessionProvider, tokenManager *tokens.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.token.introspect.New"
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("client_id", clientauth.ClientID(r.Context())),
+		)
+
+		token := r.PostFormValue("token")
+		if token == "" {
+			log.Error("token is empty")
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.OAuthError{Error: resp.OAuthInvalidRequest, ErrorDescription: "token is required"})
+			return
+		}
+
+		if !tokens.IsAccessToken(token) {
+			introspectRefresh(w, r, log, sessionProvider, tokenManager, token)
+			return
+		}
+
+		claims, err := tokenManager.Verify(r.Context(), token, sessionProvider)
+		if err != nil {
+			if tokens.IsInactive(err) {
+				log.Info("token is not active", sl.Err(err))
+				render.JSON(w, r, Response{Active: false})
+				return
+			}
+
+			log.Error("failed to verify token", sl.Err(err))
+			render.Status(r, resp.StorageStatus(err))
+			render.JSON(w, r, resp.OAuthError{Error: resp.OAuthServerError})
+			return
+		}
+
+		log.Info("token is active", slog.String("session", claims.SessionID))
+
+		render.JSON(w, r, Response{
+			Active:    true,
+			Scope:     claims.Scope,
+			ClientID:  claims.ClientID,
+			Username:  claims.Email,
+			TokenType: "Bearer",
+			Exp:       unix(claims.ExpiresAt),
+			Iat:       unix(claims.IssuedAt),
+			Nbf:       unix(claims.NotBefore),
+			Sub:       claims.Subject,
+			Aud:       claims.Audience,
+			Iss:       claims.Issuer,
+			Jti:       claims.ID,
+			Email:     claims.Email,
+			SessionID: claims.SessionID,
+		})
+	}
+}
+
+// introspectRefresh describes the refresh token by its session, rotated and revoked tokens aren't active
+func introspectRefresh(w http.ResponseWriter, r *http.Request, log *slog.Logger, sessionProvider SessionProvider, tokenManager *tokens.Manager, token string) {
+	session, err := sessionProvider.GetSessionByRefreshHash(r.Context(), tokenManager.HashRefreshToken(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			log.Info("refresh token is not active")
+			render.JSON(w, r, Response{Active: false})
+			return
+		}
+
+		log.Error("failed to get session", sl.Err(err))
+		render.Status(r, resp.StorageStatus(err))
+		render.JSON(w, r, resp.OAuthError{Error: resp.OAuthServerError})
+		return
+	}
+
+	if time.Now().After(session.ExpiresAt) {
+		log.Info("refresh token expired", slog.String("session", session.ID))
+		render.JSON(w, r, Response{Active: false})
+		return
+	}
+
+	user, err := sessionProvider.GetUserByID(r.Context(), session.UID)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			log.Info("user of refresh token not found", slog.String("session", session.ID))
+			render.JSON(w, r, Response{Active: false})
+			return
+		}
+
+		log.Error("failed to get user", sl.Err(err))
+		render.Status(r, resp.StorageStatus(err))
+		render.JSON(w, r, resp.OAuthError{Error: resp.OAuthServerError})
+		return
+	}
+
+	log.Info("refresh token is active", slog.String("session", session.ID))
+
+	render.JSON(w, r, Response{
+		Active:    true,
+		ClientID:  tokenManager.Access.ClientID,
+		Username:  user.Email,
+		TokenType: "refresh_token",
+		Exp:       session.ExpiresAt.Unix(),
+		Iat:       session.LastUsedAt.Unix(),
+		Sub:       strconv.FormatInt(session.UID, 10),
+		Email:     user.Email,
+		SessionID: session.ID,
+	})
+}
+
+func unix(date *jwt.NumericDate) int64 {
+	if date == nil {
+		return 0
+	}
+
+	return date.Unix()
+}
@@ -0,0 +1,107 @@
+package introspect
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, manager)
+
+	introspect := func(token string) Response {
+		form := url.Values{"token": {token}}
+		r := httptest.NewRequest(http.MethodPost, "/introspect", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		require.Equal(t, http.StatusOK, w.Code)
+
+		var response Response
+		require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+
+		return response
+	}
+
+	// Managers sharing the key ring issue tokens which differ in the options only
+	expiring, foreign := *manager, *manager
+	expiring.Access.TTL = -time.Hour
+	foreign.Access.Audience = "another service"
+
+	session, token, err := manager.NewSession(user, tokenstest.IP, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, session))
+
+	active := introspect(token.AccessToken)
+	assert.True(t, active.Active)
+	assert.Equal(t, strconv.FormatInt(user.UID, 10), active.Sub)
+	assert.Equal(t, user.Email, active.Username)
+	assert.Equal(t, session.ID, active.SessionID)
+	assert.Equal(t, "web", active.ClientID)
+
+	refresh := introspect(token.RefreshToken)
+	assert.True(t, refresh.Active)
+	assert.Equal(t, "refresh_token", refresh.TokenType)
+	assert.Equal(t, session.ID, refresh.SessionID)
+
+	tests := []struct {
+		name  string
+		token func(t *testing.T) string
+	}{
+		{"malformed", func(t *testing.T) string { return "not.a.token" }},
+		{"unknown refresh token", func(t *testing.T) string { return "unknown" }},
+		{"expired", func(t *testing.T) string {
+			session, token, err := expiring.NewSession(user, tokenstest.IP, "test")
+			require.NoError(t, err)
+			require.NoError(t, s.SaveSession(ctx, session))
+
+			return token.AccessToken
+		}},
+		{"issued for another audience", func(t *testing.T) string {
+			session, token, err := foreign.NewSession(user, tokenstest.IP, "test")
+			require.NoError(t, err)
+			require.NoError(t, s.SaveSession(ctx, session))
+
+			return token.AccessToken
+		}},
+		{"access token of revoked session", func(t *testing.T) string {
+			session, token, err := manager.NewSession(user, tokenstest.IP, "test")
+			require.NoError(t, err)
+			require.NoError(t, s.SaveSession(ctx, session))
+			require.NoError(t, s.RevokeSession(ctx, session.ID))
+
+			return token.AccessToken
+		}},
+		{"refresh token of revoked session", func(t *testing.T) string {
+			session, token, err := manager.NewSession(user, tokenstest.IP, "test")
+			require.NoError(t, err)
+			require.NoError(t, s.SaveSession(ctx, session))
+			require.NoError(t, s.RevokeSession(ctx, session.ID))
+
+			return token.RefreshToken
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response := introspect(tt.token(t))
+			assert.Equal(t, Response{Active: false}, response, "inactive tokens are described by nothing else")
+		})
+	}
+}
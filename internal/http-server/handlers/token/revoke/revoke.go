@@ -0,0 +1,121 @@
+package revoke
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/render"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/http-server/middleware/clientauth"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
+	myjwt "github.com/northwindman/testREST-autentification/internal/lib/tokens/jwt"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type TokenRevoker interface {
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeSession(ctx context.Context, id string) error
+	GetSessionByRefreshHash(ctx context.Context, hash []byte) (models.Session, error)
+}
+
+// New returns handler which revokes tokens for authenticated clients (RFC 7009).
+// Revoking either token of a pair revokes its whole session, so neither can be used anymore.
+// Clients may revoke only tokens issued to them, tokens of others are left as they are.
+// Access and refresh tokens are told apart by their format, so token_type_hint is ignored
+func New(log *slog.Logger, tokenRevoker TokenRevoker, tokenManager *tokens.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.token.revoke.New"
+
+		clientID := clientauth.ClientID(r.Context())
+
+		log := log.With(
+			slog.String("op", op),
+			slog.String("client_id", clientID),
+		)
+
+		token := r.PostFormValue("token")
+		if token == "" {
+			log.Error("token is empty")
+			render.Status(r, http.StatusBadRequest)
+			render.JSON(w, r, resp.OAuthError{Error: resp.OAuthInvalidRequest, ErrorDescription: "token is required"})
+			return
+		}
+
+		if !tokens.IsAccessToken(token) {
+			revokeRefresh(w, r, log, tokenRevoker, tokenManager, clientID, token)
+			return
+		}
+
+		// Invalid tokens do not cause an error response, there is just nothing to revoke
+		claims, err := myjwt.ParseToken(token, tokenManager.Keys, tokenManager.Access)
+		if err != nil {
+			log.Info("token is not valid", sl.Err(err))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		// The response is the same as for a revoked token, so it tells nothing about tokens of others
+		if claims.ClientID != clientID {
+			log.Warn("token was issued to another client", slog.String("issued_to", claims.ClientID))
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		if err = tokenRevoker.RevokeToken(r.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+			log.Error("failed to revoke token", sl.Err(err))
+			render.Status(r, resp.StorageStatus(err))
+			render.JSON(w, r, resp.OAuthError{Error: resp.OAuthServerError})
+			return
+		}
+
+		if err = tokenRevoker.RevokeSession(r.Context(), claims.SessionID); err != nil {
+			log.Error("failed to revoke session", sl.Err(err))
+			render.Status(r, resp.StorageStatus(err))
+			render.JSON(w, r, resp.OAuthError{Error: resp.OAuthServerError})
+			return
+		}
+
+		log.Info("token revoked", slog.String("session", claims.SessionID))
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// revokeRefresh revokes the session of the refresh token, unknown tokens are already not active.
+// Sessions are opened for the client of access tokens only, so refresh tokens are issued to it
+func revokeRefresh(w http.ResponseWriter, r *http.Request, log *slog.Logger, tokenRevoker TokenRevoker, tokenManager *tokens.Manager, clientID string, token string) {
+	if issuedTo := tokenManager.Access.ClientID; issuedTo != clientID {
+		log.Warn("refresh token was issued to another client", slog.String("issued_to", issuedTo))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	session, err := tokenRevoker.GetSessionByRefreshHash(r.Context(), tokenManager.HashRefreshToken(token))
+	if err != nil {
+		if errors.Is(err, storage.ErrSessionNotFound) {
+			log.Info("refresh token is not active")
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+
+		log.Error("failed to get session", sl.Err(err))
+		render.Status(r, resp.StorageStatus(err))
+		render.JSON(w, r, resp.OAuthError{Error: resp.OAuthServerError})
+		return
+	}
+
+	if err = tokenRevoker.RevokeSession(r.Context(), session.ID); err != nil {
+		log.Error("failed to revoke session", sl.Err(err))
+		render.Status(r, resp.StorageStatus(err))
+		render.JSON(w, r, resp.OAuthError{Error: resp.OAuthServerError})
+		return
+	}
+
+	log.Info("refresh token revoked", slog.String("session", session.ID))
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,123 @@
+package revoke
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/http-server/middleware/clientauth"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"github.com/northwindman/testREST-autentification/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNew_ClientMismatch(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := clientauth.New(log, map[string]string{
+		"web":     "web secret",
+		"gateway": "gateway secret",
+	})(New(log, s, manager))
+
+	revoke := func(clientID string, token string) int {
+		form := url.Values{"token": {token}}
+		r := httptest.NewRequest(http.MethodPost, "/revoke", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.SetBasicAuth(clientID, clientID+" secret")
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w.Code
+	}
+
+	tests := []struct {
+		name  string
+		token func(models.Token) string
+	}{
+		{"access token", func(token models.Token) string { return token.AccessToken }},
+		{"refresh token", func(token models.Token) string { return token.RefreshToken }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session, token, err := manager.NewSession(user, tokenstest.IP, "test")
+			require.NoError(t, err)
+			require.NoError(t, s.SaveSession(ctx, session))
+
+			assert.Equal(t, http.StatusOK, revoke("gateway", tt.token(token)), "the response tells nothing")
+
+			got, err := s.GetSession(ctx, session.ID)
+			require.NoError(t, err)
+			assert.Nil(t, got.RevokedAt, "tokens of other clients are not revoked")
+
+			assert.Equal(t, http.StatusOK, revoke("web", tt.token(token)))
+
+			got, err = s.GetSession(ctx, session.ID)
+			require.NoError(t, err)
+			assert.NotNil(t, got.RevokedAt)
+		})
+	}
+}
+
+// failingRevoker fails every revocation with err
+type failingRevoker struct {
+	*memory.Storage
+	err error
+}
+
+func (f failingRevoker) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	return f.err
+}
+
+func (f failingRevoker) GetSessionByRefreshHash(ctx context.Context, hash []byte) (models.Session, error) {
+	return models.Session{}, f.err
+}
+
+func TestNew_StorageError(t *testing.T) {
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	_, token, err := manager.NewSession(user, tokenstest.IP, "test")
+	require.NoError(t, err)
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	tests := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{"transient", fmt.Errorf("timeout: %w", storage.ErrTransient), http.StatusServiceUnavailable},
+		{"unknown", errors.New("boom"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		handler := clientauth.New(log, map[string]string{"web": "web secret"})(New(log, failingRevoker{Storage: s, err: tt.err}, manager))
+
+		for _, raw := range []string{token.AccessToken, token.RefreshToken} {
+			form := url.Values{"token": {raw}}
+			r := httptest.NewRequest(http.MethodPost, "/revoke", strings.NewReader(form.Encode()))
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			r.SetBasicAuth("web", "web secret")
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			assert.Equal(t, tt.want, w.Code, tt.name)
+		}
+	}
+}
@@ -1,12 +1,13 @@
 package auth
 
 import (
+	"context"
 	"errors"
 	"github.com/go-chi/render"
 	"github.com/go-playground/validator/v10"
-	"github.com/northwindman/testREST-autentification/internal/lib/format"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
 	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
-	"github.com/northwindman/testREST-autentification/internal/lib/random"
+	"github.com/northwindman/testREST-autentification/internal/lib/password"
 	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
 	"github.com/northwindman/testREST-autentification/internal/storage"
 	"io"
@@ -20,23 +21,60 @@ import (
 type Request struct {
 	Email    string `json:"email" validate:"required,email"`
 	Password string `json:"password" validate:"required"`
+	// Locale of emails to the user, the Accept-Language header is used when it is empty
+	Locale string `json:"locale,omitempty"`
 }
 
 type Response struct {
 	resp.Response
-	AccessToken  string `json:"access_token"`
-	RefreshToken string `json:"refresh_token"`
+	AccessToken  string `json:"access_token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// VerificationRequired means no tokens are issued until the email is verified
+	VerificationRequired bool `json:"verification_required,omitempty"`
 }
 
 type UserSaver interface {
-	SaveUser(ip string, email string, passHash []byte, secret string, refreshToken []byte) (int64, error)
+	SaveUser(ctx context.Context, ip string, email string, passHash []byte, locale string) (int64, error)
+	SaveSession(ctx context.Context, session models.Session) error
+	EnqueueNotification(ctx context.Context, notification models.Notification) (int64, error)
 }
 
-func New(log *slog.Logger, userSaver UserSaver) http.HandlerFunc {
+// PasswordPolicy checks if the password is strong enough for the user with the email
+type PasswordPolicy interface {
+	Check(password string, email string) []password.Violation
+}
+
+// PasswordHasher hashes passwords for storage
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+}
+
+// LocaleMatcher picks the supported locale of emails closest to the preferences of the user
+type LocaleMatcher interface {
+	MatchLocale(preferences string) string
+}
+
+// VerificationMailer renders the email verification email of the user
+type VerificationMailer interface {
+	Notification(user models.User) (models.Notification, error)
+}
+
+// New returns handler which registers the user and sends the email verification link. When verified
+// emails are required, no session is opened until the link is opened
+func New(
+	log *slog.Logger,
+	userSaver UserSaver,
+	tokenManager *tokens.Manager,
+	passwordPolicy PasswordPolicy,
+	passwordHasher PasswordHasher,
+	localeMatcher LocaleMatcher,
+	verificationMailer VerificationMailer,
+	requireVerifiedEmail bool,
+) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.user.auth.New"
 
-		log.With(
+		log := log.With(
 			slog.String("op", op),
 		)
 
@@ -44,12 +82,12 @@ func New(log *slog.Logger, userSaver UserSaver) http.HandlerFunc {
 		err := render.DecodeJSON(r.Body, &req)
 		if errors.Is(err, io.EOF) {
 			log.Error("request body is empty", sl.Err(err))
-			render.JSON(w, r, resp.Error("empty request"))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "empty request"))
 			return
 		}
 		if err != nil {
 			log.Error("failed to parse request body", sl.Err(err))
-			render.JSON(w, r, resp.Error("failed to parse request"))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "failed to parse request"))
 			return
 		}
 
@@ -59,66 +97,83 @@ func New(log *slog.Logger, userSaver UserSaver) http.HandlerFunc {
 			var validateErr validator.ValidationErrors
 			if errors.As(err, &validateErr) {
 				log.Error("invalid request", sl.Err(err))
-				render.JSON(w, r, resp.ValidationError(validateErr))
+				resp.WriteProblem(w, r, resp.ValidationProblem(validateErr))
 			} else {
 				log.Error("unexpected error", sl.Err(err))
-				render.JSON(w, r, resp.Error("internal server error"))
+				resp.WriteProblem(w, r, resp.NewProblem(http.StatusInternalServerError, resp.CodeInternal, "internal server error"))
 			}
 			return
 		}
 
-		secret, err := random.NewSecret(random.SecretLength)
-		if err != nil {
-			log.Error("failed to generate secret", sl.Err(err))
-			render.JSON(w, r, resp.Error("failed to generate secret"))
+		if violations := passwordPolicy.Check(req.Password, req.Email); len(violations) > 0 {
+			log.Warn("password violates the policy", slog.Int("violations", len(violations)))
+			resp.WriteProblem(w, r, resp.PasswordProblem(violations))
 			return
 		}
 
 		ip, _, err := net.SplitHostPort(r.RemoteAddr)
 		if err != nil {
 			log.Error("failed to parse remote address", sl.Err(err))
-			render.JSON(w, r, resp.Error("failed to parse remote address"))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusInternalServerError, resp.CodeInternal, "failed to parse remote address"))
 			return
 		}
 
-		token, err := tokens.GenTokens(ip, req.Email, secret, tokens.AccessTokenLength)
+		passHash, err := passwordHasher.Hash(req.Password)
 		if err != nil {
-			log.Error("failed to generate token", sl.Err(err))
-			render.JSON(w, r, resp.Error("failed to generate token"))
+			log.Error("failed to hash password", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusInternalServerError, resp.CodeInternal, "failed to hash password"))
 			return
 		}
 
-		log.Info("generated token")
+		preferences := req.Locale
+		if preferences == "" {
+			preferences = r.Header.Get("Accept-Language")
+		}
 
-		tokenHash, err := format.HashString(token.RefreshToken)
+		locale := localeMatcher.MatchLocale(preferences)
+
+		id, err := userSaver.SaveUser(r.Context(), ip, req.Email, []byte(passHash), locale)
+		if errors.Is(err, storage.ErrAlreadyExist) {
+			log.Warn("user already exists", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusConflict, resp.CodeUserExists, "user already exists"))
+			return
+		}
 		if err != nil {
-			log.Error("failed to hash token", sl.Err(err))
-			render.JSON(w, r, resp.Error("failed to hash token"))
+			log.Error("failed to save user", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "failed to save user"))
 			return
 		}
 
-		token.RefreshToken = format.InBase64(token.RefreshToken)
+		log.Info("user saved", slog.Int64("user", id))
 
-		passHash, err := format.HashString(req.Password)
-		if err != nil {
-			log.Error("failed to hash password", sl.Err(err))
-			render.JSON(w, r, resp.Error("failed to hash password"))
-			return
+		user := models.User{UID: id, IP: ip, Email: req.Email, Locale: locale}
+
+		// The user is registered anyway, a lost email can be sent again
+		if notification, err := verificationMailer.Notification(user); err != nil {
+			log.Error("failed to render verification email", slog.Int64("user", id), sl.Err(err))
+		} else if _, err = userSaver.EnqueueNotification(r.Context(), notification); err != nil {
+			log.Error("failed to enqueue verification email", slog.Int64("user", id), sl.Err(err))
 		}
 
-		id, err := userSaver.SaveUser(ip, req.Email, passHash, secret, tokenHash)
-		if errors.Is(err, storage.ErrAlreadyExist) {
-			log.Warn("user already exists", sl.Err(err))
-			render.JSON(w, r, resp.Error("user already exists"))
+		if requireVerifiedEmail {
+			render.JSON(w, r, Response{Response: resp.OK(), VerificationRequired: true})
 			return
 		}
+
+		session, token, err := tokenManager.NewSession(user, ip, r.UserAgent())
 		if err != nil {
-			log.Error("failed to save user", sl.Err(err))
-			render.JSON(w, r, resp.Error("failed to save user"))
+			log.Error("failed to generate token", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusInternalServerError, resp.CodeInternal, "failed to generate token"))
 			return
 		}
 
-		log.Info("user saved", slog.Int64("user", id))
+		log.Info("generated token")
+
+		if err = userSaver.SaveSession(r.Context(), session); err != nil {
+			log.Error("failed to save session", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "failed to save session"))
+			return
+		}
 
 		responseOK(w, r, token.AccessToken, token.RefreshToken)
 	}
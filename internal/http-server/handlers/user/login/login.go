@@ -0,0 +1,173 @@
+package login
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+type Request struct {
+	Email    string `json:"email" validate:"required,email"`
+	Password string `json:"password" validate:"required"`
+}
+
+type Response struct {
+	resp.Response
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+type UserProvider interface {
+	GetUser(ctx context.Context, email string) (models.User, error)
+	SaveSession(ctx context.Context, session models.Session) error
+	UpdatePassword(ctx context.Context, uid int64, passHash []byte) error
+}
+
+// PasswordHasher verifies passwords and tells which stored hashes are outdated
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+	Verify(password string, encoded string) (bool, error)
+	NeedsRehash(encoded string) bool
+}
+
+// New returns handler which checks user's password and opens a new session with its own pair of tokens.
+// Password hashes made with outdated algorithm or parameters are replaced on the way
+func New(log *slog.Logger, userProvider UserProvider, tokenManager *tokens.Manager, passwordHasher PasswordHasher, requireVerifiedEmail bool) http.HandlerFunc {
+	// Passwords of unknown emails are checked against this hash, so they take as long to reject
+	// as wrong passwords and the response time doesn't tell which emails are registered
+	dummyHash, err := passwordHasher.Hash("password of unknown users")
+	if err != nil {
+		log.Error("failed to hash dummy password", sl.Err(err))
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.login.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		var req Request
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "empty request"))
+			return
+		}
+		if err != nil {
+			log.Error("failed to parse request body", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "failed to parse request"))
+			return
+		}
+
+		log.Info("request body decoded")
+
+		if err = validator.New().Struct(req); err != nil {
+			var validateErr validator.ValidationErrors
+			if errors.As(err, &validateErr) {
+				log.Error("invalid request", sl.Err(err))
+				resp.WriteProblem(w, r, resp.ValidationProblem(validateErr))
+			} else {
+				log.Error("unexpected error", sl.Err(err))
+				resp.WriteProblem(w, r, resp.NewProblem(http.StatusInternalServerError, resp.CodeInternal, "internal server error"))
+			}
+			return
+		}
+
+		user, err := userProvider.GetUser(r.Context(), req.Email)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				log.Warn("user not found", sl.Err(err))
+				_, _ = passwordHasher.Verify(req.Password, dummyHash)
+				resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeInvalidCredentials, "invalid credentials"))
+				return
+			}
+
+			log.Error("failed to get user", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "failed to get user"))
+			return
+		}
+
+		ok, err := passwordHasher.Verify(req.Password, string(user.PassHash))
+		if err != nil {
+			log.Error("failed to verify password", slog.Int64("user", user.UID), sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusInternalServerError, resp.CodeInternal, "internal error"))
+			return
+		}
+		if !ok {
+			log.Warn("invalid password", slog.Int64("user", user.UID))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeInvalidCredentials, "invalid credentials"))
+			return
+		}
+
+		// Checked after the password, so it tells nothing about the account to strangers
+		if requireVerifiedEmail && !user.EmailVerified {
+			log.Warn("email is not verified", slog.Int64("user", user.UID))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusForbidden, resp.CodeEmailNotVerified, "email is not verified"))
+			return
+		}
+
+		if passwordHasher.NeedsRehash(string(user.PassHash)) {
+			// The login must not fail because of the upgrade, it is retried on the next one
+			rehash(r.Context(), log, userProvider, passwordHasher, user.UID, req.Password)
+		}
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			log.Error("failed to parse remote address", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusInternalServerError, resp.CodeInternal, "failed to parse remote address"))
+			return
+		}
+
+		session, token, err := tokenManager.NewSession(user, ip, r.UserAgent())
+		if err != nil {
+			log.Error("failed to generate tokens", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusInternalServerError, resp.CodeInternal, "internal error"))
+			return
+		}
+
+		if err = userProvider.SaveSession(r.Context(), session); err != nil {
+			log.Error("failed to save session", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		}
+
+		log.Info("user logged in", slog.Int64("user", user.UID))
+
+		responseOK(w, r, token.AccessToken, token.RefreshToken)
+	}
+}
+
+// rehash replaces the stored hash of the password with one made by the current algorithm and parameters
+func rehash(ctx context.Context, log *slog.Logger, userProvider UserProvider, passwordHasher PasswordHasher, uid int64, password string) {
+	passHash, err := passwordHasher.Hash(password)
+	if err != nil {
+		log.Error("failed to rehash password", slog.Int64("user", uid), sl.Err(err))
+		return
+	}
+
+	if err = userProvider.UpdatePassword(ctx, uid, []byte(passHash)); err != nil {
+		log.Error("failed to update password hash", slog.Int64("user", uid), sl.Err(err))
+		return
+	}
+
+	log.Info("password hash upgraded", slog.Int64("user", uid))
+}
+
+func responseOK(w http.ResponseWriter, r *http.Request, acToken string, rfToken string) {
+	render.JSON(w, r, Response{
+		Response:     resp.OK(),
+		AccessToken:  acToken,
+		RefreshToken: rfToken,
+	})
+}
@@ -0,0 +1,135 @@
+package login
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/northwindman/testREST-autentification/internal/lib/password"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNew_Rehash(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+
+	// The user registered when passwords were hashed with bcrypt
+	oldHash, err := (&password.Bcrypt{Cost: bcrypt.MinCost}).Hash("correct horse")
+	require.NoError(t, err)
+	s, user := tokenstest.NewStorage(t, []byte(oldHash))
+
+	hasher := password.NewHasher(&password.Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1})
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, manager, hasher, false)
+
+	login := func(pass string) int {
+		body, err := json.Marshal(Request{Email: "test@example.com", Password: pass})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body)))
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, login("wrong horse"))
+
+	user, err = s.GetUserByID(ctx, user.UID)
+	require.NoError(t, err)
+	assert.Equal(t, oldHash, string(user.PassHash), "a failed login must not touch the hash")
+
+	assert.Equal(t, http.StatusOK, login("correct horse"))
+
+	user, err = s.GetUserByID(ctx, user.UID)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(user.PassHash), "$argon2id$"))
+	assert.False(t, hasher.NeedsRehash(string(user.PassHash)))
+
+	newHash := string(user.PassHash)
+
+	assert.Equal(t, http.StatusOK, login("correct horse"))
+
+	user, err = s.GetUserByID(ctx, user.UID)
+	require.NoError(t, err)
+	assert.Equal(t, newHash, string(user.PassHash), "an up to date hash is kept")
+}
+
+func TestNew_RequireVerifiedEmail(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+
+	hasher := password.NewHasher(&password.Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1})
+	passHash, err := hasher.Hash("correct horse")
+	require.NoError(t, err)
+	s, user := tokenstest.NewStorage(t, []byte(passHash))
+
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, manager, hasher, true)
+
+	login := func(pass string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(Request{Email: "test@example.com", Password: pass})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body)))
+
+		return rec
+	}
+
+	assert.Equal(t, http.StatusUnauthorized, login("wrong horse").Code, "the password is checked first")
+
+	rec := login("correct horse")
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"email_not_verified"`)
+
+	require.NoError(t, s.VerifyEmail(ctx, user.UID, user.Email))
+	assert.Equal(t, http.StatusOK, login("correct horse").Code)
+}
+
+// countingHasher counts verified passwords
+type countingHasher struct {
+	*password.Hasher
+	verified int
+}
+
+func (h *countingHasher) Verify(password string, encoded string) (bool, error) {
+	h.verified++
+
+	return h.Hasher.Verify(password, encoded)
+}
+
+func TestNew_UnknownEmail(t *testing.T) {
+	hasher := &countingHasher{Hasher: password.NewHasher(&password.Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1})}
+	passHash, err := hasher.Hash("correct horse")
+	require.NoError(t, err)
+
+	s, _ := tokenstest.NewStorage(t, []byte(passHash))
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, tokenstest.NewManager(t), hasher, false)
+
+	login := func(email string, pass string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(Request{Email: email, Password: pass})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/login", bytes.NewReader(body)))
+
+		return rec
+	}
+
+	wrongPassword := login(tokenstest.Email, "wrong horse")
+	require.Equal(t, http.StatusUnauthorized, wrongPassword.Code)
+	require.Equal(t, 1, hasher.verified)
+
+	unknownEmail := login("unknown@example.com", "correct horse")
+	assert.Equal(t, http.StatusUnauthorized, unknownEmail.Code)
+	assert.Equal(t, wrongPassword.Body.String(), unknownEmail.Body.String(), "the response doesn't tell if the email is registered")
+	assert.Equal(t, 2, hasher.verified, "passwords of unknown emails are hashed as well")
+
+	assert.Equal(t, http.StatusOK, login(tokenstest.Email, "correct horse").Code)
+}
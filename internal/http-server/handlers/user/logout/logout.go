@@ -0,0 +1,52 @@
+package logout
+
+import (
+	"context"
+	"github.com/go-chi/render"
+	"github.com/northwindman/testREST-autentification/internal/http-server/middleware/bearerauth"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type SessionRevoker interface {
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	RevokeSession(ctx context.Context, id string) error
+}
+
+// New returns handler which ends the session of the bearer access token:
+// the access token is denied until it expires and the session's refresh token is invalidated
+func New(log *slog.Logger, sessionRevoker SessionRevoker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.logout.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		principal, ok := bearerauth.FromContext(r.Context())
+		if !ok {
+			log.Error("principal is missing")
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeUnauthorized, "unauthorized"))
+			return
+		}
+
+		if err := sessionRevoker.RevokeToken(r.Context(), principal.TokenID, principal.ExpiresAt); err != nil {
+			log.Error("failed to revoke token", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		}
+
+		if err := sessionRevoker.RevokeSession(r.Context(), principal.SessionID); err != nil {
+			log.Error("failed to revoke session", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		}
+
+		log.Info("user logged out", slog.String("session", principal.SessionID))
+
+		render.JSON(w, r, resp.OK())
+	}
+}
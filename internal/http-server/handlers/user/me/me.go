@@ -0,0 +1,65 @@
+package me
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/render"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/http-server/middleware/bearerauth"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"log/slog"
+	"net/http"
+)
+
+type Response struct {
+	resp.Response
+	UID       int64    `json:"uid"`
+	Email     string   `json:"email"`
+	Scopes    []string `json:"scopes"`
+	SessionID string   `json:"session_id"`
+}
+
+type UserProvider interface {
+	GetUserByID(ctx context.Context, uid int64) (models.User, error)
+}
+
+// New returns handler which describes the authenticated user
+func New(log *slog.Logger, userProvider UserProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.me.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		principal, ok := bearerauth.FromContext(r.Context())
+		if !ok {
+			log.Error("principal is missing")
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeUnauthorized, "unauthorized"))
+			return
+		}
+
+		user, err := userProvider.GetUserByID(r.Context(), principal.UID)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				log.Warn("user not found", slog.Int64("user", principal.UID))
+				resp.WriteProblem(w, r, resp.NewProblem(http.StatusNotFound, resp.CodeNotFound, "user not found"))
+				return
+			}
+
+			log.Error("failed to get user", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		}
+
+		render.JSON(w, r, Response{
+			Response:  resp.OK(),
+			UID:       user.UID,
+			Email:     user.Email,
+			Scopes:    principal.Scopes,
+			SessionID: principal.SessionID,
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package forgot
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/background"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/outbox"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/templates"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
+	"github.com/northwindman/testREST-autentification/internal/lib/useragent"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultTTL    = 30 * time.Minute
+	DefaultLimit  = 3
+	DefaultWindow = time.Hour
+)
+
+type Request struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type UserProvider interface {
+	GetUser(ctx context.Context, email string) (models.User, error)
+	CountPasswordResets(ctx context.Context, uid int64, since time.Time) (int, error)
+	SavePasswordReset(ctx context.Context, reset models.PasswordReset) error
+	EnqueueNotification(ctx context.Context, notification models.Notification) (int64, error)
+}
+
+// Queue runs jobs one at a time in the background
+type Queue interface {
+	Enqueue(ctx context.Context, job background.Job) error
+}
+
+// Options of reset links, zero values are replaced by defaults
+type Options struct {
+	// TTL is how long the link is valid
+	TTL time.Duration
+	// Limit of reset emails to one account per Window, further requests are dropped. Only registered
+	// emails get reset emails, so requests for unknown ones are not counted
+	Limit  int
+	Window time.Duration
+}
+
+// New returns handler which emails the password reset link. Only looking up the user is done on the
+// request path, the rest is queued for the background, so the response is the same and takes about
+// the same time whether the email is registered, unknown or rate limited, and it can't be used to
+// probe for accounts. The queue must run one job at a time, otherwise concurrent requests outrun the limit
+func New(log *slog.Logger, userProvider UserProvider, tokenManager *tokens.Manager, emails *templates.Set, queue Queue, opts Options) http.HandlerFunc {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultLimit
+	}
+	if opts.Window <= 0 {
+		opts.Window = DefaultWindow
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.password.forgot.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		var req Request
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "empty request"))
+			return
+		}
+		if err != nil {
+			log.Error("failed to parse request body", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "failed to parse request"))
+			return
+		}
+
+		if err = validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+			log.Error("invalid request")
+			resp.WriteProblem(w, r, resp.ValidationProblem(validateErr))
+			return
+		}
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			log.Error("failed to parse remote address", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusInternalServerError, resp.CodeInternal, "failed to parse remote address"))
+			return
+		}
+
+		user, err := userProvider.GetUser(r.Context(), req.Email)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			log.Info("reset email not sent: user not found")
+			render.JSON(w, r, resp.OK())
+			return
+		}
+		if err != nil {
+			log.Error("failed to get user", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		}
+
+		// Unknown emails never get here, so they can't fill the queue up for the registered ones
+		pending := resetRequest{user: user, ip: ip, userAgent: r.UserAgent(), time: time.Now()}
+		err = queue.Enqueue(r.Context(), func(ctx context.Context) {
+			sendReset(ctx, log, userProvider, tokenManager, emails, opts, pending)
+		})
+		if err != nil {
+			log.Error("failed to queue reset email", slog.Int64("user", user.UID), sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusServiceUnavailable, resp.CodeUnavailable, "try again later"))
+			return
+		}
+
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+// resetRequest is the part of the request the background job needs
+type resetRequest struct {
+	user      models.User
+	ip        string
+	userAgent string
+	time      time.Time
+}
+
+var errRateLimited = errors.New("too many resets of the user")
+
+// sendReset saves a new reset of the user and enqueues the email with its link.
+// Nobody waits for the outcome, so it is only logged
+func sendReset(ctx context.Context, log *slog.Logger, userProvider UserProvider, tokenManager *tokens.Manager, emails *templates.Set, opts Options, req resetRequest) {
+	log = log.With(slog.Int64("user", req.user.UID))
+
+	err := reset(ctx, userProvider, tokenManager, emails, opts, req)
+	switch {
+	case errors.Is(err, errRateLimited):
+		log.Warn("reset email not sent: rate limited", slog.Int("limit", opts.Limit), slog.Duration("window", opts.Window))
+	case err != nil:
+		log.Error("reset email not sent", sl.Err(err))
+	default:
+		log.Info("reset email sent")
+	}
+}
+
+// reset saves a new reset of the user and enqueues the email with its link
+func reset(ctx context.Context, userProvider UserProvider, tokenManager *tokens.Manager, emails *templates.Set, opts Options, req resetRequest) error {
+	user := req.user
+
+	count, err := userProvider.CountPasswordResets(ctx, user.UID, req.time.Add(-opts.Window))
+	if err != nil {
+		return err
+	}
+	if count >= opts.Limit {
+		return errRateLimited
+	}
+
+	token, tokenHash, err := tokenManager.NewPasswordResetToken()
+	if err != nil {
+		return err
+	}
+
+	link := emails.ResetPasswordLink(token)
+	if link == "" {
+		return errNoLink
+	}
+
+	msg, err := emails.Render(templates.PasswordReset, user.Locale, user.Email, templates.Data{
+		IP:       req.ip,
+		Device:   useragent.Describe(req.userAgent),
+		Time:     req.time,
+		Link:     link,
+		ValidFor: opts.TTL,
+	})
+	if err != nil {
+		return err
+	}
+
+	err = userProvider.SavePasswordReset(ctx, models.PasswordReset{
+		TokenHash: tokenHash,
+		UID:       user.UID,
+		CreatedAt: req.time,
+		ExpiresAt: req.time.Add(opts.TTL),
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = userProvider.EnqueueNotification(ctx, outbox.Notification(msg))
+
+	return err
+}
+
+var errNoLink = errors.New("reset link is not configured")
@@ -0,0 +1,112 @@
+package forgot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/northwindman/testREST-autentification/internal/lib/background"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/templates"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var linkPattern = regexp.MustCompile(`https://example\.com/reset\?token=(\S+)`)
+
+func TestNew(t *testing.T) {
+	manager := tokenstest.NewManager(t)
+
+	emails, err := templates.New(templates.Links{ResetPassword: "https://example.com/reset?token={token}"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	queue := background.New(log, background.Options{})
+	queue.Start()
+
+	handler := New(log, s, manager, emails, queue, Options{Limit: 2})
+
+	forgot := func(email string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(Request{Email: email})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/password/forgot", bytes.NewReader(body)))
+
+		return rec
+	}
+
+	assert.Equal(t, http.StatusBadRequest, forgot("not an email").Code)
+
+	unknown := forgot("unknown@example.com")
+	registered := forgot("test@example.com")
+	require.Equal(t, http.StatusOK, registered.Code)
+	assert.Equal(t, unknown.Code, registered.Code, "the response doesn't tell if the email is registered")
+	assert.Equal(t, unknown.Body.String(), registered.Body.String(), "the response doesn't tell if the email is registered")
+
+	// Over the limit requests succeed but send nothing
+	require.Equal(t, http.StatusOK, forgot("test@example.com").Code)
+	require.Equal(t, http.StatusOK, forgot("test@example.com").Code)
+
+	// Shutdown waits for the queued emails
+	require.NoError(t, queue.Shutdown(ctx))
+
+	notifications, err := s.ClaimNotifications(ctx, 100, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, notifications, 2)
+
+	count, err := s.CountPasswordResets(ctx, user.UID, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	notification := notifications[0]
+	assert.Equal(t, "test@example.com", notification.To)
+
+	match := linkPattern.FindStringSubmatch(notification.Body)
+	require.NotNil(t, match, "the email has the reset link")
+	token, err := url.QueryUnescape(match[1])
+	require.NoError(t, err)
+
+	reset, err := s.GetPasswordReset(ctx, manager.HashPasswordResetToken(token))
+	require.NoError(t, err)
+	assert.Equal(t, user.UID, reset.UID)
+	assert.WithinDuration(t, time.Now().Add(DefaultTTL), reset.ExpiresAt, time.Minute)
+}
+
+func TestNew_QueueFull(t *testing.T) {
+	emails, err := templates.New(templates.Links{ResetPassword: "https://example.com/reset?token={token}"})
+	require.NoError(t, err)
+
+	s, _ := tokenstest.NewStorage(t, []byte("hash"))
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	// Not started, so the queue stays full
+	queue := background.New(log, background.Options{Size: 1, Wait: 10 * time.Millisecond})
+	require.NoError(t, queue.Enqueue(context.Background(), func(ctx context.Context) {}))
+
+	handler := New(log, s, tokenstest.NewManager(t), emails, queue, Options{})
+
+	forgot := func(email string) int {
+		body, err := json.Marshal(Request{Email: email})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/password/forgot", bytes.NewReader(body)))
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, forgot("unknown@example.com"), "unknown emails don't need the queue")
+	assert.Equal(t, http.StatusServiceUnavailable, forgot(tokenstest.Email), "resets of registered emails are not dropped silently")
+}
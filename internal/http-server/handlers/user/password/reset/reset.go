@@ -0,0 +1,164 @@
+package reset
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/outbox"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/templates"
+	"github.com/northwindman/testREST-autentification/internal/lib/password"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
+	"github.com/northwindman/testREST-autentification/internal/lib/useragent"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+)
+
+type Request struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required"`
+}
+
+type UserProvider interface {
+	GetPasswordReset(ctx context.Context, tokenHash []byte) (models.PasswordReset, error)
+	GetUserByID(ctx context.Context, uid int64) (models.User, error)
+	ResetPassword(ctx context.Context, tokenHash []byte, passHash []byte, outbox ...models.Notification) error
+}
+
+// PasswordPolicy checks if the password is strong enough for the user with the email
+type PasswordPolicy interface {
+	Check(password string, email string) []password.Violation
+}
+
+// PasswordHasher hashes passwords for storage
+type PasswordHasher interface {
+	Hash(password string) (string, error)
+}
+
+// New returns handler which sets the new password by the token of the reset link
+// and signs the user out everywhere
+func New(
+	log *slog.Logger,
+	userProvider UserProvider,
+	tokenManager *tokens.Manager,
+	passwordPolicy PasswordPolicy,
+	passwordHasher PasswordHasher,
+	emails *templates.Set,
+) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.password.reset.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		var req Request
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "empty request"))
+			return
+		}
+		if err != nil {
+			log.Error("failed to parse request body", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "failed to parse request"))
+			return
+		}
+
+		if err = validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+			log.Error("invalid request")
+			resp.WriteProblem(w, r, resp.ValidationProblem(validateErr))
+			return
+		}
+
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			log.Error("failed to parse remote address", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusInternalServerError, resp.CodeInternal, "failed to parse remote address"))
+			return
+		}
+
+		tokenHash := tokenManager.HashPasswordResetToken(req.Token)
+
+		reset, err := userProvider.GetPasswordReset(r.Context(), tokenHash)
+		if errors.Is(err, storage.ErrPasswordResetNotFound) {
+			log.Warn("password reset not found")
+			invalidLink(w, r)
+			return
+		}
+		if err != nil {
+			log.Error("failed to get password reset", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		}
+		if !reset.ExpiresAt.After(time.Now()) {
+			log.Warn("password reset expired", slog.Int64("user", reset.UID))
+			invalidLink(w, r)
+			return
+		}
+
+		log = log.With(slog.Int64("user", reset.UID))
+
+		user, err := userProvider.GetUserByID(r.Context(), reset.UID)
+		if err != nil {
+			log.Error("failed to get user", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		}
+
+		if violations := passwordPolicy.Check(req.Password, user.Email); len(violations) > 0 {
+			log.Warn("password violates the policy", slog.Int("violations", len(violations)))
+			resp.WriteProblem(w, r, resp.PasswordProblem(violations))
+			return
+		}
+
+		passHash, err := passwordHasher.Hash(req.Password)
+		if err != nil {
+			log.Error("failed to hash password", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusInternalServerError, resp.CodeInternal, "failed to hash password"))
+			return
+		}
+
+		// The alert is enqueued with the reset, so it is sent only if the reset succeeds
+		var alerts []models.Notification
+		msg, err := emails.Render(templates.PasswordChanged, user.Locale, user.Email, templates.Data{
+			IP:     ip,
+			Device: useragent.Describe(r.UserAgent()),
+			Time:   time.Now(),
+		})
+		if err != nil {
+			log.Error("failed to render password changed alert", sl.Err(err))
+		} else {
+			alerts = append(alerts, outbox.Notification(msg))
+		}
+
+		err = userProvider.ResetPassword(r.Context(), tokenHash, []byte(passHash), alerts...)
+		if errors.Is(err, storage.ErrPasswordResetNotFound) {
+			// Used concurrently or expired in the meantime
+			log.Warn("password reset was spent")
+			invalidLink(w, r)
+			return
+		}
+		if err != nil {
+			log.Error("failed to reset password", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		}
+
+		log.Info("password reset")
+
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+func invalidLink(w http.ResponseWriter, r *http.Request) {
+	resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeInvalidToken, "invalid or expired link"))
+}
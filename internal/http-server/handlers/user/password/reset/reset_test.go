@@ -0,0 +1,88 @@
+package reset
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/templates"
+	"github.com/northwindman/testREST-autentification/internal/lib/password"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	manager := tokenstest.NewManager(t)
+
+	emails, err := templates.New(templates.Links{})
+	require.NoError(t, err)
+
+	hasher := password.NewHasher(&password.Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1})
+	policy := &password.Policy{MinLength: 10}
+
+	ctx := context.Background()
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+	uid := user.UID
+
+	session, _, err := manager.NewSession(user, "192.0.2.1", "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, session))
+
+	saveReset := func(expiresAt time.Time) string {
+		token, tokenHash, err := manager.NewPasswordResetToken()
+		require.NoError(t, err)
+		require.NoError(t, s.SavePasswordReset(ctx, models.PasswordReset{
+			TokenHash: tokenHash,
+			UID:       uid,
+			CreatedAt: time.Now(),
+			ExpiresAt: expiresAt,
+		}))
+
+		return token
+	}
+
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, manager, policy, hasher, emails)
+
+	reset := func(token string, pass string) int {
+		body, err := json.Marshal(Request{Token: token, Password: pass})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/password/reset", bytes.NewReader(body)))
+
+		return rec.Code
+	}
+
+	expired := saveReset(time.Now().Add(-time.Second))
+	valid := saveReset(time.Now().Add(time.Hour))
+
+	assert.Equal(t, http.StatusBadRequest, reset("", "new password 1"))
+	assert.Equal(t, http.StatusUnauthorized, reset("garbage", "new password 1"))
+	assert.Equal(t, http.StatusUnauthorized, reset(expired, "new password 1"), "expired")
+	assert.Equal(t, http.StatusBadRequest, reset(valid, "short"), "the policy applies")
+
+	require.Equal(t, http.StatusOK, reset(valid, "new password 1"))
+	assert.Equal(t, http.StatusUnauthorized, reset(valid, "new password 2"), "the token works once")
+
+	user, err = s.GetUserByID(ctx, uid)
+	require.NoError(t, err)
+	ok, err := hasher.Verify("new password 1", string(user.PassHash))
+	require.NoError(t, err)
+	assert.True(t, ok)
+
+	sessions, err := s.ListSessions(ctx, uid)
+	require.NoError(t, err)
+	assert.Empty(t, sessions, "all sessions are revoked")
+
+	notifications, err := s.ClaimNotifications(ctx, 100, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	assert.Equal(t, "Your password was changed", notifications[0].Subject)
+}
@@ -1,25 +1,32 @@
 package refresh
 
 import (
+	"context"
 	"errors"
 	"github.com/go-chi/render"
 	"github.com/go-playground/validator/v10"
 	"github.com/northwindman/testREST-autentification/internal/domain/models"
 	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
-	"github.com/northwindman/testREST-autentification/internal/lib/format"
 	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
-	"github.com/northwindman/testREST-autentification/internal/lib/notifications/email"
-	"github.com/northwindman/testREST-autentification/internal/lib/random"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/outbox"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/templates"
 	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
 	myjwt "github.com/northwindman/testREST-autentification/internal/lib/tokens/jwt"
+	"github.com/northwindman/testREST-autentification/internal/lib/useragent"
 	"github.com/northwindman/testREST-autentification/internal/storage"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+var errTokenMismatch = errors.New("access token belongs to another session")
+
 type Request struct {
-	AccessToken  string `json:"access_token" validate:"required"`
+	// AccessToken is optional, if it is sent it must belong to the session of the refresh token
+	AccessToken  string `json:"access_token,omitempty"`
 	RefreshToken string `json:"refresh_token" validate:"required"`
 }
 
@@ -30,15 +37,32 @@ type Response struct {
 }
 
 type UserProvider interface {
-	GetUser(email string) (models.User, error)
-	UpdateUser(email string, ip string, secret string, refreshToken []byte) (int64, error)
+	GetUserByID(ctx context.Context, uid int64) (models.User, error)
+	GetSessionByRefreshHash(ctx context.Context, hash []byte) (models.Session, error)
+	GetSessionByUsedRefreshHash(ctx context.Context, hash []byte) (models.Session, time.Time, error)
+	RotateSession(ctx context.Context, session models.Session, usedHash []byte, outbox ...models.Notification) error
+	RevokeSession(ctx context.Context, id string) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	EnqueueNotification(ctx context.Context, notification models.Notification) (int64, error)
 }
 
-func New(log *slog.Logger, userProvider UserProvider) http.HandlerFunc {
+// New returns handler which rotates the pair of tokens of the session the refresh token identifies.
+// Nothing from the request is trusted before the refresh token is found. Alerts go to the outbox,
+// so a slow mail server doesn't delay the response. Within reuseGracePeriod after the rotation
+// the previous refresh token replayed from the same IP and user agent is taken for a retry or
+// a refresh in a parallel tab rather than for theft, zero takes every replay for theft
+func New(
+	log *slog.Logger,
+	userProvider UserProvider,
+	tokenManager *tokens.Manager,
+	emails *templates.Set,
+	requireVerifiedEmail bool,
+	reuseGracePeriod time.Duration,
+) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		const op = "handlers.user.refresh.New"
 
-		log.With(
+		log := log.With(
 			slog.String("op", op),
 		)
 
@@ -46,12 +70,12 @@ func New(log *slog.Logger, userProvider UserProvider) http.HandlerFunc {
 		err := render.DecodeJSON(r.Body, &req)
 		if errors.Is(err, io.EOF) {
 			log.Error("request body is empty", sl.Err(err))
-			render.JSON(w, r, resp.Error("empty request"))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "empty request"))
 			return
 		}
 		if err != nil {
 			log.Error("failed to parse request body", sl.Err(err))
-			render.JSON(w, r, resp.Error("failed to parse request"))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "failed to parse request"))
 			return
 		}
 
@@ -60,96 +84,221 @@ func New(log *slog.Logger, userProvider UserProvider) http.HandlerFunc {
 		if err = validator.New().Struct(req); err != nil {
 			validateErr := err.(validator.ValidationErrors)
 			log.Error("invalid request")
-			render.JSON(w, r, resp.ValidationError(validateErr))
+			resp.WriteProblem(w, r, resp.ValidationProblem(validateErr))
 			return
 		}
 
-		claims, err := myjwt.GetClaims(req.AccessToken)
+		ip, _, err := net.SplitHostPort(r.RemoteAddr)
 		if err != nil {
-			log.Error("failed to get claims", sl.Err(err))
-			render.JSON(w, r, resp.Error("failed to get claims"))
+			log.Error("failed to parse remote address", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusInternalServerError, resp.CodeInternal, "failed to parse remote address"))
 			return
 		}
 
-		incomingEmail := claims["email"].(string)
+		refreshHash := tokenManager.HashRefreshToken(req.RefreshToken)
 
-		originalUser, err := userProvider.GetUser(incomingEmail)
+		session, err := userProvider.GetSessionByRefreshHash(r.Context(), refreshHash)
 		if err != nil {
-			if errors.Is(err, storage.ErrNotFound) {
-				log.Warn("user not found", sl.Err(err))
-				render.JSON(w, r, resp.Error("user not found"))
+			if errors.Is(err, storage.ErrSessionNotFound) {
+				if detectReuse(r.Context(), log, userProvider, emails, reuseGracePeriod, refreshHash, ip, r.UserAgent()) {
+					staleToken(w, r)
+					return
+				}
+
+				log.Warn("invalid refresh token")
+				resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeInvalidCredentials, "invalid credentials"))
 				return
 			}
 
-			render.JSON(w, r, resp.Error("failed to get user"))
+			log.Error("failed to get session", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "failed to get session"))
 			return
 		}
 
-		decodedBytes, err := format.FromBase64(req.RefreshToken)
-		if err != nil {
-			log.Error("failed to decode refresh token", sl.Err(err))
-			render.JSON(w, r, resp.Error("failed to decode refresh token"))
+		if time.Now().After(session.ExpiresAt) {
+			log.Warn("session expired", slog.String("session", session.ID))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeInvalidToken, "session expired"))
 			return
 		}
 
-		if ok := format.VerifyString(decodedBytes, originalUser.RefreshToken); !ok {
-			log.Error("invalid refresh token")
-			render.JSON(w, r, resp.Error("invalid credentials"))
+		originalUser, err := userProvider.GetUserByID(r.Context(), session.UID)
+		if err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				log.Warn("user not found", sl.Err(err))
+				resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeInvalidToken, "user not found"))
+				return
+			}
+
+			log.Error("failed to get user", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "failed to get user"))
 			return
 		}
 
-		originalSecret := originalUser.Secret
-
-		incomingUser, err := myjwt.ParseToken(req.AccessToken, originalSecret)
-		if err != nil {
-			log.Error("failed to parse token", sl.Err(err))
-			render.JSON(w, r, resp.Error("failed to parse token"))
+		if requireVerifiedEmail && !originalUser.EmailVerified {
+			log.Warn("email is not verified", slog.Int64("user", originalUser.UID))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusForbidden, resp.CodeEmailNotVerified, "email is not verified"))
 			return
 		}
 
-		if originalUser.IP != incomingUser.IP {
-			err = email.New(originalUser.Email, "Someone an another IP refresh your access token", "some body...")
+		if req.AccessToken != "" {
+			problem, err := checkBinding(r.Context(), userProvider, tokenManager, session, req.AccessToken)
 			if err != nil {
-				log.Error("failed to send email", sl.Err(err))
+				if problem.Status >= http.StatusInternalServerError {
+					log.Error("failed to check access token", sl.Err(err))
+				} else {
+					log.Warn("access token does not match the session", slog.String("session", session.ID), sl.Err(err))
+				}
+				resp.WriteProblem(w, r, problem)
+				return
 			}
 		}
 
-		newSecret, err := random.NewSecret(random.SecretLength)
-		if err != nil {
-			log.Error("failed to generate new secret", sl.Err(err))
-			render.JSON(w, r, resp.Error("internal error"))
-			return
-		}
+		newIP := session.IP != ip
+		usedHash := session.RefreshHash
+		session.IP = ip
+		session.UserAgent = r.UserAgent()
 
-		newTokens, err := tokens.GenTokens(incomingUser.IP, originalUser.Email, newSecret, tokens.AccessTokenLength)
+		newTokens, err := tokenManager.Rotate(&session, originalUser.Email)
 		if err != nil {
 			log.Error("failed to generate new tokens", sl.Err(err))
-			render.JSON(w, r, resp.Error("internal error"))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusInternalServerError, resp.CodeInternal, "internal error"))
 			return
 		}
 
-		tokenHash, err := format.HashString(newTokens.RefreshToken)
-		if err != nil {
-			log.Error("failed to hash token", sl.Err(err))
-			render.JSON(w, r, resp.Error("internal error"))
-			return
+		// The alert is enqueued with the rotation, so it is sent only if the rotation wins
+		var alerts []models.Notification
+		if newIP {
+			msg, err := emails.Render(templates.NewSignIn, originalUser.Locale, originalUser.Email, templates.Data{
+				IP:         ip,
+				Device:     useragent.Describe(r.UserAgent()),
+				Time:       time.Now(),
+				RevokeLink: emails.RevokeSessionLink(tokenManager.RevokeLinkToken(session)),
+			})
+			if err != nil {
+				log.Error("failed to render new IP alert", sl.Err(err))
+			} else {
+				alerts = append(alerts, outbox.Notification(msg))
+			}
 		}
 
-		newTokens.RefreshToken = format.InBase64(newTokens.RefreshToken)
+		if err = userProvider.RotateSession(r.Context(), session, usedHash, alerts...); err != nil {
+			if errors.Is(err, storage.ErrStaleSession) {
+				// Another refresh with the same token has won the race
+				log.Warn("session was rotated concurrently", slog.String("session", session.ID))
+				staleToken(w, r)
+				return
+			}
 
-		id, err := userProvider.UpdateUser(originalUser.Email, incomingUser.IP, newSecret, tokenHash)
-		if err != nil {
-			log.Error("failed to update user", sl.Err(err))
-			render.JSON(w, r, resp.Error("internal error"))
+			log.Error("failed to rotate session", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
 			return
 		}
 
-		log.Info("user updated", slog.Int64("id", id))
+		log.Info("session updated", slog.String("session", session.ID), slog.Int("alerts", len(alerts)))
 
 		responseOK(w, r, newTokens.AccessToken, newTokens.RefreshToken)
 	}
 }
 
+// checkBinding makes sure the access token was issued for the session and was not revoked,
+// its expiry doesn't matter as access tokens are usually outdated by the time they are refreshed
+func checkBinding(ctx context.Context, userProvider UserProvider, tokenManager *tokens.Manager, session models.Session, accessToken string) (resp.Problem, error) {
+	claims, err := myjwt.ParseExpiredToken(accessToken, tokenManager.Keys, tokenManager.Access)
+	if err != nil {
+		return resp.NewProblem(http.StatusUnauthorized, resp.CodeInvalidToken, "failed to parse token"), err
+	}
+
+	if claims.Subject != strconv.FormatInt(session.UID, 10) || claims.SessionID != session.ID {
+		return resp.NewProblem(http.StatusUnauthorized, resp.CodeInvalidCredentials, "invalid credentials"), errTokenMismatch
+	}
+
+	revoked, err := userProvider.IsTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		return resp.StorageProblem(err, "internal error"), err
+	}
+	if revoked {
+		return resp.NewProblem(http.StatusUnauthorized, resp.CodeInvalidToken, "token revoked"), tokens.ErrTokenRevoked
+	}
+
+	return resp.Problem{}, nil
+}
+
+// detectReuse looks for the session an unknown refresh token was already rotated in. Such a replay
+// means the token was most likely stolen, so every token of its family (the whole session) is revoked.
+// A token rotated within reuseGracePeriod by a request from the same IP and user agent has rather lost
+// a race with its own client, then the session is left alone and concurrent is true
+func detectReuse(
+	ctx context.Context,
+	log *slog.Logger,
+	userProvider UserProvider,
+	emails *templates.Set,
+	reuseGracePeriod time.Duration,
+	refreshHash []byte,
+	ip string,
+	userAgent string,
+) (concurrent bool) {
+	// The family must be revoked even if the client has already gone
+	ctx = context.WithoutCancel(ctx)
+
+	session, usedAt, err := userProvider.GetSessionByUsedRefreshHash(ctx, refreshHash)
+	if err != nil {
+		if !errors.Is(err, storage.ErrSessionNotFound) {
+			log.Error("failed to look up used refresh token", sl.Err(err))
+		}
+		return false
+	}
+
+	// The family was revoked and the user alerted by the first replay, later ones change nothing
+	if session.RevokedAt != nil {
+		log.Warn("refresh token of revoked session replayed", slog.String("session", session.ID))
+		return false
+	}
+
+	// The session keeps the IP and user agent of the rotation, a thief rarely shares both
+	if time.Since(usedAt) < reuseGracePeriod && session.IP == ip && session.UserAgent == userAgent {
+		log.Warn("refresh token was rotated concurrently", slog.String("session", session.ID))
+		return true
+	}
+
+	log.Warn("security event: refresh token reuse detected",
+		slog.String("event", "refresh_token_reuse"),
+		slog.String("session", session.ID),
+		slog.Int64("user", session.UID),
+		slog.String("ip", ip),
+	)
+
+	if err = userProvider.RevokeSession(ctx, session.ID); err != nil {
+		log.Error("failed to revoke session", sl.Err(err))
+	}
+
+	user, err := userProvider.GetUserByID(ctx, session.UID)
+	if err != nil {
+		log.Error("failed to get user", sl.Err(err))
+		return false
+	}
+
+	msg, err := emails.Render(templates.SessionRevoked, user.Locale, user.Email, templates.Data{
+		IP:     ip,
+		Device: useragent.Describe(userAgent),
+		Time:   time.Now(),
+	})
+	if err != nil {
+		log.Error("failed to render reuse alert", sl.Err(err))
+		return false
+	}
+
+	if _, err = userProvider.EnqueueNotification(ctx, outbox.Notification(msg)); err != nil {
+		log.Error("failed to enqueue reuse alert", sl.Err(err))
+	}
+
+	return false
+}
+
+// staleToken tells the client its refresh token was spent by a concurrent refresh
+func staleToken(w http.ResponseWriter, r *http.Request) {
+	resp.WriteProblem(w, r, resp.NewProblem(http.StatusConflict, resp.CodeConflict, "refresh token was already used"))
+}
+
 func responseOK(w http.ResponseWriter, r *http.Request, acToken string, rfToken string) {
 	render.JSON(w, r, Response{
 		Response:     resp.OK(),
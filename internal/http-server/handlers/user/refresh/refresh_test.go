@@ -0,0 +1,393 @@
+package refresh
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/outbox"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/smtp"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/smtp/smtptest"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/templates"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/northwindman/testREST-autentification/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func emails(t *testing.T) *templates.Set {
+	set, err := templates.New(templates.Links{RevokeSession: "https://example.com/revoke?token={token}"})
+	require.NoError(t, err)
+
+	return set
+}
+
+// deliver runs the outbox of the storage against a fake SMTP server until the test ends
+func deliver(t *testing.T, s *memory.Storage) (*smtptest.Server, *outbox.Pool) {
+	server := smtptest.NewServer(t, smtptest.Options{})
+
+	notifier, err := smtp.New(smtp.Options{
+		Host: server.Host(),
+		Port: server.Port(),
+		From: "no-reply@example.com",
+		TLS:  smtp.TLSNone,
+	})
+	require.NoError(t, err)
+
+	pool := outbox.New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, notifier, outbox.Options{PollInterval: 5 * time.Millisecond})
+	pool.Start()
+	t.Cleanup(func() { _ = pool.Shutdown(context.Background()) })
+
+	return server, pool
+}
+
+// gracePeriod is long enough for any test to replay a token within it
+const gracePeriod = time.Minute
+
+// pending returns notifications waiting in the outbox
+func pending(t *testing.T, s *memory.Storage) []models.Notification {
+	notifications, err := s.ClaimNotifications(context.Background(), 100, time.Minute)
+	require.NoError(t, err)
+
+	return notifications
+}
+
+func TestNew_ConcurrentRefresh(t *testing.T) {
+	const refreshes = 8
+
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	// httptest requests come from 192.0.2.1, so no new IP alert is sent
+	session, token, err := manager.NewSession(user, "192.0.2.1", "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, session))
+
+	body, err := json.Marshal(Request{AccessToken: token.AccessToken, RefreshToken: token.RefreshToken})
+	require.NoError(t, err)
+
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, manager, emails(t), false, gracePeriod)
+
+	var (
+		wg        sync.WaitGroup
+		start     = make(chan struct{})
+		responses = make([]*httptest.ResponseRecorder, refreshes)
+	)
+
+	for i := 0; i < refreshes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			r := httptest.NewRequest(http.MethodPatch, "/refresh", bytes.NewReader(body))
+			w := httptest.NewRecorder()
+
+			<-start
+			handler.ServeHTTP(w, r)
+
+			responses[i] = w
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+
+	var winners []*httptest.ResponseRecorder
+	for _, w := range responses {
+		if w.Code == http.StatusOK {
+			winners = append(winners, w)
+			continue
+		}
+		assert.Equal(t, http.StatusConflict, w.Code, "losers are told the token was spent, not taken for thieves")
+	}
+	require.Len(t, winners, 1, "exactly one refresh must win")
+
+	used, _, err := s.GetSessionByUsedRefreshHash(ctx, session.RefreshHash)
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, used.ID)
+
+	got, err := s.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.Nil(t, got.RevokedAt, "the race doesn't revoke the session")
+
+	var rotated Response
+	require.NoError(t, json.NewDecoder(winners[0].Body).Decode(&rotated))
+	body, err = json.Marshal(Request{RefreshToken: rotated.RefreshToken})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/refresh", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code, "the token of the winner still refreshes")
+
+	assert.Empty(t, pending(t, s), "no refresh came from a new IP and none was taken for reuse")
+}
+
+func TestNew_Reuse(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	session, token, err := manager.NewSession(user, "192.0.2.1", "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, session))
+
+	mail, _ := deliver(t, s)
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, manager, emails(t), false, 0)
+
+	refresh := func(token models.Token) (int, Response) {
+		body, err := json.Marshal(Request{AccessToken: token.AccessToken, RefreshToken: token.RefreshToken})
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/refresh", bytes.NewReader(body)))
+
+		var response Response
+		if w.Code == http.StatusOK {
+			require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+		}
+
+		return w.Code, response
+	}
+
+	status, rotated := refresh(token)
+	require.Equal(t, http.StatusOK, status)
+
+	// Replay of the rotated token revokes the whole family, the new token included
+	status, _ = refresh(token)
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	got, err := s.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, got.RevokedAt)
+
+	status, _ = refresh(models.Token{AccessToken: rotated.AccessToken, RefreshToken: rotated.RefreshToken})
+	assert.Equal(t, http.StatusUnauthorized, status)
+
+	require.Eventually(t, func() bool { return len(mail.Messages()) == 1 }, 5*time.Second, 5*time.Millisecond)
+
+	messages := mail.Messages()
+	assert.Equal(t, []string{"test@example.com"}, messages[0].To)
+	assert.Equal(t, "Your session was revoked", messages[0].Subject)
+}
+
+func TestNew_ReplayAfterRevoke(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	session, token, err := manager.NewSession(user, tokenstest.IP, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, session))
+
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, manager, emails(t), false, 0)
+
+	body, err := json.Marshal(Request{RefreshToken: token.RefreshToken})
+	require.NoError(t, err)
+
+	refresh := func() int {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/refresh", bytes.NewReader(body)))
+
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusOK, refresh())
+
+	// Every replay of the leaked token is refused, but only the first one alerts the user
+	assert.Equal(t, http.StatusUnauthorized, refresh())
+	assert.Equal(t, http.StatusUnauthorized, refresh())
+
+	notifications := pending(t, s)
+	require.Len(t, notifications, 1)
+	assert.Equal(t, "Your session was revoked", notifications[0].Subject)
+}
+
+func TestNew_NewIPAlert(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	// httptest requests come from 192.0.2.1
+	session, token, err := manager.NewSession(user, "203.0.113.7", "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, session))
+
+	mail, pool := deliver(t, s)
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, manager, emails(t), false, gracePeriod)
+
+	body, err := json.Marshal(Request{RefreshToken: token.RefreshToken})
+	require.NoError(t, err)
+
+	r := httptest.NewRequest(http.MethodPatch, "/refresh", bytes.NewReader(body))
+	r.Header.Set("User-Agent", "Mozilla/5.0 (X11; Linux x86_64; rv:128.0) Gecko/20100101 Firefox/128.0")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	// The alert is sent in the background
+	require.Eventually(t, func() bool { return len(mail.Messages()) == 1 }, 5*time.Second, 5*time.Millisecond)
+
+	messages := mail.Messages()
+	assert.Equal(t, "no-reply@example.com", messages[0].From)
+	assert.Equal(t, []string{"test@example.com"}, messages[0].To)
+	assert.Equal(t, "New sign-in to your account", messages[0].Subject)
+	assert.Contains(t, messages[0].Body, "IP address: 192.0.2.1")
+	assert.Contains(t, messages[0].Body, "Device: Firefox on Linux")
+	assert.Contains(t, messages[0].HTML, "192.0.2.1")
+
+	// The "this wasn't me" link revokes the session the alert is about
+	_, link, found := strings.Cut(messages[0].Body, "https://example.com/revoke?token=")
+	require.True(t, found, "the alert has the revoke link")
+	link, _, _ = strings.Cut(link, "\n")
+	revokeToken, err := url.QueryUnescape(link)
+	require.NoError(t, err)
+	sessionID, err := manager.VerifyRevokeLinkToken(revokeToken)
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, sessionID)
+
+	// Refreshing again from the same IP sends nothing
+	var response Response
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	body, err = json.Marshal(Request{RefreshToken: response.RefreshToken})
+	require.NoError(t, err)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/refresh", bytes.NewReader(body)))
+	require.Equal(t, http.StatusOK, w.Code)
+
+	require.NoError(t, pool.Shutdown(ctx))
+	assert.Len(t, mail.Messages(), 1)
+	assert.Empty(t, pending(t, s))
+}
+
+func TestNew_AccessTokenBinding(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	first, firstToken, err := manager.NewSession(user, "192.0.2.1", "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, first))
+
+	second, secondToken, err := manager.NewSession(user, "192.0.2.1", "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, second))
+
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, manager, emails(t), false, gracePeriod)
+
+	refresh := func(req Request) int {
+		body, err := json.Marshal(req)
+		require.NoError(t, err)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/refresh", bytes.NewReader(body)))
+
+		return w.Code
+	}
+
+	tests := []struct {
+		name   string
+		req    Request
+		status int
+	}{
+		{"refresh token only", Request{RefreshToken: firstToken.RefreshToken}, http.StatusOK},
+		{"access token of another session", Request{AccessToken: firstToken.AccessToken, RefreshToken: secondToken.RefreshToken}, http.StatusUnauthorized},
+		{"malformed access token", Request{AccessToken: "not.a.token", RefreshToken: secondToken.RefreshToken}, http.StatusUnauthorized},
+		{"unknown refresh token", Request{AccessToken: secondToken.AccessToken, RefreshToken: "unknown"}, http.StatusUnauthorized},
+		{"matching pair", Request{AccessToken: secondToken.AccessToken, RefreshToken: secondToken.RefreshToken}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.status, refresh(tt.req))
+		})
+	}
+
+	// The matching pair was accepted, so the rejected bindings neither rotated nor revoked the session
+	got, err := s.GetSession(ctx, second.ID)
+	require.NoError(t, err)
+	assert.Nil(t, got.RevokedAt)
+	assert.NotEqual(t, second.RefreshHash, got.RefreshHash, "the matching pair rotates the session")
+
+	assert.Empty(t, pending(t, s), "all refreshes came from the same IP")
+}
+
+func TestNew_RequireVerifiedEmail(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	session, token, err := manager.NewSession(user, "192.0.2.1", "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, session))
+
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, manager, emails(t), true, gracePeriod)
+
+	body, err := json.Marshal(Request{RefreshToken: token.RefreshToken})
+	require.NoError(t, err)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/refresh", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusForbidden, w.Code)
+
+	require.NoError(t, s.VerifyEmail(ctx, user.UID, user.Email))
+
+	// The refused refresh didn't spend the token
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPatch, "/refresh", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestNew_ReplayWithinGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	session, token, err := manager.NewSession(user, tokenstest.IP, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, session))
+
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, manager, emails(t), false, gracePeriod)
+
+	body, err := json.Marshal(Request{RefreshToken: token.RefreshToken})
+	require.NoError(t, err)
+
+	refresh := func(remoteAddr string, userAgent string) int {
+		r := httptest.NewRequest(http.MethodPatch, "/refresh", bytes.NewReader(body))
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("User-Agent", userAgent)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w.Code
+	}
+
+	require.Equal(t, http.StatusOK, refresh("192.0.2.1:1234", "browser"))
+	assert.Equal(t, http.StatusConflict, refresh("192.0.2.1:1234", "browser"), "a retry of the same client")
+
+	got, err := s.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	require.Nil(t, got.RevokedAt)
+
+	// The grace period doesn't cover replays from anywhere else
+	assert.Equal(t, http.StatusUnauthorized, refresh("198.51.100.1:1234", "browser"))
+
+	got, err = s.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, got.RevokedAt)
+
+	notifications := pending(t, s)
+	require.Len(t, notifications, 1)
+	assert.Equal(t, "Your session was revoked", notifications[0].Subject)
+}
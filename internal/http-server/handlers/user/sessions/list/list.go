@@ -0,0 +1,78 @@
+package list
+
+import (
+	"context"
+	"github.com/go-chi/render"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/http-server/middleware/bearerauth"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type Session struct {
+	ID         string    `json:"id"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// Current is set for the session of the token the request was made with
+	Current bool `json:"current"`
+}
+
+type Response struct {
+	resp.Response
+	Sessions []Session `json:"sessions"`
+}
+
+type SessionProvider interface {
+	ListSessions(ctx context.Context, uid int64) ([]models.Session, error)
+}
+
+// New returns handler which lists active sessions of the user
+func New(log *slog.Logger, sessionProvider SessionProvider) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.sessions.list.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		principal, ok := bearerauth.FromContext(r.Context())
+		if !ok {
+			log.Error("principal is missing")
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeUnauthorized, "unauthorized"))
+			return
+		}
+
+		sessions, err := sessionProvider.ListSessions(r.Context(), principal.UID)
+		if err != nil {
+			log.Error("failed to list sessions", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		}
+
+		response := Response{
+			Response: resp.OK(),
+			Sessions: make([]Session, 0, len(sessions)),
+		}
+		for _, session := range sessions {
+			response.Sessions = append(response.Sessions, Session{
+				ID:         session.ID,
+				IP:         session.IP,
+				UserAgent:  session.UserAgent,
+				CreatedAt:  session.CreatedAt,
+				LastUsedAt: session.LastUsedAt,
+				ExpiresAt:  session.ExpiresAt,
+				Current:    session.ID == principal.SessionID,
+			})
+		}
+
+		log.Info("sessions listed", slog.Int64("user", principal.UID), slog.Int("sessions", len(sessions)))
+
+		render.JSON(w, r, response)
+	}
+}
@@ -0,0 +1,66 @@
+package list
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/northwindman/testREST-autentification/internal/http-server/middleware/bearerauth"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	otherUID, err := s.SaveUser(ctx, tokenstest.IP, "other@example.com", []byte("hash"), "")
+	require.NoError(t, err)
+	other, err := s.GetUserByID(ctx, otherUID)
+	require.NoError(t, err)
+
+	current, token, err := manager.NewSession(user, tokenstest.IP, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, current))
+
+	second, _, err := manager.NewSession(user, "203.0.113.7", "another device")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, second))
+
+	revoked, _, err := manager.NewSession(user, tokenstest.IP, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, revoked))
+	require.NoError(t, s.RevokeSession(ctx, revoked.ID))
+
+	foreign, _, err := manager.NewSession(other, tokenstest.IP, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, foreign))
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := bearerauth.New(log, s, manager)(New(log, s))
+
+	r := httptest.NewRequest(http.MethodGet, "/sessions", nil)
+	r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response Response
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+
+	listed := make(map[string]Session, len(response.Sessions))
+	for _, session := range response.Sessions {
+		listed[session.ID] = session
+	}
+
+	require.Len(t, listed, 2, "only active sessions of the user are listed")
+	assert.True(t, listed[current.ID].Current)
+	assert.False(t, listed[second.ID].Current)
+	assert.Equal(t, "203.0.113.7", listed[second.ID].IP)
+	assert.Equal(t, "another device", listed[second.ID].UserAgent)
+}
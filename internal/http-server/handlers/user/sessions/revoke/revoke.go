@@ -0,0 +1,70 @@
+package revoke
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/http-server/middleware/bearerauth"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"log/slog"
+	"net/http"
+)
+
+type SessionRevoker interface {
+	GetSession(ctx context.Context, id string) (models.Session, error)
+	RevokeSession(ctx context.Context, id string) error
+}
+
+// New returns handler which revokes one session of the user by its id from the URL
+func New(log *slog.Logger, sessionRevoker SessionRevoker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.sessions.revoke.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		principal, ok := bearerauth.FromContext(r.Context())
+		if !ok {
+			log.Error("principal is missing")
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeUnauthorized, "unauthorized"))
+			return
+		}
+
+		sessionID := chi.URLParam(r, "id")
+
+		session, err := sessionRevoker.GetSession(r.Context(), sessionID)
+		if err != nil {
+			if errors.Is(err, storage.ErrSessionNotFound) {
+				log.Warn("session not found", slog.String("session", sessionID))
+				resp.WriteProblem(w, r, resp.NewProblem(http.StatusNotFound, resp.CodeNotFound, "session not found"))
+				return
+			}
+
+			log.Error("failed to get session", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		}
+
+		// Sessions of other users look exactly like missing ones
+		if session.UID != principal.UID {
+			log.Warn("session belongs to another user", slog.String("session", sessionID))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusNotFound, resp.CodeNotFound, "session not found"))
+			return
+		}
+
+		if err = sessionRevoker.RevokeSession(r.Context(), session.ID); err != nil {
+			log.Error("failed to revoke session", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		}
+
+		log.Info("session revoked", slog.String("session", session.ID))
+
+		render.JSON(w, r, resp.OK())
+	}
+}
@@ -0,0 +1,85 @@
+package revoke
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/go-chi/chi/v5"
+	"github.com/northwindman/testREST-autentification/internal/http-server/middleware/bearerauth"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// problem decodes the problem of the response, without the instance which is the path
+func problem(t *testing.T, w *httptest.ResponseRecorder) resp.Problem {
+	var problem resp.Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	problem.Instance = ""
+
+	return problem
+}
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	otherUID, err := s.SaveUser(ctx, tokenstest.IP, "other@example.com", []byte("hash"), "")
+	require.NoError(t, err)
+	other, err := s.GetUserByID(ctx, otherUID)
+	require.NoError(t, err)
+
+	current, token, err := manager.NewSession(user, tokenstest.IP, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, current))
+
+	second, _, err := manager.NewSession(user, tokenstest.IP, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, second))
+
+	foreign, _, err := manager.NewSession(other, tokenstest.IP, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, foreign))
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	router := chi.NewRouter()
+	router.Use(bearerauth.New(log, s, manager))
+	router.Delete("/sessions/{id}", New(log, s))
+
+	revoke := func(id string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodDelete, "/sessions/"+id, nil)
+		r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+
+		return w
+	}
+
+	unknown := revoke("unknown")
+	assert.Equal(t, http.StatusNotFound, unknown.Code)
+
+	stolen := revoke(foreign.ID)
+	assert.Equal(t, http.StatusNotFound, stolen.Code)
+	assert.Equal(t, problem(t, unknown), problem(t, stolen), "sessions of other users look like missing ones")
+
+	got, err := s.GetSession(ctx, foreign.ID)
+	require.NoError(t, err)
+	assert.Nil(t, got.RevokedAt, "sessions of other users stay active")
+
+	assert.Equal(t, http.StatusOK, revoke(second.ID).Code)
+
+	got, err = s.GetSession(ctx, second.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, got.RevokedAt)
+
+	got, err = s.GetSession(ctx, current.ID)
+	require.NoError(t, err)
+	assert.Nil(t, got.RevokedAt, "only the session of the id is revoked")
+}
@@ -0,0 +1,53 @@
+package revokeall
+
+import (
+	"context"
+	"github.com/go-chi/render"
+	"github.com/northwindman/testREST-autentification/internal/http-server/middleware/bearerauth"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"log/slog"
+	"net/http"
+)
+
+type Response struct {
+	resp.Response
+	Revoked int64 `json:"revoked"`
+}
+
+type SessionRevoker interface {
+	RevokeAllSessions(ctx context.Context, uid int64) (int64, error)
+}
+
+// New returns handler which logs the user out everywhere by revoking all of their sessions,
+// including the current one
+func New(log *slog.Logger, sessionRevoker SessionRevoker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.sessions.revokeall.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		principal, ok := bearerauth.FromContext(r.Context())
+		if !ok {
+			log.Error("principal is missing")
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeUnauthorized, "unauthorized"))
+			return
+		}
+
+		revoked, err := sessionRevoker.RevokeAllSessions(r.Context(), principal.UID)
+		if err != nil {
+			log.Error("failed to revoke sessions", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		}
+
+		log.Info("all sessions revoked", slog.Int64("user", principal.UID), slog.Int64("revoked", revoked))
+
+		render.JSON(w, r, Response{
+			Response: resp.OK(),
+			Revoked:  revoked,
+		})
+	}
+}
@@ -0,0 +1,68 @@
+package revokeall
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/northwindman/testREST-autentification/internal/http-server/middleware/bearerauth"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	otherUID, err := s.SaveUser(ctx, tokenstest.IP, "other@example.com", []byte("hash"), "")
+	require.NoError(t, err)
+	other, err := s.GetUserByID(ctx, otherUID)
+	require.NoError(t, err)
+
+	current, token, err := manager.NewSession(user, tokenstest.IP, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, current))
+
+	second, _, err := manager.NewSession(user, tokenstest.IP, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, second))
+
+	foreign, _, err := manager.NewSession(other, tokenstest.IP, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, foreign))
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+	handler := bearerauth.New(log, s, manager)(New(log, s))
+
+	revokeAll := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodDelete, "/sessions", nil)
+		r.Header.Set("Authorization", "Bearer "+token.AccessToken)
+
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		return w
+	}
+
+	w := revokeAll()
+	require.Equal(t, http.StatusOK, w.Code)
+
+	var response Response
+	require.NoError(t, json.NewDecoder(w.Body).Decode(&response))
+	assert.Equal(t, int64(2), response.Revoked)
+
+	sessions, err := s.ListSessions(ctx, user.UID)
+	require.NoError(t, err)
+	assert.Empty(t, sessions, "the current session is revoked too")
+
+	got, err := s.GetSession(ctx, foreign.ID)
+	require.NoError(t, err)
+	assert.Nil(t, got.RevokedAt, "sessions of other users stay active")
+
+	assert.Equal(t, http.StatusUnauthorized, revokeAll().Code, "the access token died with its session")
+}
@@ -0,0 +1,72 @@
+package revokelink
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+type Request struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type SessionRevoker interface {
+	RevokeSession(ctx context.Context, id string) error
+}
+
+// New returns handler which revokes the session by the token of the "this wasn't me" link of an alert.
+// The token is the only credential, the user may have no access to the session any more
+func New(log *slog.Logger, sessionRevoker SessionRevoker, tokenManager *tokens.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.sessions.revokelink.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		var req Request
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "empty request"))
+			return
+		}
+		if err != nil {
+			log.Error("failed to parse request body", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "failed to parse request"))
+			return
+		}
+
+		if err = validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+			log.Error("invalid request")
+			resp.WriteProblem(w, r, resp.ValidationProblem(validateErr))
+			return
+		}
+
+		sessionID, err := tokenManager.VerifyRevokeLinkToken(req.Token)
+		if err != nil {
+			log.Warn("invalid revoke link token", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeInvalidToken, "invalid or expired link"))
+			return
+		}
+
+		// Revoking is idempotent, so opening the link twice is fine
+		if err = sessionRevoker.RevokeSession(r.Context(), sessionID); err != nil {
+			log.Error("failed to revoke session", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		}
+
+		log.Info("session revoked by link", slog.String("session", sessionID))
+
+		render.JSON(w, r, resp.OK())
+	}
+}
@@ -0,0 +1,54 @@
+package revokelink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	session, _, err := manager.NewSession(user, tokenstest.IP, "test")
+	require.NoError(t, err)
+	require.NoError(t, s.SaveSession(ctx, session))
+
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, manager)
+
+	revoke := func(token string) int {
+		body, err := json.Marshal(Request{Token: token})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/sessions/revoke-link", bytes.NewReader(body)))
+
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusBadRequest, revoke(""))
+	assert.Equal(t, http.StatusUnauthorized, revoke("garbage"))
+	assert.Equal(t, http.StatusUnauthorized, revoke(manager.EmailVerificationToken(user, time.Now().Add(time.Hour))), "token of another link")
+
+	got, err := s.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	require.Nil(t, got.RevokedAt)
+
+	token := manager.RevokeLinkToken(session)
+	assert.Equal(t, http.StatusOK, revoke(token))
+	assert.Equal(t, http.StatusOK, revoke(token), "opening the link twice is fine")
+
+	got, err = s.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, got.RevokedAt)
+}
@@ -0,0 +1,158 @@
+package resend
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/background"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	DefaultLimit  = 3
+	DefaultWindow = time.Hour
+)
+
+type Request struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type UserProvider interface {
+	GetUser(ctx context.Context, email string) (models.User, error)
+	CountVerificationEmails(ctx context.Context, uid int64, since time.Time) (int, error)
+	SaveVerificationEmail(ctx context.Context, uid int64) error
+	EnqueueNotification(ctx context.Context, notification models.Notification) (int64, error)
+}
+
+// VerificationMailer renders the email verification email of the user
+type VerificationMailer interface {
+	Notification(user models.User) (models.Notification, error)
+}
+
+// Queue runs jobs one at a time in the background
+type Queue interface {
+	Enqueue(ctx context.Context, job background.Job) error
+}
+
+// Options of resending, zero values are replaced by defaults
+type Options struct {
+	// Limit of emails to one account per Window, further requests are dropped. Only registered
+	// unverified emails get verification emails, so requests for others are not counted
+	Limit  int
+	Window time.Duration
+}
+
+// New returns handler which sends the verification email again. Only looking up the user is done
+// on the request path, the rest is queued for the background, so the response is the same and takes
+// about the same time whether the email is registered, verified, rate limited or not, and it can't
+// be used to probe for accounts. The queue must run one job at a time, otherwise concurrent requests
+// outrun the limit
+func New(log *slog.Logger, userProvider UserProvider, verificationMailer VerificationMailer, queue Queue, opts Options) http.HandlerFunc {
+	if opts.Limit <= 0 {
+		opts.Limit = DefaultLimit
+	}
+	if opts.Window <= 0 {
+		opts.Window = DefaultWindow
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.verifyemail.resend.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		var req Request
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "empty request"))
+			return
+		}
+		if err != nil {
+			log.Error("failed to parse request body", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "failed to parse request"))
+			return
+		}
+
+		if err = validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+			log.Error("invalid request")
+			resp.WriteProblem(w, r, resp.ValidationProblem(validateErr))
+			return
+		}
+
+		user, err := userProvider.GetUser(r.Context(), req.Email)
+		switch {
+		case errors.Is(err, storage.ErrUserNotFound):
+			log.Info("verification email not sent: user not found")
+		case err != nil:
+			log.Error("failed to get user", sl.Err(err))
+			resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			return
+		case user.EmailVerified:
+			log.Info("verification email not sent: already verified", slog.Int64("user", user.UID))
+		default:
+			// Others never get here, so they can't fill the queue up for the unverified users
+			err = queue.Enqueue(r.Context(), func(ctx context.Context) {
+				sendVerification(ctx, log, userProvider, verificationMailer, opts, user)
+			})
+			if err != nil {
+				log.Error("failed to queue verification email", slog.Int64("user", user.UID), sl.Err(err))
+				resp.WriteProblem(w, r, resp.NewProblem(http.StatusServiceUnavailable, resp.CodeUnavailable, "try again later"))
+				return
+			}
+		}
+
+		render.JSON(w, r, resp.OK())
+	}
+}
+
+var errRateLimited = errors.New("too many verification emails to the user")
+
+// sendVerification enqueues the verification email of the user. Nobody waits for the outcome, so it is only logged
+func sendVerification(ctx context.Context, log *slog.Logger, userProvider UserProvider, verificationMailer VerificationMailer, opts Options, user models.User) {
+	log = log.With(slog.Int64("user", user.UID))
+
+	err := send(ctx, userProvider, verificationMailer, opts, user)
+	switch {
+	case errors.Is(err, errRateLimited):
+		log.Warn("verification email not sent: rate limited", slog.Int("limit", opts.Limit), slog.Duration("window", opts.Window))
+	case err != nil:
+		log.Error("verification email not sent", sl.Err(err))
+	default:
+		log.Info("verification email sent")
+	}
+}
+
+// send records and enqueues the verification email of the user unless the user has got too many of them
+func send(ctx context.Context, userProvider UserProvider, verificationMailer VerificationMailer, opts Options, user models.User) error {
+	count, err := userProvider.CountVerificationEmails(ctx, user.UID, time.Now().Add(-opts.Window))
+	if err != nil {
+		return err
+	}
+	if count >= opts.Limit {
+		return errRateLimited
+	}
+
+	notification, err := verificationMailer.Notification(user)
+	if err != nil {
+		return err
+	}
+
+	if err = userProvider.SaveVerificationEmail(ctx, user.UID); err != nil {
+		return err
+	}
+
+	_, err = userProvider.EnqueueNotification(ctx, notification)
+
+	return err
+}
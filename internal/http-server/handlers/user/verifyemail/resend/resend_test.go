@@ -0,0 +1,97 @@
+package resend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/northwindman/testREST-autentification/internal/lib/background"
+	"github.com/northwindman/testREST-autentification/internal/lib/emailverify"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/templates"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	manager := tokenstest.NewManager(t)
+
+	emails, err := templates.New(templates.Links{VerifyEmail: "https://example.com/verify?token={token}"})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+
+	verifiedUID, err := s.SaveUser(ctx, tokenstest.IP, "verified@example.com", []byte("hash"), "")
+	require.NoError(t, err)
+	verified, err := s.GetUserByID(ctx, verifiedUID)
+	require.NoError(t, err)
+	require.NoError(t, s.VerifyEmail(ctx, verifiedUID, verified.Email))
+
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	queue := background.New(log, background.Options{})
+	queue.Start()
+
+	handler := New(log, s, emailverify.New(manager, emails, time.Hour), queue, Options{Limit: 2})
+
+	resend := func(email string) *httptest.ResponseRecorder {
+		body, err := json.Marshal(Request{Email: email})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/verify-email/resend", bytes.NewReader(body)))
+
+		return rec
+	}
+
+	assert.Equal(t, http.StatusBadRequest, resend("not an email").Code)
+
+	unknown := resend("unknown@example.com")
+	for _, rec := range []*httptest.ResponseRecorder{resend(verified.Email), resend(user.Email), resend(user.Email), resend(user.Email)} {
+		assert.Equal(t, unknown.Code, rec.Code, "the response doesn't tell about the account")
+		assert.Equal(t, unknown.Body.String(), rec.Body.String(), "the response doesn't tell about the account")
+	}
+	require.Equal(t, http.StatusOK, unknown.Code)
+
+	// Shutdown waits for the queued emails
+	require.NoError(t, queue.Shutdown(ctx))
+
+	notifications, err := s.ClaimNotifications(ctx, 100, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, notifications, 2, "emails over the limit are not sent")
+	for _, notification := range notifications {
+		assert.Equal(t, user.Email, notification.To)
+	}
+}
+
+func TestNew_NoLink(t *testing.T) {
+	emails, err := templates.New(templates.Links{})
+	require.NoError(t, err)
+
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	queue := background.New(log, background.Options{})
+	queue.Start()
+
+	handler := New(log, s, emailverify.New(tokenstest.NewManager(t), emails, time.Hour), queue, Options{})
+
+	body, err := json.Marshal(Request{Email: user.Email})
+	require.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodPost, "/verify-email/resend", bytes.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code, "failures of the email are not seen by the caller")
+
+	require.NoError(t, queue.Shutdown(context.Background()))
+
+	count, err := s.CountVerificationEmails(context.Background(), user.UID, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, count, "unsent emails don't count")
+}
@@ -0,0 +1,82 @@
+package verifyemail
+
+import (
+	"context"
+	"errors"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"io"
+	"log/slog"
+	"net/http"
+)
+
+type Request struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type EmailVerifier interface {
+	VerifyEmail(ctx context.Context, uid int64, email string) error
+}
+
+// New returns handler which verifies the email by the token of the verification link.
+// A token works once: after the email is verified, it and every other token of the email are spent
+func New(log *slog.Logger, emailVerifier EmailVerifier, tokenManager *tokens.Manager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		const op = "handlers.user.verifyemail.New"
+
+		log := log.With(
+			slog.String("op", op),
+		)
+
+		var req Request
+		err := render.DecodeJSON(r.Body, &req)
+		if errors.Is(err, io.EOF) {
+			log.Error("request body is empty", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "empty request"))
+			return
+		}
+		if err != nil {
+			log.Error("failed to parse request body", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusBadRequest, resp.CodeInvalidRequest, "failed to parse request"))
+			return
+		}
+
+		if err = validator.New().Struct(req); err != nil {
+			validateErr := err.(validator.ValidationErrors)
+			log.Error("invalid request")
+			resp.WriteProblem(w, r, resp.ValidationProblem(validateErr))
+			return
+		}
+
+		uid, email, err := tokenManager.VerifyEmailVerificationToken(req.Token)
+		if err != nil {
+			log.Warn("invalid email verification token", sl.Err(err))
+			resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeInvalidToken, "invalid or expired link"))
+			return
+		}
+
+		if err = emailVerifier.VerifyEmail(r.Context(), uid, email); err != nil {
+			switch {
+			case errors.Is(err, storage.ErrUserNotFound):
+				// The user is gone or has another email now
+				log.Warn("user of the token not found", slog.Int64("user", uid))
+				resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeInvalidToken, "invalid or expired link"))
+			case errors.Is(err, storage.ErrEmailAlreadyVerified):
+				log.Warn("email already verified", slog.Int64("user", uid))
+				resp.WriteProblem(w, r, resp.NewProblem(http.StatusConflict, resp.CodeConflict, "email already verified"))
+			default:
+				log.Error("failed to verify email", sl.Err(err))
+				resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+			}
+			return
+		}
+
+		log.Info("email verified", slog.Int64("user", uid))
+
+		render.JSON(w, r, resp.OK())
+	}
+}
@@ -0,0 +1,55 @@
+package verifyemail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+	manager := tokenstest.NewManager(t)
+	s, user := tokenstest.NewStorage(t, []byte("hash"))
+	uid := user.UID
+
+	handler := New(slog.New(slog.NewTextHandler(io.Discard, nil)), s, manager)
+
+	verify := func(token string) int {
+		body, err := json.Marshal(Request{Token: token})
+		require.NoError(t, err)
+
+		rec := httptest.NewRecorder()
+		handler(rec, httptest.NewRequest(http.MethodPost, "/verify-email", bytes.NewReader(body)))
+
+		return rec.Code
+	}
+
+	valid := manager.EmailVerificationToken(user, time.Now().Add(time.Hour))
+
+	assert.Equal(t, http.StatusBadRequest, verify(""))
+	assert.Equal(t, http.StatusUnauthorized, verify("garbage"))
+	assert.Equal(t, http.StatusUnauthorized, verify(manager.EmailVerificationToken(user, time.Now().Add(-time.Second))), "expired")
+	assert.Equal(t, http.StatusUnauthorized, verify(manager.EmailVerificationToken(models.User{UID: uid, Email: "old@example.com"}, time.Now().Add(time.Hour))), "issued for another email")
+	assert.Equal(t, http.StatusUnauthorized, verify(manager.RevokeLinkToken(models.Session{ID: "1", ExpiresAt: time.Now().Add(time.Hour)})), "token of another link")
+
+	user, err := s.GetUserByID(ctx, uid)
+	require.NoError(t, err)
+	require.False(t, user.EmailVerified)
+
+	assert.Equal(t, http.StatusOK, verify(valid))
+	assert.Equal(t, http.StatusConflict, verify(valid), "the token works once")
+
+	user, err = s.GetUserByID(ctx, uid)
+	require.NoError(t, err)
+	assert.True(t, user.EmailVerified)
+}
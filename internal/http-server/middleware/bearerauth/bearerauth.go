@@ -0,0 +1,131 @@
+package bearerauth
+
+import (
+	"context"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/lib/api/request"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type ctxKey struct{}
+
+// Principal is the user authenticated by the access token of the request
+type Principal struct {
+	UID       int64
+	Email     string
+	Scopes    []string
+	SessionID string
+	// TokenID and ExpiresAt describe the access token itself, e.g. to revoke it
+	TokenID   string
+	ExpiresAt time.Time
+}
+
+// HasScope reports if the access token was granted the scope
+func (p Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+// New returns middleware which authenticates requests by "Authorization: Bearer" access token.
+// The token must be valid and neither it nor its session may be revoked,
+// the Principal of the token is put in the request context
+func New(log *slog.Logger, checker tokens.SessionChecker, tokenManager *tokens.Manager) func(next http.Handler) http.Handler {
+	log = log.With(
+		slog.String("component", "middleware/bearerauth"),
+	)
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			token, ok := request.BearerToken(r)
+			if !ok {
+				log.Warn("bearer token is missing")
+				unauthorized(w, r, "")
+				return
+			}
+
+			claims, err := tokenManager.Verify(r.Context(), token, checker)
+			if err != nil {
+				if tokens.IsInactive(err) {
+					log.Warn("token is not active", sl.Err(err))
+					unauthorized(w, r, "invalid_token")
+					return
+				}
+
+				log.Error("failed to verify token", sl.Err(err))
+				resp.WriteProblem(w, r, resp.StorageProblem(err, "internal error"))
+				return
+			}
+
+			uid, err := strconv.ParseInt(claims.Subject, 10, 64)
+			if err != nil {
+				log.Warn("invalid subject", sl.Err(err))
+				unauthorized(w, r, "invalid_token")
+				return
+			}
+
+			principal := Principal{
+				UID:       uid,
+				Email:     claims.Email,
+				Scopes:    strings.Fields(claims.Scope),
+				SessionID: claims.SessionID,
+				TokenID:   claims.ID,
+				ExpiresAt: claims.ExpiresAt.Time,
+			}
+
+			ctx := context.WithValue(r.Context(), ctxKey{}, principal)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// RequireScope returns middleware which lets through only principals granted the scope.
+// It must be used after New
+func RequireScope(scope string) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			principal, ok := FromContext(r.Context())
+			if !ok {
+				unauthorized(w, r, "")
+				return
+			}
+
+			if !principal.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope="%s"`, scope))
+				resp.WriteProblem(w, r, resp.NewProblem(http.StatusForbidden, resp.CodeInsufficientScope, "insufficient scope"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// FromContext returns the principal authenticated by the middleware
+func FromContext(ctx context.Context) (Principal, bool) {
+	principal, ok := ctx.Value(ctxKey{}).(Principal)
+
+	return principal, ok
+}
+
+// unauthorized responds with 401 and the challenge of RFC 6750, section 3
+func unauthorized(w http.ResponseWriter, r *http.Request, errorCode string) {
+	challenge := "Bearer"
+	if errorCode != "" {
+		challenge = fmt.Sprintf(`Bearer error="%s"`, errorCode)
+	}
+
+	w.Header().Set("WWW-Authenticate", challenge)
+	resp.WriteProblem(w, r, resp.NewProblem(http.StatusUnauthorized, resp.CodeUnauthorized, "unauthorized"))
+}
@@ -0,0 +1,119 @@
+package bearerauth
+
+import (
+	"context"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+type fakeChecker struct {
+	sessions map[string]models.Session
+	revoked  map[string]bool
+}
+
+func (c *fakeChecker) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	return c.revoked[jti], nil
+}
+
+func (c *fakeChecker) GetSession(ctx context.Context, id string) (models.Session, error) {
+	session, ok := c.sessions[id]
+	if !ok {
+		return models.Session{}, storage.ErrSessionNotFound
+	}
+
+	return session, nil
+}
+
+func setup(t *testing.T) (*tokens.Manager, *fakeChecker, models.Session, string) {
+	opts := tokenstest.Options
+	opts.Scope = "profile"
+	manager := tokenstest.NewManagerWith(t, opts)
+
+	session, token, err := manager.NewSession(models.User{UID: 42, Email: "test@example.com"}, "127.0.0.1", "test")
+	require.NoError(t, err)
+
+	checker := &fakeChecker{
+		sessions: map[string]models.Session{session.ID: session},
+		revoked:  map[string]bool{},
+	}
+
+	return manager, checker, session, token.AccessToken
+}
+
+func serve(handler http.Handler, token string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	return w
+}
+
+func TestNew_Success(t *testing.T) {
+	manager, checker, session, token := setup(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	var principal Principal
+	handler := New(log, checker, manager)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var ok bool
+		principal, ok = FromContext(r.Context())
+		require.True(t, ok)
+	}))
+
+	w := serve(handler, token)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(42), principal.UID)
+	assert.Equal(t, "test@example.com", principal.Email)
+	assert.Equal(t, session.ID, principal.SessionID)
+	assert.Equal(t, []string{"profile"}, principal.Scopes)
+}
+
+func TestNew_Unauthorized(t *testing.T) {
+	manager, checker, session, token := setup(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	handler := New(log, checker, manager)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Fatal("handler must not be called")
+	}))
+
+	w := serve(handler, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, "Bearer", w.Header().Get("WWW-Authenticate"))
+
+	w = serve(handler, "invalid.token")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+
+	now := time.Now()
+	session.RevokedAt = &now
+	checker.sessions[session.ID] = session
+
+	w = serve(handler, token)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestRequireScope(t *testing.T) {
+	manager, checker, _, token := setup(t)
+	log := slog.New(slog.NewTextHandler(io.Discard, nil))
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	w := serve(New(log, checker, manager)(RequireScope("profile")(ok)), token)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	w = serve(New(log, checker, manager)(RequireScope("sessions")(ok)), token)
+	assert.Equal(t, http.StatusForbidden, w.Code)
+}
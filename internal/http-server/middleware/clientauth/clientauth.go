@@ -0,0 +1,53 @@
+package clientauth
+
+import (
+	"context"
+	"crypto/subtle"
+	"github.com/go-chi/render"
+	resp "github.com/northwindman/testREST-autentification/internal/lib/api/response"
+	"log/slog"
+	"net/http"
+)
+
+type ctxKey struct{}
+
+// New returns middleware which authenticates OAuth clients by their credentials (RFC 6749, section 2.3.1),
+// passed with HTTP Basic authentication or client_id and client_secret form fields.
+// clients maps client id to its secret
+func New(log *slog.Logger, clients map[string]string) func(next http.Handler) http.Handler {
+	log = log.With(
+		slog.String("component", "middleware/clientauth"),
+	)
+
+	return func(next http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			id, secret, ok := r.BasicAuth()
+			if !ok {
+				id, secret = r.PostFormValue("client_id"), r.PostFormValue("client_secret")
+			}
+
+			expected, known := clients[id]
+			if id == "" || !known || expected == "" || subtle.ConstantTimeCompare([]byte(secret), []byte(expected)) != 1 {
+				log.Warn("client authentication failed", slog.String("client_id", id))
+
+				w.Header().Set("WWW-Authenticate", `Basic realm="clients"`)
+				render.Status(r, http.StatusUnauthorized)
+				render.JSON(w, r, resp.OAuthError{Error: resp.OAuthInvalidClient})
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), ctxKey{}, id)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		}
+
+		return http.HandlerFunc(fn)
+	}
+}
+
+// ClientID returns id of the client authenticated by the middleware
+func ClientID(ctx context.Context) string {
+	id, _ := ctx.Value(ctxKey{}).(string)
+
+	return id
+}
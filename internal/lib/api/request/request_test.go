@@ -0,0 +1,35 @@
+package request
+
+import (
+	"github.com/stretchr/testify/assert"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		token  string
+		ok     bool
+	}{
+		{"valid", "Bearer abc.def.ghi", "abc.def.ghi", true},
+		{"lowercase scheme", "bearer abc", "abc", true},
+		{"empty header", "", "", false},
+		{"basic scheme", "Basic dXNlcjpwYXNz", "", false},
+		{"empty token", "Bearer ", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+
+			token, ok := BearerToken(r)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.token, token)
+		})
+	}
+}
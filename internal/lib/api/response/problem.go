@@ -0,0 +1,130 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"github.com/northwindman/testREST-autentification/internal/lib/password"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"net/http"
+)
+
+const ProblemContentType = "application/problem+json"
+
+// Stable machine-readable codes of problems, clients should branch on them rather than on the detail
+const (
+	CodeInvalidRequest     = "invalid_request"
+	CodeValidationFailed   = "validation_failed"
+	CodeInvalidCredentials = "invalid_credentials"
+	CodeInvalidToken       = "invalid_token"
+	CodeUnauthorized       = "unauthorized"
+	CodeInsufficientScope  = "insufficient_scope"
+	CodeNotFound           = "not_found"
+	CodeUserExists         = "user_exists"
+	CodeEmailNotVerified   = "email_not_verified"
+	CodeConflict           = "conflict"
+	CodeConstraint         = "constraint_violation"
+	CodeInternal           = "internal_error"
+	CodeUnavailable        = "service_unavailable"
+)
+
+// Problem is an error response in the format of RFC 7807 extended with the code
+// and, for invalid requests, the errors of every field
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError describes why a field of the request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// NewProblem returns a problem without a type of its own, so its title is the status text (RFC 7807, section 4.2)
+func NewProblem(status int, code string, detail string) Problem {
+	return Problem{
+		Type:   "about:blank",
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// ValidationProblem describes every invalid field of the request
+func ValidationProblem(errs validator.ValidationErrors) Problem {
+	problem := NewProblem(http.StatusBadRequest, CodeValidationFailed, "request has invalid fields")
+
+	for _, err := range errs {
+		problem.Errors = append(problem.Errors, FieldError{
+			Field:   err.Field(),
+			Code:    err.ActualTag(),
+			Message: fieldMessage(err),
+		})
+	}
+
+	return problem
+}
+
+// PasswordProblem reports violations of the password policy as errors of the Password field
+func PasswordProblem(violations []password.Violation) Problem {
+	problem := NewProblem(http.StatusBadRequest, CodeValidationFailed, "password does not satisfy the policy")
+
+	for _, violation := range violations {
+		problem.Errors = append(problem.Errors, FieldError{
+			Field:   "Password",
+			Code:    violation.Code,
+			Message: violation.Message,
+		})
+	}
+
+	return problem
+}
+
+// StorageProblem picks the status and the code by the kind of the storage error
+func StorageProblem(err error, detail string) Problem {
+	status := StorageStatus(err)
+
+	code := CodeInternal
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		code = CodeNotFound
+	case errors.Is(err, storage.ErrConflict):
+		code = CodeConflict
+	case errors.Is(err, storage.ErrConstraint):
+		code = CodeConstraint
+	case errors.Is(err, storage.ErrTransient):
+		code = CodeUnavailable
+	}
+
+	return NewProblem(status, code, detail)
+}
+
+// WriteProblem writes the problem as application/problem+json with its status
+func WriteProblem(w http.ResponseWriter, r *http.Request, problem Problem) {
+	if problem.Instance == "" {
+		problem.Instance = r.URL.Path
+	}
+
+	w.Header().Set("Content-Type", ProblemContentType)
+	w.WriteHeader(problem.Status)
+	_ = json.NewEncoder(w).Encode(problem)
+}
+
+func fieldMessage(err validator.FieldError) string {
+	switch err.ActualTag() {
+	case "required":
+		return "field " + err.Field() + " is a required field"
+	case "email":
+		return "field " + err.Field() + " is not a valid email"
+	default:
+		return "field " + err.Field() + " is not valid"
+	}
+}
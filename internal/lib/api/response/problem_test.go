@@ -0,0 +1,52 @@
+package response
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/go-playground/validator/v10"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteProblem(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/login", nil)
+	w := httptest.NewRecorder()
+
+	WriteProblem(w, r, NewProblem(http.StatusUnauthorized, CodeInvalidCredentials, "invalid credentials"))
+
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	assert.Equal(t, ProblemContentType, w.Header().Get("Content-Type"))
+
+	var problem Problem
+	require.NoError(t, json.Unmarshal(w.Body.Bytes(), &problem))
+	assert.Equal(t, Problem{
+		Type:     "about:blank",
+		Title:    "Unauthorized",
+		Status:   http.StatusUnauthorized,
+		Detail:   "invalid credentials",
+		Instance: "/login",
+		Code:     CodeInvalidCredentials,
+	}, problem)
+}
+
+func TestValidationProblem(t *testing.T) {
+	req := struct {
+		Email    string `validate:"required,email"`
+		Password string `validate:"required"`
+	}{Email: "not an email"}
+
+	var errs validator.ValidationErrors
+	require.True(t, errors.As(validator.New().Struct(req), &errs))
+
+	problem := ValidationProblem(errs)
+
+	assert.Equal(t, http.StatusBadRequest, problem.Status)
+	assert.Equal(t, CodeValidationFailed, problem.Code)
+	assert.Equal(t, []FieldError{
+		{Field: "Email", Code: "email", Message: "field Email is not a valid email"},
+		{Field: "Password", Code: "required", Message: "field Password is a required field"},
+	}, problem.Errors)
+}
@@ -1,20 +1,11 @@
 package response
 
-import (
-	"fmt"
-	"github.com/go-playground/validator/v10"
-	"strings"
-)
-
+// Response is the body of successful responses, failures are described by Problem
 type Response struct {
 	Status string `json:"status"`
-	Error  string `json:"error,omitempty"`
 }
 
-const (
-	StatusOK    = "OK"
-	StatusError = "Error"
-)
+const StatusOK = "OK"
 
 func OK() Response {
 	return Response{
@@ -22,29 +13,14 @@ func OK() Response {
 	}
 }
 
-func Error(msg string) Response {
-	return Response{
-		Status: StatusError,
-		Error:  msg,
-	}
+// OAuthError is an error response of OAuth endpoints (RFC 6749, section 5.2)
+type OAuthError struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
 }
 
-func ValidationError(errs validator.ValidationErrors) Response {
-	var errMsgs []string
-
-	for _, err := range errs {
-		switch err.ActualTag() {
-		case "required":
-			errMsgs = append(errMsgs, fmt.Sprintf("field %s is a required field", err.Field()))
-		case "email":
-			errMsgs = append(errMsgs, fmt.Sprintf("field %s is not a valid email", err.Field()))
-		default:
-			errMsgs = append(errMsgs, fmt.Sprintf("field %s is not valid", err.Field()))
-		}
-	}
-
-	return Response{
-		Status: StatusError,
-		Error:  strings.Join(errMsgs, ", "),
-	}
-}
+const (
+	OAuthInvalidRequest = "invalid_request"
+	OAuthInvalidClient  = "invalid_client"
+	OAuthServerError    = "server_error"
+)
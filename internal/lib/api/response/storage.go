@@ -0,0 +1,23 @@
+package response
+
+import (
+	"errors"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"net/http"
+)
+
+// StorageStatus returns the HTTP status for the kind of the storage error
+func StorageStatus(err error) int {
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, storage.ErrConflict):
+		return http.StatusConflict
+	case errors.Is(err, storage.ErrConstraint):
+		return http.StatusBadRequest
+	case errors.Is(err, storage.ErrTransient):
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,31 @@
+package response
+
+import (
+	"errors"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"testing"
+)
+
+func TestStorageStatus(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{storage.ErrUserNotFound, http.StatusNotFound},
+		{storage.ErrSessionNotFound, http.StatusNotFound},
+		{storage.ErrAlreadyExist, http.StatusConflict},
+		{storage.ErrStaleSession, http.StatusConflict},
+		{fmt.Errorf("op: %w: fk", storage.ErrConstraint), http.StatusBadRequest},
+		{fmt.Errorf("op: %w: timeout", storage.ErrTransient), http.StatusServiceUnavailable},
+		{errors.New("unknown"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			assert.Equal(t, tt.status, StorageStatus(tt.err))
+		})
+	}
+}
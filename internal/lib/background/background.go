@@ -0,0 +1,161 @@
+// Package background runs work of handlers off the request path, one job at a time in the order
+// the jobs were queued, so jobs of concurrent requests can't race each other
+package background
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultSize    = 100
+	DefaultWait    = time.Second
+	DefaultTimeout = 10 * time.Second
+)
+
+// ErrStopped means the queue is shutting down and takes no more jobs
+var ErrStopped = errors.New("background queue is stopped")
+
+// Job is run by the worker, ctx is done when the job runs out of time or shutdown gives up on it
+type Job func(ctx context.Context)
+
+// Options of the queue, zero values take the defaults
+type Options struct {
+	// Size is how many jobs may wait for the worker
+	Size int
+	// Wait is how long Enqueue waits for room in the full queue
+	Wait time.Duration
+	// Timeout limits every job, so a stuck one doesn't hold the ones queued after it
+	Timeout time.Duration
+}
+
+// Queue hands jobs to a single worker
+type Queue struct {
+	log  *slog.Logger
+	opts Options
+
+	jobs chan Job
+	stop chan struct{}
+	// ctx of jobs, canceled when shutdown runs out of time
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	start    sync.Once
+	shutdown sync.Once
+	wg       sync.WaitGroup
+}
+
+func New(log *slog.Logger, opts Options) *Queue {
+	if opts.Size <= 0 {
+		opts.Size = DefaultSize
+	}
+	if opts.Wait <= 0 {
+		opts.Wait = DefaultWait
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Queue{
+		log:    log.With(slog.String("component", "background")),
+		opts:   opts,
+		jobs:   make(chan Job, opts.Size),
+		stop:   make(chan struct{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Start runs the worker in the background
+func (q *Queue) Start() {
+	q.start.Do(func() {
+		q.wg.Add(1)
+
+		go q.work()
+	})
+}
+
+// Enqueue adds the job to the queue. When the queue is full it waits for room
+// up to the Wait of the options or until ctx is done
+func (q *Queue) Enqueue(ctx context.Context, job Job) error {
+	select {
+	case <-q.stop:
+		return ErrStopped
+	default:
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, q.opts.Wait)
+	defer cancel()
+
+	select {
+	case q.jobs <- job:
+		return nil
+	case <-q.stop:
+		return ErrStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Shutdown stops taking jobs and waits until the queued ones are done.
+// When ctx is done first, the jobs are canceled and the ones not started yet are dropped
+func (q *Queue) Shutdown(ctx context.Context) error {
+	q.shutdown.Do(func() { close(q.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		if dropped := len(q.jobs); dropped > 0 {
+			q.log.Error("background jobs dropped on shutdown", slog.Int("count", dropped))
+		}
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+
+	for {
+		select {
+		case job := <-q.jobs:
+			q.run(job)
+		case <-q.stop:
+			q.drain()
+			return
+		}
+	}
+}
+
+// drain runs the jobs queued before shutdown, unless shutdown has given up on them
+func (q *Queue) drain() {
+	for q.ctx.Err() == nil {
+		select {
+		case job := <-q.jobs:
+			q.run(job)
+		default:
+			return
+		}
+	}
+}
+
+func (q *Queue) run(job Job) {
+	ctx, cancel := context.WithTimeout(q.ctx, q.opts.Timeout)
+	defer cancel()
+
+	job(ctx)
+}
@@ -0,0 +1,79 @@
+package background
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func TestQueue_InOrder(t *testing.T) {
+	q := New(discard, Options{})
+	q.Start()
+
+	var done []int
+	for i := 0; i < 10; i++ {
+		require.NoError(t, q.Enqueue(context.Background(), func(ctx context.Context) {
+			done = append(done, i)
+		}))
+	}
+
+	require.NoError(t, q.Shutdown(context.Background()))
+	assert.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, done, "shutdown waits for the queued jobs")
+
+	assert.ErrorIs(t, q.Enqueue(context.Background(), func(ctx context.Context) {}), ErrStopped)
+}
+
+func TestQueue_Timeout(t *testing.T) {
+	q := New(discard, Options{Timeout: 10 * time.Millisecond})
+	q.Start()
+
+	var stuckErr error
+	require.NoError(t, q.Enqueue(context.Background(), func(ctx context.Context) {
+		<-ctx.Done()
+		stuckErr = ctx.Err()
+	}))
+
+	next := make(chan struct{})
+	require.NoError(t, q.Enqueue(context.Background(), func(ctx context.Context) {
+		close(next)
+	}))
+
+	select {
+	case <-next:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stuck job holds the queue")
+	}
+
+	require.NoError(t, q.Shutdown(context.Background()))
+	assert.ErrorIs(t, stuckErr, context.DeadlineExceeded)
+}
+
+func TestQueue_Full(t *testing.T) {
+	q := New(discard, Options{Size: 1, Wait: 10 * time.Millisecond})
+
+	require.NoError(t, q.Enqueue(context.Background(), func(ctx context.Context) {}))
+	assert.ErrorIs(t, q.Enqueue(context.Background(), func(ctx context.Context) {}), context.DeadlineExceeded, "waits for room no longer than Wait")
+}
+
+func TestQueue_ShutdownTimeout(t *testing.T) {
+	q := New(discard, Options{Timeout: time.Minute})
+	q.Start()
+
+	var canceled bool
+	require.NoError(t, q.Enqueue(context.Background(), func(ctx context.Context) {
+		<-ctx.Done()
+		canceled = true
+	}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, q.Shutdown(ctx), context.DeadlineExceeded)
+	assert.True(t, canceled, "running jobs are canceled when shutdown runs out of time")
+}
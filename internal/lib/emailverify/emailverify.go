@@ -0,0 +1,59 @@
+// Package emailverify makes the emails which confirm users own their addresses
+package emailverify
+
+import (
+	"errors"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/outbox"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/templates"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
+	"time"
+)
+
+const DefaultTTL = 24 * time.Hour
+
+var ErrNoLink = errors.New("email verification link is not configured")
+
+// Mailer renders verification emails with links valid for TTL
+type Mailer struct {
+	tokenManager *tokens.Manager
+	emails       *templates.Set
+	ttl          time.Duration
+}
+
+func New(tokenManager *tokens.Manager, emails *templates.Set, ttl time.Duration) *Mailer {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Mailer{
+		tokenManager: tokenManager,
+		emails:       emails,
+		ttl:          ttl,
+	}
+}
+
+// Notification returns the verification email of the user to be put in the outbox
+func (m *Mailer) Notification(user models.User) (models.Notification, error) {
+	const op = "lib.emailverify.Notification"
+
+	now := time.Now()
+
+	verifyLink := m.emails.VerifyEmailLink(m.tokenManager.EmailVerificationToken(user, now.Add(m.ttl)))
+	if verifyLink == "" {
+		return models.Notification{}, fmt.Errorf("%s: %w", op, ErrNoLink)
+	}
+
+	msg, err := m.emails.Render(templates.EmailVerification, user.Locale, user.Email, templates.Data{
+		Email:    user.Email,
+		Time:     now,
+		Link:     verifyLink,
+		ValidFor: m.ttl,
+	})
+	if err != nil {
+		return models.Notification{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return outbox.Notification(msg), nil
+}
@@ -0,0 +1,52 @@
+package emailverify
+
+import (
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/templates"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMailer_Notification(t *testing.T) {
+	manager := tokenstest.NewManager(t)
+	emails, err := templates.New(templates.Links{VerifyEmail: "https://example.com/verify?token={token}"})
+	require.NoError(t, err)
+
+	user := models.User{UID: 42, Email: "user@example.com", Locale: "ru"}
+
+	notification, err := New(manager, emails, 0).Notification(user)
+	require.NoError(t, err)
+
+	assert.Equal(t, "user@example.com", notification.To)
+	assert.Equal(t, "Подтвердите адрес электронной почты", notification.Subject)
+	assert.Contains(t, notification.Body, "24 ч.")
+	assert.NotEmpty(t, notification.HTML)
+
+	_, rawToken, found := strings.Cut(notification.Body, "https://example.com/verify?token=")
+	require.True(t, found)
+	rawToken, _, _ = strings.Cut(rawToken, "\n")
+	token, err := url.QueryUnescape(rawToken)
+	require.NoError(t, err)
+
+	uid, email, err := manager.VerifyEmailVerificationToken(token)
+	require.NoError(t, err)
+	assert.Equal(t, int64(42), uid)
+	assert.Equal(t, "user@example.com", email)
+
+	_, err = manager.VerifyRevokeLinkToken(token)
+	assert.ErrorIs(t, err, tokens.ErrInvalidToken, "tokens of other links are not accepted")
+}
+
+func TestMailer_NoLink(t *testing.T) {
+	emails, err := templates.New(templates.Links{})
+	require.NoError(t, err)
+
+	_, err = New(tokenstest.NewManager(t), emails, time.Hour).Notification(models.User{UID: 1, Email: "user@example.com"})
+	assert.ErrorIs(t, err, ErrNoLink)
+}
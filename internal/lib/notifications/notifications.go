@@ -0,0 +1,115 @@
+package notifications
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+)
+
+var (
+	ErrInvalidMessage = errors.New("invalid message")
+	// ErrPermanent marks failures which retrying won't fix, e.g. an unknown mailbox
+	ErrPermanent = errors.New("permanent failure")
+)
+
+// Message is a notification for a single recipient, e.g. an email
+type Message struct {
+	To      string `json:"to"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+	// HTML is an optional alternative of the plain text Body
+	HTML string `json:"html,omitempty"`
+}
+
+// Validate rejects messages which can't be delivered or would inject headers
+func (m Message) Validate() error {
+	if m.To == "" {
+		return fmt.Errorf("%w: empty recipient", ErrInvalidMessage)
+	}
+	if strings.ContainsAny(m.To, "\r\n") || strings.ContainsAny(m.Subject, "\r\n") {
+		return fmt.Errorf("%w: line break in a header", ErrInvalidMessage)
+	}
+
+	return nil
+}
+
+// Notifier delivers messages. Errors are returned as is, so the caller decides to retry or give up,
+// the ones known to be final wrap ErrPermanent
+type Notifier interface {
+	Notify(ctx context.Context, msg Message) error
+}
+
+// Template renders messages of one kind, subject and body are text/template, the optional HTML is html/template
+type Template struct {
+	subject *template.Template
+	body    *template.Template
+	html    *htmltemplate.Template
+}
+
+func NewTemplate(name string, subject string, body string) (*Template, error) {
+	const op = "lib.notifications.NewTemplate"
+
+	subjectTmpl, err := template.New(name + ".subject").Option("missingkey=error").Parse(subject)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	bodyTmpl, err := template.New(name + ".body").Option("missingkey=error").Parse(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Template{subject: subjectTmpl, body: bodyTmpl}, nil
+}
+
+// NewHTMLTemplate is NewTemplate with the HTML alternative of the body
+func NewHTMLTemplate(name string, subject string, body string, html string) (*Template, error) {
+	const op = "lib.notifications.NewHTMLTemplate"
+
+	tmpl, err := NewTemplate(name, subject, body)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl.html, err = htmltemplate.New(name + ".html").Option("missingkey=error").Parse(html)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return tmpl, nil
+}
+
+// Render returns the message to the recipient with data put in the subject and body
+func (t *Template) Render(to string, data any) (Message, error) {
+	const op = "lib.notifications.Template.Render"
+
+	var subject, body, html bytes.Buffer
+
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return Message{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if err := t.body.Execute(&body, data); err != nil {
+		return Message{}, fmt.Errorf("%s: %w", op, err)
+	}
+	if t.html != nil {
+		if err := t.html.Execute(&html, data); err != nil {
+			return Message{}, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	return Message{
+		To:      to,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    body.String(),
+		HTML:    html.String(),
+	}, nil
+}
+
+// IsPermanent reports if delivery of the message failed for good
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrPermanent) || errors.Is(err, ErrInvalidMessage)
+}
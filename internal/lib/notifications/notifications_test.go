@@ -0,0 +1,66 @@
+package notifications
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestTemplate_Render(t *testing.T) {
+	tmpl, err := NewTemplate("test", "Hello, {{.Name}}", "Sign-in from {{.IP}}\n")
+	require.NoError(t, err)
+
+	msg, err := tmpl.Render("user@example.com", map[string]string{"Name": "user", "IP": "192.0.2.1"})
+	require.NoError(t, err)
+
+	assert.Equal(t, Message{To: "user@example.com", Subject: "Hello, user", Body: "Sign-in from 192.0.2.1\n"}, msg)
+
+	_, err = tmpl.Render("user@example.com", map[string]string{"Name": "user"})
+	assert.Error(t, err, "missing data must not render as <no value>")
+}
+
+func TestHTMLTemplate_Render(t *testing.T) {
+	tmpl, err := NewHTMLTemplate("test", "Hello", "Sign-in from {{.}}\n", "<p>Sign-in from {{.}}</p>")
+	require.NoError(t, err)
+
+	msg, err := tmpl.Render("user@example.com", "<script>")
+	require.NoError(t, err)
+
+	assert.Equal(t, "Sign-in from <script>\n", msg.Body)
+	assert.Equal(t, "<p>Sign-in from &lt;script&gt;</p>", msg.HTML, "data is escaped in HTML")
+
+	_, err = NewHTMLTemplate("test", "Hello", "body", "{{.Name")
+	assert.Error(t, err)
+}
+
+func TestNewTemplate_Invalid(t *testing.T) {
+	_, err := NewTemplate("test", "{{.Name", "body")
+	assert.Error(t, err)
+
+	_, err = NewTemplate("test", "subject", "{{end}}")
+	assert.Error(t, err)
+}
+
+func TestMessage_Validate(t *testing.T) {
+	tests := []struct {
+		name  string
+		msg   Message
+		valid bool
+	}{
+		{"valid", Message{To: "user@example.com", Subject: "subject", Body: "line\nline"}, true},
+		{"no recipient", Message{Subject: "subject"}, false},
+		{"line break in recipient", Message{To: "user@example.com\r\nBcc: x@example.com"}, false},
+		{"line break in subject", Message{To: "user@example.com", Subject: "a\nb"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.msg.Validate()
+			if tt.valid {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorIs(t, err, ErrInvalidMessage)
+			}
+		})
+	}
+}
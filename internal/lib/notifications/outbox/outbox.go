@@ -0,0 +1,248 @@
+// Package outbox delivers notifications stored in the outbox table by a pool of workers,
+// retrying failures with exponential backoff and dead-lettering the ones which keep failing
+package outbox
+
+import (
+	"context"
+	"errors"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/lib/logger/sl"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications"
+	"log/slog"
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultWorkers      = 4
+	DefaultPollInterval = time.Second
+	DefaultMaxAttempts  = 8
+	DefaultMinBackoff   = 30 * time.Second
+	DefaultMaxBackoff   = time.Hour
+	DefaultLease        = time.Minute
+)
+
+// Notification returns the message as a notification to be stored in the outbox
+func Notification(msg notifications.Message) models.Notification {
+	return models.Notification{To: msg.To, Subject: msg.Subject, Body: msg.Body, HTML: msg.HTML}
+}
+
+// Store is the storage side of the outbox
+type Store interface {
+	ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]models.Notification, error)
+	DeleteNotification(ctx context.Context, id int64) error
+	RetryNotification(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error
+	DeadLetterNotification(ctx context.Context, id int64, lastError string) error
+}
+
+// Options of the pool, zero values take the defaults
+type Options struct {
+	Workers      int
+	PollInterval time.Duration
+	// MaxAttempts is how many times a notification is tried before it is dead-lettered
+	MaxAttempts int
+	// MinBackoff is the delay after the first failure, it doubles with every next one up to MaxBackoff
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Lease is how long a claimed notification is hidden from other claims,
+	// it must outlive a delivery or the notification may be sent twice
+	Lease time.Duration
+}
+
+// Pool claims due notifications in batches and hands them to the workers
+type Pool struct {
+	log      *slog.Logger
+	store    Store
+	notifier notifications.Notifier
+	opts     Options
+
+	jobs chan models.Notification
+	stop chan struct{}
+	// ctx of deliveries, canceled when shutdown runs out of time
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	start    sync.Once
+	shutdown sync.Once
+	wg       sync.WaitGroup
+}
+
+func New(log *slog.Logger, store Store, notifier notifications.Notifier, opts Options) *Pool {
+	if opts.Workers <= 0 {
+		opts.Workers = DefaultWorkers
+	}
+	if opts.PollInterval <= 0 {
+		opts.PollInterval = DefaultPollInterval
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = DefaultMinBackoff
+	}
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(DefaultMaxBackoff, opts.MinBackoff)
+	}
+	if opts.Lease <= 0 {
+		opts.Lease = DefaultLease
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &Pool{
+		log:      log.With(slog.String("component", "outbox")),
+		store:    store,
+		notifier: notifier,
+		opts:     opts,
+		jobs:     make(chan models.Notification),
+		stop:     make(chan struct{}),
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+}
+
+// Start runs the poller and the workers in the background
+func (p *Pool) Start() {
+	p.start.Do(func() {
+		p.wg.Add(1 + p.opts.Workers)
+
+		go p.poll()
+		for i := 0; i < p.opts.Workers; i++ {
+			go p.work()
+		}
+	})
+}
+
+// Shutdown stops claiming notifications and waits for deliveries in progress.
+// When ctx is done first, the deliveries are canceled and retried later
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.shutdown.Do(func() { close(p.stop) })
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		p.cancel()
+		return nil
+	case <-ctx.Done():
+		p.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) poll() {
+	defer p.wg.Done()
+	defer close(p.jobs)
+
+	ticker := time.NewTicker(p.opts.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		// A full batch means there may be more due, so claim again right away
+		if full := p.dispatch(); full {
+			select {
+			case <-p.stop:
+				return
+			default:
+				continue
+			}
+		}
+
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dispatch claims a batch of notifications and hands them to the workers, it reports if the batch was full
+func (p *Pool) dispatch() bool {
+	batch, err := p.store.ClaimNotifications(p.ctx, p.opts.Workers, p.opts.Lease)
+	if err != nil {
+		p.log.Error("failed to claim notifications", sl.Err(err))
+		return false
+	}
+
+	for _, notification := range batch {
+		select {
+		case p.jobs <- notification:
+		case <-p.stop:
+			// The rest is claimed again when the lease ends
+			return false
+		}
+	}
+
+	return len(batch) == p.opts.Workers
+}
+
+func (p *Pool) work() {
+	defer p.wg.Done()
+
+	for notification := range p.jobs {
+		p.deliver(notification)
+	}
+}
+
+func (p *Pool) deliver(notification models.Notification) {
+	log := p.log.With(
+		slog.Int64("notification_id", notification.ID),
+		slog.Int("attempt", notification.Attempts),
+	)
+
+	err := p.notifier.Notify(p.ctx, notifications.Message{
+		To:      notification.To,
+		Subject: notification.Subject,
+		Body:    notification.Body,
+		HTML:    notification.HTML,
+	})
+
+	// Results are saved even when shutdown cancels the delivery
+	ctx := context.WithoutCancel(p.ctx)
+
+	switch {
+	case err == nil:
+		if err = p.store.DeleteNotification(ctx, notification.ID); err != nil {
+			log.Error("failed to delete delivered notification", sl.Err(err))
+		}
+
+		log.Debug("notification delivered")
+	case errors.Is(err, context.Canceled) && p.ctx.Err() != nil:
+		// Interrupted by shutdown, that is not the notification's fault
+		if err = p.store.RetryNotification(ctx, notification.ID, time.Now(), err.Error()); err != nil {
+			log.Error("failed to reschedule notification", sl.Err(err))
+		}
+	case notifications.IsPermanent(err) || notification.Attempts >= p.opts.MaxAttempts:
+		log.Error("notification is dead", sl.Err(err))
+
+		if err = p.store.DeadLetterNotification(ctx, notification.ID, err.Error()); err != nil {
+			log.Error("failed to dead-letter notification", sl.Err(err))
+		}
+	default:
+		next := time.Now().Add(p.backoff(notification.Attempts))
+
+		log.Warn("failed to deliver notification, will retry", sl.Err(err), slog.Time("next_attempt_at", next))
+
+		if err = p.store.RetryNotification(ctx, notification.ID, next, err.Error()); err != nil {
+			log.Error("failed to reschedule notification", sl.Err(err))
+		}
+	}
+}
+
+// backoff returns the delay after the failed attempt: MinBackoff doubled for every
+// previous attempt up to MaxBackoff, with jitter so failed notifications don't retry in lockstep
+func (p *Pool) backoff(attempt int) time.Duration {
+	delay := p.opts.MinBackoff
+	for i := 1; i < attempt && delay < p.opts.MaxBackoff; i++ {
+		delay *= 2
+	}
+	delay = min(delay, p.opts.MaxBackoff)
+
+	return delay/2 + rand.N(delay/2+1)
+}
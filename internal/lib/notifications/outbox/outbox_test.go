@@ -0,0 +1,198 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications"
+	"github.com/northwindman/testREST-autentification/internal/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+)
+
+type notifierFunc func(ctx context.Context, msg notifications.Message) error
+
+func (f notifierFunc) Notify(ctx context.Context, msg notifications.Message) error {
+	return f(ctx, msg)
+}
+
+var discard = slog.New(slog.NewTextHandler(io.Discard, nil))
+
+func fastOptions() Options {
+	return Options{
+		Workers:      2,
+		PollInterval: 5 * time.Millisecond,
+		MaxAttempts:  3,
+		MinBackoff:   time.Millisecond,
+		MaxBackoff:   5 * time.Millisecond,
+		Lease:        time.Minute,
+	}
+}
+
+func enqueue(t *testing.T, s *memory.Storage, to string) int64 {
+	t.Helper()
+
+	id, err := s.EnqueueNotification(context.Background(), models.Notification{To: to, Subject: "subject", Body: "body", HTML: "<p>body</p>"})
+	require.NoError(t, err)
+
+	return id
+}
+
+func TestPool_Deliver(t *testing.T) {
+	s := memory.New()
+
+	var (
+		mu        sync.Mutex
+		delivered = map[string]int{}
+	)
+	notifier := notifierFunc(func(ctx context.Context, msg notifications.Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		delivered[msg.To]++
+		assert.Equal(t, "<p>body</p>", msg.HTML)
+		return nil
+	})
+
+	for i := 0; i < 10; i++ {
+		enqueue(t, s, fmt.Sprintf("user%d@example.com", i))
+	}
+
+	pool := New(discard, s, notifier, fastOptions())
+	pool.Start()
+
+	assert.Eventually(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		return len(delivered) == 10
+	}, 5*time.Second, 5*time.Millisecond)
+
+	require.NoError(t, pool.Shutdown(context.Background()))
+
+	for to, count := range delivered {
+		assert.Equal(t, 1, count, to)
+	}
+
+	// Delivered notifications are deleted
+	left, err := s.ClaimNotifications(context.Background(), 100, time.Minute)
+	require.NoError(t, err)
+	assert.Empty(t, left)
+}
+
+func TestPool_Retry(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		attempts int
+	}{
+		{"temporary", errors.New("421 try again later"), 3},
+		{"permanent", fmt.Errorf("%w: 550 no such mailbox", notifications.ErrPermanent), 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := memory.New()
+
+			var (
+				mu    sync.Mutex
+				tries int
+			)
+			notifier := notifierFunc(func(ctx context.Context, msg notifications.Message) error {
+				mu.Lock()
+				defer mu.Unlock()
+
+				tries++
+				return tt.err
+			})
+
+			id := enqueue(t, s, "user@example.com")
+
+			pool := New(discard, s, notifier, fastOptions())
+			pool.Start()
+			defer pool.Shutdown(ctx)
+
+			var dead []models.Notification
+			require.Eventually(t, func() bool {
+				var err error
+				dead, err = s.ListDeadNotifications(ctx, 10)
+				require.NoError(t, err)
+
+				return len(dead) == 1
+			}, 5*time.Second, 5*time.Millisecond)
+
+			assert.Equal(t, id, dead[0].ID)
+			assert.Equal(t, tt.attempts, dead[0].Attempts)
+			assert.Contains(t, dead[0].LastError, tt.err.Error())
+
+			mu.Lock()
+			assert.Equal(t, tt.attempts, tries)
+			mu.Unlock()
+		})
+	}
+}
+
+func TestPool_Shutdown(t *testing.T) {
+	ctx := context.Background()
+	s := memory.New()
+
+	started := make(chan struct{})
+	notifier := notifierFunc(func(ctx context.Context, msg notifications.Message) error {
+		close(started)
+		<-ctx.Done()
+
+		return ctx.Err()
+	})
+
+	id := enqueue(t, s, "user@example.com")
+
+	pool := New(discard, s, notifier, fastOptions())
+	pool.Start()
+	<-started
+
+	shutdownCtx, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+
+	assert.ErrorIs(t, pool.Shutdown(shutdownCtx), context.DeadlineExceeded)
+
+	// The interrupted delivery is neither lost nor dead-lettered
+	dead, err := s.ListDeadNotifications(ctx, 10)
+	require.NoError(t, err)
+	assert.Empty(t, dead)
+
+	pending, err := s.ClaimNotifications(ctx, 10, time.Minute)
+	require.NoError(t, err)
+	require.Len(t, pending, 1)
+	assert.Equal(t, id, pending[0].ID)
+}
+
+func TestPool_Backoff(t *testing.T) {
+	pool := New(discard, memory.New(), nil, Options{MinBackoff: time.Second, MaxBackoff: 10 * time.Second})
+
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{50, 10 * time.Second},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 20; i++ {
+			got := pool.backoff(tt.attempt)
+			assert.GreaterOrEqual(t, got, tt.delay/2, "attempt %d", tt.attempt)
+			assert.LessOrEqual(t, got, tt.delay, "attempt %d", tt.attempt)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// File appends messages to the file as JSON lines instead of delivering them, e.g. for local development
+type File struct {
+	mu   sync.Mutex
+	path string
+}
+
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+type record struct {
+	Time time.Time `json:"time"`
+	notifications.Message
+}
+
+func (f *File) Notify(ctx context.Context, msg notifications.Message) error {
+	const op = "lib.notifications.sink.File.Notify"
+
+	if err := msg.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	line, err := json.Marshal(record{Time: time.Now().UTC(), Message: msg})
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if _, err = file.Write(append(line, '\n')); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = file.Close(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+// Log writes messages to the log instead of delivering them. Bodies may carry secrets,
+// so use it only for local development
+type Log struct {
+	log *slog.Logger
+}
+
+func NewLog(log *slog.Logger) *Log {
+	return &Log{log: log.With(slog.String("component", "notifications/log"))}
+}
+
+func (l *Log) Notify(ctx context.Context, msg notifications.Message) error {
+	const op = "lib.notifications.sink.Log.Notify"
+
+	if err := msg.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	l.log.InfoContext(ctx, "notification",
+		slog.String("to", msg.To),
+		slog.String("subject", msg.Subject),
+		slog.String("body", msg.Body),
+	)
+
+	return nil
+}
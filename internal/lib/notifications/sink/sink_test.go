@@ -0,0 +1,65 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFile_Notify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "notifications.jsonl")
+	file := NewFile(path)
+
+	first := notifications.Message{To: "a@example.com", Subject: "first", Body: "line 1\nline 2"}
+	second := notifications.Message{To: "b@example.com", Subject: "second", Body: "body"}
+
+	require.NoError(t, file.Notify(context.Background(), first))
+	require.NoError(t, file.Notify(context.Background(), second))
+
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	var got []notifications.Message
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec record
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		assert.False(t, rec.Time.IsZero())
+		got = append(got, rec.Message)
+	}
+	require.NoError(t, scanner.Err())
+
+	assert.Equal(t, []notifications.Message{first, second}, got)
+}
+
+func TestFile_Errors(t *testing.T) {
+	file := NewFile(filepath.Join(t.TempDir(), "missing", "notifications.jsonl"))
+
+	err := file.Notify(context.Background(), notifications.Message{To: "a@example.com"})
+	assert.Error(t, err)
+
+	err = file.Notify(context.Background(), notifications.Message{})
+	assert.ErrorIs(t, err, notifications.ErrInvalidMessage)
+}
+
+func TestLog_Notify(t *testing.T) {
+	var buf bytes.Buffer
+	log := NewLog(slog.New(slog.NewJSONHandler(&buf, nil)))
+
+	require.NoError(t, log.Notify(context.Background(), notifications.Message{To: "a@example.com", Subject: "subject", Body: "body"}))
+
+	var entry map[string]any
+	require.NoError(t, json.Unmarshal(buf.Bytes(), &entry))
+	assert.Equal(t, "a@example.com", entry["to"])
+	assert.Equal(t, "subject", entry["subject"])
+	assert.Equal(t, "body", entry["body"])
+}
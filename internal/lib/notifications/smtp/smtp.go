@@ -0,0 +1,279 @@
+package smtp
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	netsmtp "net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+// TLS modes of the connection to the server
+const (
+	TLSStartTLS = "starttls" // plain connection upgraded by STARTTLS, usually port 587
+	TLSImplicit = "implicit" // TLS from the start, usually port 465
+	TLSNone     = "none"     // no encryption, only for local relays and tests
+)
+
+const (
+	DefaultTimeout = 10 * time.Second
+)
+
+var (
+	ErrInvalidOptions = errors.New("invalid smtp options")
+	ErrNoStartTLS     = errors.New("server does not support STARTTLS")
+)
+
+type Options struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	// From is the sender address, e.g. "Auth <no-reply@example.com>"
+	From string
+	TLS  string
+	// Timeout limits the whole delivery of a message
+	Timeout time.Duration
+	// TLSConfig replaces the default one, e.g. to trust a private CA
+	TLSConfig *tls.Config
+}
+
+// Notifier sends messages as plain text emails, a connection is opened for every message
+type Notifier struct {
+	opts Options
+	from string
+}
+
+func New(opts Options) (*Notifier, error) {
+	const op = "lib.notifications.smtp.New"
+
+	if opts.Host == "" || opts.Port == 0 {
+		return nil, fmt.Errorf("%s: %w: host and port are required", op, ErrInvalidOptions)
+	}
+
+	from, err := parseAddress(opts.From)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: from: %w", op, ErrInvalidOptions, err)
+	}
+
+	switch opts.TLS {
+	case TLSStartTLS, TLSImplicit, TLSNone:
+	case "":
+		opts.TLS = TLSStartTLS
+	default:
+		return nil, fmt.Errorf("%s: %w: unknown tls mode %q", op, ErrInvalidOptions, opts.TLS)
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	return &Notifier{opts: opts, from: from}, nil
+}
+
+// Notify delivers the message, errors of the server are returned as is
+func (n *Notifier) Notify(ctx context.Context, msg notifications.Message) error {
+	const op = "lib.notifications.smtp.Notify"
+
+	if err := msg.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	to, err := parseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("%s: %w: %w", op, notifications.ErrInvalidMessage, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.opts.Timeout)
+	defer cancel()
+
+	conn, err := n.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	// net/smtp knows nothing about contexts, so the deadline is put on the connection
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	stop := context.AfterFunc(ctx, func() { _ = conn.Close() })
+	defer stop()
+
+	if err = n.send(conn, to, msg); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("%s: %w", op, ctx.Err())
+		}
+
+		// 5xx replies are final, 4xx ones are temporary
+		var reply *textproto.Error
+		if errors.As(err, &reply) && reply.Code >= 500 {
+			return fmt.Errorf("%s: %w: %w", op, notifications.ErrPermanent, err)
+		}
+
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	return nil
+}
+
+func (n *Notifier) dial(ctx context.Context) (net.Conn, error) {
+	addr := net.JoinHostPort(n.opts.Host, strconv.Itoa(n.opts.Port))
+
+	if n.opts.TLS == TLSImplicit {
+		dialer := &tls.Dialer{Config: n.tlsConfig()}
+		return dialer.DialContext(ctx, "tcp", addr)
+	}
+
+	var dialer net.Dialer
+	return dialer.DialContext(ctx, "tcp", addr)
+}
+
+func (n *Notifier) send(conn net.Conn, to string, msg notifications.Message) error {
+	client, err := netsmtp.NewClient(conn, n.opts.Host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if n.opts.TLS == TLSStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			return ErrNoStartTLS
+		}
+		if err = client.StartTLS(n.tlsConfig()); err != nil {
+			return err
+		}
+	}
+
+	if n.opts.Username != "" {
+		if err = client.Auth(netsmtp.PlainAuth("", n.opts.Username, n.opts.Password, n.opts.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err = client.Mail(n.from); err != nil {
+		return err
+	}
+	if err = client.Rcpt(to); err != nil {
+		return err
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	data, err := n.message(msg)
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.Write(data); err != nil {
+		return err
+	}
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+func (n *Notifier) tlsConfig() *tls.Config {
+	if n.opts.TLSConfig != nil {
+		config := n.opts.TLSConfig.Clone()
+		if config.ServerName == "" {
+			config.ServerName = n.opts.Host
+		}
+
+		return config
+	}
+
+	return &tls.Config{ServerName: n.opts.Host, MinVersion: tls.VersionTLS12}
+}
+
+// message builds the email, parts are quoted-printable so any text and line length is fine.
+// A message with HTML is multipart/alternative, the plain text goes first as the fallback
+func (n *Notifier) message(msg notifications.Message) ([]byte, error) {
+	var buf bytes.Buffer
+
+	fmt.Fprintf(&buf, "From: %s\r\n", n.opts.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+
+	if msg.HTML == "" {
+		buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+		buf.WriteString("Content-Transfer-Encoding: quoted-printable\r\n")
+		buf.WriteString("\r\n")
+
+		if err := writeQuotedPrintable(&buf, msg.Body); err != nil {
+			return nil, err
+		}
+
+		return buf.Bytes(), nil
+	}
+
+	w := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "Content-Type: multipart/alternative; boundary=%q\r\n", w.Boundary())
+	buf.WriteString("\r\n")
+
+	parts := []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", msg.Body},
+		{"text/html; charset=utf-8", msg.HTML},
+	}
+
+	for _, part := range parts {
+		pw, err := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err = writeQuotedPrintable(pw, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+func writeQuotedPrintable(w io.Writer, content string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := qp.Write([]byte(content)); err != nil {
+		return err
+	}
+
+	return qp.Close()
+}
+
+// parseAddress returns the bare address for the SMTP envelope
+func parseAddress(address string) (string, error) {
+	parsed, err := mail.ParseAddress(address)
+	if err != nil {
+		return "", err
+	}
+
+	return parsed.Address, nil
+}
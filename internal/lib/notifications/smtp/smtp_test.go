@@ -0,0 +1,155 @@
+package smtp
+
+import (
+	"context"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications/smtp/smtptest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/textproto"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newNotifier(t *testing.T, server *smtptest.Server, opts Options) *Notifier {
+	t.Helper()
+
+	opts.Host = server.Host()
+	opts.Port = server.Port()
+	opts.From = "Auth <no-reply@example.com>"
+	opts.TLSConfig = server.ClientTLSConfig()
+
+	notifier, err := New(opts)
+	require.NoError(t, err)
+
+	return notifier
+}
+
+func TestNotifier_Notify(t *testing.T) {
+	tests := []struct {
+		name string
+		tls  string
+	}{
+		{"plain", TLSNone},
+		{"starttls", TLSStartTLS},
+		{"implicit tls", TLSImplicit},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := smtptest.NewServer(t, smtptest.Options{TLS: tt.tls, Username: "user", Password: "secret"})
+			notifier := newNotifier(t, server, Options{TLS: tt.tls, Username: "user", Password: "secret"})
+
+			body := "Hello,\n.\nthe line above is a lone dot, and this one is long: " + strings.Repeat("x", 100) + "\n"
+
+			err := notifier.Notify(context.Background(), notifications.Message{
+				To:      "User <user@example.com>",
+				Subject: "Привет, new sign-in",
+				Body:    body,
+			})
+			require.NoError(t, err)
+
+			messages := server.Messages()
+			require.Len(t, messages, 1)
+
+			msg := messages[0]
+			assert.Equal(t, "no-reply@example.com", msg.From)
+			assert.Equal(t, []string{"user@example.com"}, msg.To)
+			assert.Equal(t, "Привет, new sign-in", msg.Subject)
+			assert.Equal(t, body, msg.Body)
+			assert.Equal(t, tt.tls != TLSNone, msg.TLS)
+		})
+	}
+}
+
+func TestNotifier_NotifyHTML(t *testing.T) {
+	server := smtptest.NewServer(t, smtptest.Options{})
+	notifier := newNotifier(t, server, Options{TLS: TLSNone})
+
+	err := notifier.Notify(context.Background(), notifications.Message{
+		To:      "user@example.com",
+		Subject: "New sign-in",
+		Body:    "Sign-in from 192.0.2.1\n",
+		HTML:    "<p style=\"margin: 0\">Sign-in from <b>192.0.2.1</b></p>\n",
+	})
+	require.NoError(t, err)
+
+	messages := server.Messages()
+	require.Len(t, messages, 1)
+
+	assert.Contains(t, messages[0].Header.Get("Content-Type"), "multipart/alternative")
+	assert.Equal(t, "Sign-in from 192.0.2.1\n", messages[0].Body)
+	assert.Equal(t, "<p style=\"margin: 0\">Sign-in from <b>192.0.2.1</b></p>\n", messages[0].HTML)
+}
+
+func TestNotifier_Errors(t *testing.T) {
+	ctx := context.Background()
+	msg := notifications.Message{To: "user@example.com", Subject: "subject", Body: "body"}
+
+	t.Run("rejected recipient", func(t *testing.T) {
+		server := smtptest.NewServer(t, smtptest.Options{RejectRecipients: true})
+		notifier := newNotifier(t, server, Options{TLS: TLSNone})
+
+		err := notifier.Notify(ctx, msg)
+
+		var smtpErr *textproto.Error
+		require.ErrorAs(t, err, &smtpErr)
+		assert.Equal(t, 550, smtpErr.Code)
+		assert.ErrorIs(t, err, notifications.ErrPermanent)
+		assert.Empty(t, server.Messages())
+	})
+
+	t.Run("wrong password", func(t *testing.T) {
+		server := smtptest.NewServer(t, smtptest.Options{Username: "user", Password: "secret"})
+		notifier := newNotifier(t, server, Options{TLS: TLSNone, Username: "user", Password: "wrong"})
+
+		assert.Error(t, notifier.Notify(ctx, msg))
+		assert.Empty(t, server.Messages())
+	})
+
+	t.Run("no starttls", func(t *testing.T) {
+		server := smtptest.NewServer(t, smtptest.Options{TLS: smtptest.TLSNone})
+		notifier := newNotifier(t, server, Options{TLS: TLSStartTLS})
+
+		assert.ErrorIs(t, notifier.Notify(ctx, msg), ErrNoStartTLS)
+		assert.Empty(t, server.Messages())
+	})
+
+	t.Run("header injection", func(t *testing.T) {
+		server := smtptest.NewServer(t, smtptest.Options{})
+		notifier := newNotifier(t, server, Options{TLS: TLSNone})
+
+		err := notifier.Notify(ctx, notifications.Message{To: "user@example.com", Subject: "hi\r\nBcc: victim@example.com", Body: "body"})
+
+		assert.ErrorIs(t, err, notifications.ErrInvalidMessage)
+		assert.Empty(t, server.Messages())
+	})
+
+	t.Run("server is down", func(t *testing.T) {
+		server := smtptest.NewServer(t, smtptest.Options{})
+		notifier := newNotifier(t, server, Options{TLS: TLSNone, Timeout: time.Second})
+		server.Close()
+
+		assert.Error(t, notifier.Notify(ctx, msg))
+	})
+}
+
+func TestNew_InvalidOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"no host", Options{Port: 25, From: "a@example.com"}},
+		{"no port", Options{Host: "localhost", From: "a@example.com"}},
+		{"bad from", Options{Host: "localhost", Port: 25, From: "not an address"}},
+		{"unknown tls", Options{Host: "localhost", Port: 25, From: "a@example.com", TLS: "ssl"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New(tt.opts)
+			assert.ErrorIs(t, err, ErrInvalidOptions)
+		})
+	}
+}
@@ -0,0 +1,419 @@
+// Package smtptest provides a fake SMTP server which records delivered messages
+package smtptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TLS modes of the server, the same as of the smtp notifier
+const (
+	TLSNone     = "none"
+	TLSStartTLS = "starttls"
+	TLSImplicit = "implicit"
+)
+
+type Options struct {
+	TLS string
+	// Username and Password are required to send mail when set
+	Username string
+	Password string
+	// RejectRecipients makes the server refuse every recipient
+	RejectRecipients bool
+}
+
+// Message is a message received by the server
+type Message struct {
+	From    string
+	To      []string
+	Subject string
+	// Body is the plain text, of multipart/alternative messages too
+	Body   string
+	HTML   string
+	Header mail.Header
+	// TLS reports if the message was sent over an encrypted connection
+	TLS bool
+}
+
+type Server struct {
+	opts      Options
+	listener  net.Listener
+	tlsConfig *tls.Config
+	roots     *x509.CertPool
+
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewServer starts the server on a random local port, it is stopped when the test ends
+func NewServer(t testing.TB, opts Options) *Server {
+	t.Helper()
+
+	if opts.TLS == "" {
+		opts.TLS = TLSNone
+	}
+
+	tlsConfig, roots, err := selfSigned()
+	if err != nil {
+		t.Fatalf("smtptest: generate certificate: %v", err)
+	}
+
+	var listener net.Listener
+	if opts.TLS == TLSImplicit {
+		listener, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		listener, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatalf("smtptest: listen: %v", err)
+	}
+
+	s := &Server{
+		opts:      opts,
+		listener:  listener,
+		tlsConfig: tlsConfig,
+		roots:     roots,
+	}
+
+	s.wg.Add(1)
+	go s.serve()
+
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+// Host and Port to connect the notifier to
+func (s *Server) Host() string {
+	return "127.0.0.1"
+}
+
+func (s *Server) Port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+// ClientTLSConfig trusts the server's self-signed certificate
+func (s *Server) ClientTLSConfig() *tls.Config {
+	return &tls.Config{RootCAs: s.roots, ServerName: s.Host()}
+}
+
+// Messages returns the messages received so far
+func (s *Server) Messages() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]Message{}, s.messages...)
+}
+
+func (s *Server) Close() {
+	_ = s.listener.Close()
+	s.wg.Wait()
+}
+
+func (s *Server) serve() {
+	defer s.wg.Done()
+
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+
+			_ = conn.SetDeadline(time.Now().Add(10 * time.Second))
+			s.handle(conn)
+		}()
+	}
+}
+
+// session is the state of one client connection
+type session struct {
+	conn   net.Conn
+	text   *textproto.Conn
+	tls    bool
+	authed bool
+	from   string
+	to     []string
+}
+
+func (s *Server) handle(conn net.Conn) {
+	sess := &session{
+		conn: conn,
+		text: textproto.NewConn(conn),
+		tls:  s.opts.TLS == TLSImplicit,
+	}
+
+	reply(sess, 220, "smtptest ESMTP ready")
+
+	for {
+		line, err := sess.text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		verb, arg, _ := strings.Cut(line, " ")
+
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			var ext []string
+			if s.opts.TLS == TLSStartTLS && !sess.tls {
+				ext = append(ext, "STARTTLS")
+			}
+			if s.opts.Username != "" {
+				ext = append(ext, "AUTH PLAIN")
+			}
+			ext = append(ext, "8BITMIME")
+
+			_ = sess.text.PrintfLine("250-smtptest")
+			for i, e := range ext {
+				sep := "-"
+				if i == len(ext)-1 {
+					sep = " "
+				}
+				_ = sess.text.PrintfLine("250%s%s", sep, e)
+			}
+		case "HELO":
+			reply(sess, 250, "smtptest")
+		case "STARTTLS":
+			if s.opts.TLS != TLSStartTLS || sess.tls {
+				reply(sess, 502, "not supported")
+				continue
+			}
+			reply(sess, 220, "ready to start TLS")
+
+			tlsConn := tls.Server(sess.conn, s.tlsConfig)
+			if err = tlsConn.Handshake(); err != nil {
+				return
+			}
+			sess.conn = tlsConn
+			sess.text = textproto.NewConn(tlsConn)
+			sess.tls = true
+			sess.from, sess.to = "", nil
+		case "AUTH":
+			sess.authed = s.auth(arg)
+			if sess.authed {
+				reply(sess, 235, "authenticated")
+			} else {
+				reply(sess, 535, "authentication failed")
+			}
+		case "MAIL":
+			if s.opts.TLS == TLSStartTLS && !sess.tls {
+				reply(sess, 530, "must issue STARTTLS first")
+				continue
+			}
+			if s.opts.Username != "" && !sess.authed {
+				reply(sess, 530, "authentication required")
+				continue
+			}
+			sess.from = path(arg)
+			sess.to = nil
+			reply(sess, 250, "ok")
+		case "RCPT":
+			if s.opts.RejectRecipients {
+				reply(sess, 550, "mailbox unavailable")
+				continue
+			}
+			sess.to = append(sess.to, path(arg))
+			reply(sess, 250, "ok")
+		case "DATA":
+			if sess.from == "" || len(sess.to) == 0 {
+				reply(sess, 503, "bad sequence of commands")
+				continue
+			}
+			reply(sess, 354, "end data with <CR><LF>.<CR><LF>")
+
+			data, err := sess.text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+
+			msg, err := parse(data)
+			if err != nil {
+				reply(sess, 554, "malformed message")
+				continue
+			}
+			msg.From, msg.To, msg.TLS = sess.from, sess.to, sess.tls
+
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+
+			reply(sess, 250, "queued")
+		case "RSET":
+			sess.from, sess.to = "", nil
+			reply(sess, 250, "ok")
+		case "NOOP":
+			reply(sess, 250, "ok")
+		case "QUIT":
+			reply(sess, 221, "bye")
+			return
+		default:
+			reply(sess, 502, "command not implemented")
+		}
+	}
+}
+
+// auth checks "PLAIN <base64 of \x00user\x00password>"
+func (s *Server) auth(arg string) bool {
+	mechanism, initial, _ := strings.Cut(arg, " ")
+	if !strings.EqualFold(mechanism, "PLAIN") {
+		return false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(initial)
+	if err != nil {
+		return false
+	}
+
+	parts := strings.Split(string(decoded), "\x00")
+
+	return len(parts) == 3 && parts[1] == s.opts.Username && parts[2] == s.opts.Password
+}
+
+func reply(sess *session, code int, text string) {
+	_ = sess.text.PrintfLine("%d %s", code, text)
+}
+
+// path returns the address of "FROM:<address>" and "TO:<address>"
+func path(arg string) string {
+	_, address, _ := strings.Cut(arg, ":")
+	address, _, _ = strings.Cut(strings.TrimSpace(address), " ")
+
+	return strings.Trim(address, "<>")
+}
+
+func parse(data []byte) (Message, error) {
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		return Message{}, err
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		return Message{}, err
+	}
+
+	msg := Message{Subject: subject, Header: parsed.Header}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		return Message{}, err
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		msg.Body, err = decodePart(parsed.Body, parsed.Header.Get("Content-Transfer-Encoding"))
+		return msg, err
+	}
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		part, err := reader.NextRawPart()
+		if errors.Is(err, io.EOF) {
+			return msg, nil
+		}
+		if err != nil {
+			return Message{}, err
+		}
+
+		content, err := decodePart(part, part.Header.Get("Content-Transfer-Encoding"))
+		if err != nil {
+			return Message{}, err
+		}
+
+		switch partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type")); partType {
+		case "text/plain":
+			msg.Body = content
+		case "text/html":
+			msg.HTML = content
+		}
+	}
+}
+
+func decodePart(r io.Reader, encoding string) (string, error) {
+	if strings.EqualFold(encoding, "quoted-printable") {
+		r = quotedprintable.NewReader(r)
+	}
+
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.ReplaceAll(string(decoded), "\r\n", "\n"), nil
+}
+
+// selfSigned returns the server config with a new certificate for 127.0.0.1 and the pool trusting it
+func selfSigned() (*tls.Config, *x509.CertPool, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "smtptest"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		DNSNames:              []string{"localhost"},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+
+	config := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key, Leaf: cert}},
+		MinVersion:   tls.VersionTLS12,
+	}
+
+	return config, roots, nil
+}
+
+// String describes the message in test failures
+func (m Message) String() string {
+	return fmt.Sprintf("from %s to %s: %s", m.From, strings.Join(m.To, ", "), strconv.Quote(m.Subject))
+}
@@ -0,0 +1,224 @@
+// Package templates renders the emails of account events in the language of the user.
+// Every event has a plain text and an HTML template per locale, embedded into the binary
+package templates
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications"
+	"golang.org/x/text/language"
+	"io/fs"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Event is the kind of email
+type Event string
+
+const (
+	NewSignIn         Event = "new_sign_in"
+	SessionRevoked    Event = "session_revoked"
+	PasswordChanged   Event = "password_changed"
+	EmailVerification Event = "email_verification"
+	PasswordReset     Event = "password_reset"
+)
+
+var events = []Event{NewSignIn, SessionRevoked, PasswordChanged, EmailVerification, PasswordReset}
+
+// DefaultLocale is used when the user has no locale or it is not supported
+const DefaultLocale = "en"
+
+var ErrUnknownEvent = errors.New("unknown event")
+
+//go:embed files
+var files embed.FS
+
+// Data of templates, events use the fields they need
+type Data struct {
+	Email string
+	IP    string
+	// Device is the approximate description of the user agent
+	Device string
+	Time   time.Time
+	// Link is the action of the email, e.g. the email verification link, valid for ValidFor
+	Link     string
+	ValidFor time.Duration
+	// RevokeLink is the "this wasn't me" link, empty if links are not configured
+	RevokeLink string
+}
+
+// Links are URL patterns of links in emails, "{token}" is replaced by the token.
+// Empty patterns leave the links out
+type Links struct {
+	RevokeSession string
+	VerifyEmail   string
+	ResetPassword string
+}
+
+// Set holds the templates of every event in every supported locale
+type Set struct {
+	links     Links
+	locales   []string
+	matcher   language.Matcher
+	templates map[string]map[Event]*notifications.Template
+}
+
+// New parses the embedded templates, every locale must have every event
+func New(links Links) (*Set, error) {
+	const op = "lib.notifications.templates.New"
+
+	layout, err := fs.ReadFile(files, "files/layout.html")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	dirs, err := fs.ReadDir(files, "files")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	s := &Set{
+		links:     links,
+		locales:   []string{DefaultLocale},
+		templates: make(map[string]map[Event]*notifications.Template),
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() {
+			continue
+		}
+
+		locale := dir.Name()
+		if _, err = language.Parse(locale); err != nil {
+			return nil, fmt.Errorf("%s: locale %q: %w", op, locale, err)
+		}
+		if locale != DefaultLocale {
+			s.locales = append(s.locales, locale)
+		}
+
+		s.templates[locale], err = parseLocale(locale, string(layout))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+	}
+
+	if s.templates[DefaultLocale] == nil {
+		return nil, fmt.Errorf("%s: no templates of the default locale %q", op, DefaultLocale)
+	}
+
+	tags := make([]language.Tag, 0, len(s.locales))
+	for _, locale := range s.locales {
+		tags = append(tags, language.Make(locale))
+	}
+	s.matcher = language.NewMatcher(tags)
+
+	return s, nil
+}
+
+// parseLocale parses templates of every event of the locale. The text file is the subject line
+// ("Subject: ..."), an empty line and the body, the HTML one defines "content" of the layout
+func parseLocale(locale string, layout string) (map[Event]*notifications.Template, error) {
+	dir := path.Join("files", locale)
+
+	footer, err := fs.ReadFile(files, path.Join(dir, "footer.html"))
+	if err != nil {
+		return nil, err
+	}
+
+	templates := make(map[Event]*notifications.Template, len(events))
+	for _, event := range events {
+		name := locale + "." + string(event)
+
+		text, err := fs.ReadFile(files, path.Join(dir, string(event)+".txt"))
+		if err != nil {
+			return nil, err
+		}
+		html, err := fs.ReadFile(files, path.Join(dir, string(event)+".html"))
+		if err != nil {
+			return nil, err
+		}
+
+		header, body, ok := strings.Cut(string(text), "\n\n")
+		subject, found := strings.CutPrefix(header, "Subject: ")
+		if !ok || !found {
+			return nil, fmt.Errorf("template %s: text must start with the subject line and an empty line", name)
+		}
+
+		templates[event], err = notifications.NewHTMLTemplate(name, subject, body, layout+strings.TrimSpace(string(footer))+strings.TrimSpace(string(html)))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return templates, nil
+}
+
+// Locales returns the supported locales, the default one first
+func (s *Set) Locales() []string {
+	return append([]string{}, s.locales...)
+}
+
+// MatchLocale returns the supported locale closest to the preferences, which are
+// an Accept-Language header or a single locale such as "ru-RU"
+func (s *Set) MatchLocale(preferences string) string {
+	tags, _, err := language.ParseAcceptLanguage(preferences)
+	if err != nil || len(tags) == 0 {
+		return DefaultLocale
+	}
+
+	_, i, confidence := s.matcher.Match(tags...)
+	if confidence == language.No {
+		return DefaultLocale
+	}
+
+	return s.locales[i]
+}
+
+// Render returns the email about the event in the locale, unknown locales fall back to the default one
+func (s *Set) Render(event Event, locale string, to string, data Data) (notifications.Message, error) {
+	const op = "lib.notifications.templates.Render"
+
+	templates, ok := s.templates[locale]
+	if !ok {
+		templates = s.templates[s.MatchLocale(locale)]
+	}
+
+	tmpl, ok := templates[event]
+	if !ok {
+		return notifications.Message{}, fmt.Errorf("%s: %w: %s", op, ErrUnknownEvent, event)
+	}
+
+	data.Time = data.Time.UTC()
+
+	msg, err := tmpl.Render(to, data)
+	if err != nil {
+		return notifications.Message{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return msg, nil
+}
+
+// RevokeSessionLink returns the "this wasn't me" link with the token, empty if it is not configured
+func (s *Set) RevokeSessionLink(token string) string {
+	return link(s.links.RevokeSession, token)
+}
+
+// VerifyEmailLink returns the email verification link with the token, empty if it is not configured
+func (s *Set) VerifyEmailLink(token string) string {
+	return link(s.links.VerifyEmail, token)
+}
+
+// ResetPasswordLink returns the password reset link with the token, empty if it is not configured
+func (s *Set) ResetPasswordLink(token string) string {
+	return link(s.links.ResetPassword, token)
+}
+
+func link(pattern string, token string) string {
+	if pattern == "" {
+		return ""
+	}
+
+	return strings.ReplaceAll(pattern, "{token}", url.QueryEscape(token))
+}
@@ -0,0 +1,129 @@
+package templates
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSet(t *testing.T, links Links) *Set {
+	t.Helper()
+
+	s, err := New(links)
+	require.NoError(t, err)
+
+	return s
+}
+
+func TestSet_RenderEveryEvent(t *testing.T) {
+	s := newSet(t, Links{RevokeSession: "https://example.com/revoke?token={token}"})
+
+	data := Data{
+		Email:      "user@example.com",
+		IP:         "192.0.2.1",
+		Device:     "Firefox on Linux",
+		Time:       time.Date(2024, 3, 5, 14, 30, 0, 0, time.UTC),
+		Link:       "https://example.com/action",
+		ValidFor:   24 * time.Hour,
+		RevokeLink: "https://example.com/revoke",
+	}
+
+	for _, locale := range s.Locales() {
+		for _, event := range events {
+			msg, err := s.Render(event, locale, "user@example.com", data)
+			require.NoError(t, err, "%s %s", locale, event)
+
+			assert.NotEmpty(t, msg.Subject, "%s %s", locale, event)
+			assert.NotContains(t, msg.Subject, "\n")
+			assert.NotEmpty(t, msg.Body)
+			assert.True(t, strings.HasPrefix(msg.HTML, "<!DOCTYPE html>"), "%s %s: HTML is put in the layout", locale, event)
+			assert.NotContains(t, msg.Body+msg.HTML, "<no value>")
+		}
+	}
+}
+
+func TestSet_RenderNewSignIn(t *testing.T) {
+	s := newSet(t, Links{})
+
+	data := Data{
+		IP:     "192.0.2.1",
+		Device: "Chrome on Windows",
+		Time:   time.Date(2024, 3, 5, 17, 30, 0, 0, time.FixedZone("MSK", 3*60*60)),
+	}
+
+	msg, err := s.Render(NewSignIn, "en", "user@example.com", data)
+	require.NoError(t, err)
+
+	assert.Equal(t, "New sign-in to your account", msg.Subject)
+	assert.Contains(t, msg.Body, "IP address: 192.0.2.1")
+	assert.Contains(t, msg.Body, "Device: Chrome on Windows")
+	assert.Contains(t, msg.Body, "Time: 5 Mar 2024 14:30 UTC")
+	assert.NotContains(t, msg.Body, "This wasn't me", "no revoke link without the pattern")
+
+	data.RevokeLink = s.RevokeSessionLink("a+b/c")
+	assert.Equal(t, "", data.RevokeLink)
+
+	s = newSet(t, Links{RevokeSession: "https://example.com/revoke?token={token}"})
+	data.RevokeLink = s.RevokeSessionLink("a+b/c")
+	assert.Equal(t, "https://example.com/revoke?token=a%2Bb%2Fc", data.RevokeLink)
+
+	msg, err = s.Render(NewSignIn, "ru", "user@example.com", data)
+	require.NoError(t, err)
+
+	assert.Equal(t, "Новый вход в ваш аккаунт", msg.Subject)
+	assert.Contains(t, msg.Body, "Время: 05.03.2024 14:30 UTC")
+	assert.Contains(t, msg.Body, data.RevokeLink)
+	assert.Contains(t, msg.HTML, `href="https://example.com/revoke?token=a%2Bb%2Fc"`)
+}
+
+func TestSet_RenderEscapesHTML(t *testing.T) {
+	s := newSet(t, Links{})
+
+	msg, err := s.Render(SessionRevoked, "en", "user@example.com", Data{IP: "192.0.2.1", Device: "<script>alert(1)</script>"})
+	require.NoError(t, err)
+
+	assert.NotContains(t, msg.HTML, "<script>")
+	assert.Contains(t, msg.HTML, "&lt;script&gt;")
+}
+
+func TestSet_RenderFallback(t *testing.T) {
+	s := newSet(t, Links{})
+
+	for _, locale := range []string{"", "de", "ru-RU", "garbage;;"} {
+		msg, err := s.Render(SessionRevoked, locale, "user@example.com", Data{})
+		require.NoError(t, err, locale)
+
+		want := "Your session was revoked"
+		if locale == "ru-RU" {
+			want = "Ваш сеанс завершён"
+		}
+		assert.Equal(t, want, msg.Subject, locale)
+	}
+
+	_, err := s.Render("unknown", "en", "user@example.com", Data{})
+	assert.ErrorIs(t, err, ErrUnknownEvent)
+}
+
+func TestSet_MatchLocale(t *testing.T) {
+	s := newSet(t, Links{})
+
+	tests := []struct {
+		preferences string
+		want        string
+	}{
+		{"", DefaultLocale},
+		{"ru", "ru"},
+		{"ru-RU,ru;q=0.9,en-US;q=0.8", "ru"},
+		{"en-GB", "en"},
+		{"de-DE,ru;q=0.5", "ru"},
+		{"de-DE", DefaultLocale},
+		{"*", DefaultLocale},
+		{"not a locale", DefaultLocale},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, s.MatchLocale(tt.preferences), tt.preferences)
+	}
+}
@@ -0,0 +1,122 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const (
+	DefaultTimeout = 5 * time.Second
+
+	// SignatureHeader carries "sha256=<hex HMAC-SHA256 of timestamp.body>", so receivers can
+	// check the request came from this service and is not replayed later
+	SignatureHeader = "X-Signature"
+	TimestampHeader = "X-Timestamp"
+)
+
+var (
+	ErrInvalidOptions   = errors.New("invalid webhook options")
+	ErrUnexpectedStatus = errors.New("unexpected webhook response status")
+)
+
+type Options struct {
+	URL string
+	// Secret signs requests when set
+	Secret  string
+	Timeout time.Duration
+	// Client replaces http.DefaultClient, e.g. in tests
+	Client *http.Client
+}
+
+// Notifier posts messages as JSON to the URL, any 2xx response means the message is delivered
+type Notifier struct {
+	opts Options
+}
+
+func New(opts Options) (*Notifier, error) {
+	const op = "lib.notifications.webhook.New"
+
+	u, err := url.Parse(opts.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("%s: %w: url must be absolute http(s) url", op, ErrInvalidOptions)
+	}
+
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	return &Notifier{opts: opts}, nil
+}
+
+func (n *Notifier) Notify(ctx context.Context, msg notifications.Message) error {
+	const op = "lib.notifications.webhook.Notify"
+
+	if err := msg.Validate(); err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, n.opts.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.opts.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if n.opts.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(TimestampHeader, timestamp)
+		req.Header.Set(SignatureHeader, "sha256="+Sign(n.opts.Secret, timestamp, body))
+	}
+
+	resp, err := n.opts.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", op, err)
+	}
+	defer resp.Body.Close()
+
+	// Drain the body, so the connection can be reused
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	switch code := resp.StatusCode; {
+	case code >= 200 && code <= 299:
+		return nil
+	case code >= 400 && code <= 499 && code != http.StatusRequestTimeout && code != http.StatusTooManyRequests:
+		// The receiver rejected the message itself, sending it again changes nothing
+		return fmt.Errorf("%s: %w: %w: %d", op, notifications.ErrPermanent, ErrUnexpectedStatus, code)
+	default:
+		return fmt.Errorf("%s: %w: %d", op, ErrUnexpectedStatus, code)
+	}
+
+}
+
+// Sign returns hex HMAC-SHA256 of "timestamp.body", receivers compute it to verify requests
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,98 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/northwindman/testREST-autentification/internal/lib/notifications"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestNotifier_Notify(t *testing.T) {
+	msg := notifications.Message{To: "user@example.com", Subject: "subject", Body: "body"}
+
+	var received notifications.Message
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+
+		timestamp := r.Header.Get(TimestampHeader)
+		assert.Equal(t, "sha256="+Sign("secret", timestamp, body), r.Header.Get(SignatureHeader))
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+
+		require.NoError(t, json.Unmarshal(body, &received))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	notifier, err := New(Options{URL: server.URL, Secret: "secret"})
+	require.NoError(t, err)
+
+	require.NoError(t, notifier.Notify(context.Background(), msg))
+	assert.Equal(t, msg, received)
+}
+
+func TestNotifier_Errors(t *testing.T) {
+	ctx := context.Background()
+	msg := notifications.Message{To: "user@example.com", Subject: "subject", Body: "body"}
+
+	t.Run("error status", func(t *testing.T) {
+		tests := []struct {
+			status    int
+			permanent bool
+		}{
+			{http.StatusBadGateway, false},
+			{http.StatusTooManyRequests, false},
+			{http.StatusBadRequest, true},
+			{http.StatusGone, true},
+		}
+
+		for _, tt := range tests {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+			}))
+
+			notifier, err := New(Options{URL: server.URL})
+			require.NoError(t, err)
+
+			err = notifier.Notify(ctx, msg)
+			assert.ErrorIs(t, err, ErrUnexpectedStatus)
+			assert.Equal(t, tt.permanent, notifications.IsPermanent(err), "status %d", tt.status)
+
+			server.Close()
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		release := make(chan struct{})
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			<-release
+		}))
+		defer server.Close()
+		defer close(release)
+
+		notifier, err := New(Options{URL: server.URL, Timeout: 50 * time.Millisecond})
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, notifier.Notify(ctx, msg), context.DeadlineExceeded)
+	})
+
+	t.Run("invalid message", func(t *testing.T) {
+		notifier, err := New(Options{URL: "http://127.0.0.1:1"})
+		require.NoError(t, err)
+
+		assert.ErrorIs(t, notifier.Notify(ctx, notifications.Message{}), notifications.ErrInvalidMessage)
+	})
+}
+
+func TestNew_InvalidURL(t *testing.T) {
+	for _, u := range []string{"", "example.com/hook", "ftp://example.com/hook", "http://"} {
+		_, err := New(Options{URL: u})
+		assert.ErrorIs(t, err, ErrInvalidOptions, u)
+	}
+}
@@ -0,0 +1,107 @@
+package password
+
+import (
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strconv"
+	"strings"
+)
+
+const argon2idID = "argon2id"
+
+// Defaults of Argon2id follow RFC 9106 second recommended option with less memory
+const (
+	DefaultArgon2idMemory      = 64 * 1024 // KiB
+	DefaultArgon2idIterations  = 3
+	DefaultArgon2idParallelism = 2
+	defaultSaltLength          = 16
+	defaultKeyLength           = 32
+)
+
+// Argon2id is the recommended algorithm, zero parameters are replaced by the defaults
+type Argon2id struct {
+	// Memory is in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+func (a *Argon2id) Hash(password string) (string, error) {
+	const op = "lib.password.Argon2id.Hash"
+
+	memory, iterations, parallelism := a.params()
+
+	salt, err := newSalt(defaultSaltLength)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return argon2idPHC(memory, iterations, parallelism, salt,
+		argon2.IDKey([]byte(password), salt, iterations, memory, parallelism, defaultKeyLength),
+	), nil
+}
+
+func (a *Argon2id) Verify(password string, encoded string) (bool, error) {
+	const op = "lib.password.Argon2id.Verify"
+
+	p, err := parsePHC(encoded, argon2idID)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	memory, err := p.uint("m", 32)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	iterations, err := p.uint("t", 32)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	parallelism, err := p.uint("p", 8)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	hash := argon2.IDKey([]byte(password), p.salt, uint32(iterations), uint32(memory), uint8(parallelism), uint32(len(p.hash)))
+
+	return equal(hash, p.hash), nil
+}
+
+func (a *Argon2id) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$"+argon2idID+"$")
+}
+
+func (a *Argon2id) Current(encoded string) bool {
+	p, err := parsePHC(encoded, argon2idID)
+	if err != nil {
+		return false
+	}
+
+	memory, iterations, parallelism := a.params()
+
+	return p.params["m"] == strconv.FormatUint(uint64(memory), 10) &&
+		p.params["t"] == strconv.FormatUint(uint64(iterations), 10) &&
+		p.params["p"] == strconv.FormatUint(uint64(parallelism), 10) &&
+		strings.Contains(encoded, fmt.Sprintf("$v=%d$", argon2.Version))
+}
+
+func (a *Argon2id) params() (memory uint32, iterations uint32, parallelism uint8) {
+	memory, iterations, parallelism = a.Memory, a.Iterations, a.Parallelism
+	if memory == 0 {
+		memory = DefaultArgon2idMemory
+	}
+	if iterations == 0 {
+		iterations = DefaultArgon2idIterations
+	}
+	if parallelism == 0 {
+		parallelism = DefaultArgon2idParallelism
+	}
+
+	return memory, iterations, parallelism
+}
+
+func argon2idPHC(memory uint32, iterations uint32, parallelism uint8, salt []byte, hash []byte) string {
+	return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idID, argon2.Version, memory, iterations, parallelism, b64.EncodeToString(salt), b64.EncodeToString(hash),
+	)
+}
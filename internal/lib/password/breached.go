@@ -0,0 +1,94 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var ErrInvalidBreachedList = errors.New("invalid breached password list")
+
+const (
+	sha1HexLength = 40
+	// prefixLength is the length of the hash prefix of k-anonymity range queries
+	prefixLength = 5
+)
+
+// BreachedList is a local list of SHA-1 hashes of breached passwords, indexed by hash prefix
+// the same way as k-anonymity range queries (e.g. of Have I Been Pwned) are
+type BreachedList struct {
+	ranges map[string]map[string]struct{}
+}
+
+// LoadBreachedList reads the file with a SHA-1 hash per line, optionally followed by ":count"
+// as in the Pwned Passwords downloads. Empty lines and lines starting with # are skipped
+func LoadBreachedList(path string) (*BreachedList, error) {
+	const op = "lib.password.LoadBreachedList"
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	defer file.Close()
+
+	list := &BreachedList{ranges: make(map[string]map[string]struct{})}
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hash, _, _ := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != sha1HexLength {
+			return nil, fmt.Errorf("%s: %w: line %d", op, ErrInvalidBreachedList, line)
+		}
+		if _, err = hex.DecodeString(hash); err != nil {
+			return nil, fmt.Errorf("%s: %w: line %d", op, ErrInvalidBreachedList, line)
+		}
+
+		list.add(hash)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return list, nil
+}
+
+// Len returns the number of hashes in the list
+func (l *BreachedList) Len() int {
+	n := 0
+	for _, suffixes := range l.ranges {
+		n += len(suffixes)
+	}
+
+	return n
+}
+
+// IsBreached reports if SHA-1 of the password is in the list
+func (l *BreachedList) IsBreached(password string) bool {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	_, ok := l.ranges[hash[:prefixLength]][hash[prefixLength:]]
+
+	return ok
+}
+
+func (l *BreachedList) add(hash string) {
+	prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+
+	suffixes, ok := l.ranges[prefix]
+	if !ok {
+		suffixes = make(map[string]struct{})
+		l.ranges[prefix] = suffixes
+	}
+	suffixes[suffix] = struct{}{}
+}
@@ -0,0 +1,50 @@
+package password
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeList(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o600))
+
+	return path
+}
+
+func TestLoadBreachedList(t *testing.T) {
+	// SHA-1 of "password" and "123456"
+	path := writeList(t, `# top passwords
+5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:9545824
+
+7c4a8d09ca3762af61e59520943dc26494f8941b
+`)
+
+	list, err := LoadBreachedList(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, 2, list.Len())
+	assert.True(t, list.IsBreached("password"))
+	assert.True(t, list.IsBreached("123456"))
+	assert.False(t, list.IsBreached("correct-Horse-battery"))
+}
+
+func TestLoadBreachedList_Invalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{name: "short hash", content: "5BAA61E4C9B93F3F:1\n"},
+		{name: "not hex", content: "ZZAA61E4C9B93F3F0682250B6CF8331B7EE68FD8:1\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadBreachedList(writeList(t, tt.content))
+			assert.ErrorIs(t, err, ErrInvalidBreachedList)
+		})
+	}
+}
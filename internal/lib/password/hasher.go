@@ -0,0 +1,154 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrUnknownHash = errors.New("unknown password hash format")
+	ErrInvalidHash = errors.New("invalid password hash")
+)
+
+// Algorithm hashes passwords with its parameters and verifies hashes it made with any parameters
+type Algorithm interface {
+	// Hash returns the encoded hash of the password with a random salt
+	Hash(password string) (string, error)
+	// Verify checks the password against the encoded hash, the hash must be owned by the algorithm
+	Verify(password string, encoded string) (bool, error)
+	// Owns reports if the encoded hash was made by the algorithm
+	Owns(encoded string) bool
+	// Current reports if the encoded hash was made with the current parameters of the algorithm
+	Current(encoded string) bool
+}
+
+// Hasher hashes new passwords with the primary algorithm and verifies hashes of every supported one,
+// so the algorithm and its parameters can change without resetting passwords
+type Hasher struct {
+	primary    Algorithm
+	algorithms []Algorithm
+}
+
+func NewHasher(primary Algorithm) *Hasher {
+	return &Hasher{
+		primary: primary,
+		// Parameters of verified hashes are encoded in them, so the defaults are fine here
+		algorithms: []Algorithm{primary, &Argon2id{}, &Scrypt{}, &Bcrypt{}},
+	}
+}
+
+// Hash returns the encoded hash of the password made by the primary algorithm
+func (h *Hasher) Hash(password string) (string, error) {
+	return h.primary.Hash(password)
+}
+
+// Verify checks the password against the encoded hash made by any supported algorithm
+func (h *Hasher) Verify(password string, encoded string) (bool, error) {
+	for _, algorithm := range h.algorithms {
+		if algorithm.Owns(encoded) {
+			return algorithm.Verify(password, encoded)
+		}
+	}
+
+	return false, ErrUnknownHash
+}
+
+// NeedsRehash reports if the hash was made by another algorithm or with other parameters
+// than the primary algorithm has now, so the password should be hashed again once it is known
+func (h *Hasher) NeedsRehash(encoded string) bool {
+	return !h.primary.Owns(encoded) || !h.primary.Current(encoded)
+}
+
+// PHC string format: $<id>$<param>=<value>(,<param>=<value>)*$<salt>$<hash>,
+// salt and hash are in base64 without padding
+
+var b64 = base64.RawStdEncoding
+
+type phc struct {
+	id     string
+	params map[string]string
+	salt   []byte
+	hash   []byte
+}
+
+func (p phc) String() string {
+	return fmt.Sprintf("$%s$%s$%s$%s", p.id, p.encodeParams(), b64.EncodeToString(p.salt), b64.EncodeToString(p.hash))
+}
+
+func (p phc) encodeParams() string {
+	// The order of parameters is defined by their algorithms
+	var parts []string
+	for _, key := range paramOrder[p.id] {
+		parts = append(parts, key+"="+p.params[key])
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// paramOrder lists parameters in the order of PHC strings, argon2id writes its own with the version
+var paramOrder = map[string][]string{
+	scryptID: {"ln", "r", "p"},
+}
+
+// parsePHC parses the string with the id, argon2 puts its version as a separate field before parameters
+func parsePHC(encoded string, id string) (phc, error) {
+	fields := strings.Split(encoded, "$")
+	if len(fields) > 0 && fields[0] == "" {
+		fields = fields[1:]
+	}
+
+	if len(fields) == 5 && strings.HasPrefix(fields[1], "v=") {
+		fields = append(fields[:1], fields[2:]...)
+	}
+	if len(fields) != 4 || fields[0] != id {
+		return phc{}, ErrInvalidHash
+	}
+
+	params := make(map[string]string)
+	for _, pair := range strings.Split(fields[1], ",") {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok {
+			return phc{}, ErrInvalidHash
+		}
+		params[key] = value
+	}
+
+	salt, err := b64.DecodeString(fields[2])
+	if err != nil {
+		return phc{}, ErrInvalidHash
+	}
+
+	hash, err := b64.DecodeString(fields[3])
+	if err != nil || len(hash) == 0 {
+		return phc{}, ErrInvalidHash
+	}
+
+	return phc{id: id, params: params, salt: salt, hash: hash}, nil
+}
+
+func (p phc) uint(key string, bits int) (uint64, error) {
+	value, err := strconv.ParseUint(p.params[key], 10, bits)
+	if err != nil {
+		return 0, ErrInvalidHash
+	}
+
+	return value, nil
+}
+
+func newSalt(length uint32) ([]byte, error) {
+	salt := make([]byte, length)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	return salt, nil
+}
+
+func equal(a, b []byte) bool {
+	return subtle.ConstantTimeCompare(a, b) == 1
+}
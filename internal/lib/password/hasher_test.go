@@ -0,0 +1,130 @@
+package password
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/crypto/bcrypt"
+	"regexp"
+	"testing"
+)
+
+// Cheap parameters, so tests don't spend seconds on hashing
+func testAlgorithms() map[string]Algorithm {
+	return map[string]Algorithm{
+		"argon2id": &Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1},
+		"scrypt":   &Scrypt{N: 1 << 10, R: 8, P: 1},
+		"bcrypt":   &Bcrypt{Cost: bcrypt.MinCost},
+	}
+}
+
+func TestAlgorithms_HashVerify(t *testing.T) {
+	formats := map[string]*regexp.Regexp{
+		"argon2id": regexp.MustCompile(`^\$argon2id\$v=19\$m=1024,t=1,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`),
+		"scrypt":   regexp.MustCompile(`^\$scrypt\$ln=10,r=8,p=1\$[A-Za-z0-9+/]{22}\$[A-Za-z0-9+/]{43}$`),
+		"bcrypt":   regexp.MustCompile(`^\$2a\$04\$`),
+	}
+
+	for name, algorithm := range testAlgorithms() {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := algorithm.Hash("correct horse")
+			require.NoError(t, err)
+			assert.Regexp(t, formats[name], encoded)
+
+			assert.True(t, algorithm.Owns(encoded))
+			assert.True(t, algorithm.Current(encoded))
+
+			ok, err := algorithm.Verify("correct horse", encoded)
+			require.NoError(t, err)
+			assert.True(t, ok)
+
+			ok, err = algorithm.Verify("wrong horse", encoded)
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			other, err := algorithm.Hash("correct horse")
+			require.NoError(t, err)
+			assert.NotEqual(t, encoded, other, "salt must be random")
+		})
+	}
+}
+
+func TestHasher_VerifyAnyAlgorithm(t *testing.T) {
+	hasher := NewHasher(&Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1})
+
+	for name, algorithm := range testAlgorithms() {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := algorithm.Hash("correct horse")
+			require.NoError(t, err)
+
+			ok, err := hasher.Verify("correct horse", encoded)
+			require.NoError(t, err)
+			assert.True(t, ok)
+
+			assert.Equal(t, name != "argon2id", hasher.NeedsRehash(encoded))
+		})
+	}
+}
+
+func TestHasher_NeedsRehash(t *testing.T) {
+	old := NewHasher(&Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1})
+
+	encoded, err := old.Hash("correct horse")
+	require.NoError(t, err)
+	assert.False(t, old.NeedsRehash(encoded))
+
+	tests := []struct {
+		name      string
+		algorithm Algorithm
+	}{
+		{"more memory", &Argon2id{Memory: 2048, Iterations: 1, Parallelism: 1}},
+		{"more iterations", &Argon2id{Memory: 1024, Iterations: 2, Parallelism: 1}},
+		{"more parallelism", &Argon2id{Memory: 1024, Iterations: 1, Parallelism: 2}},
+		{"other algorithm", &Scrypt{N: 1 << 10}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hasher := NewHasher(tt.algorithm)
+
+			assert.True(t, hasher.NeedsRehash(encoded))
+
+			// The old hash keeps working until it is replaced
+			ok, err := hasher.Verify("correct horse", encoded)
+			require.NoError(t, err)
+			assert.True(t, ok)
+		})
+	}
+}
+
+func TestHasher_Invalid(t *testing.T) {
+	hasher := NewHasher(&Argon2id{})
+
+	tests := []struct {
+		name    string
+		encoded string
+		err     error
+	}{
+		{"empty", "", ErrUnknownHash},
+		{"plain text", "correct horse", ErrUnknownHash},
+		{"unknown algorithm", "$pbkdf2-sha256$i=1000$c2FsdA$aGFzaA", ErrUnknownHash},
+		{"argon2id without hash", "$argon2id$v=19$m=1024,t=1,p=1$c2FsdHNhbHRzYWx0", ErrInvalidHash},
+		{"argon2id bad parameter", "$argon2id$v=19$m=x,t=1,p=1$c2FsdHNhbHRzYWx0$aGFzaA", ErrInvalidHash},
+		{"scrypt bad base64", "$scrypt$ln=10,r=8,p=1$c2FsdA$!!!", ErrInvalidHash},
+		{"scrypt huge cost", "$scrypt$ln=99,r=8,p=1$c2FsdA$aGFzaA", ErrInvalidHash},
+		{"bcrypt truncated", "$2a$10$abc", ErrInvalidHash},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := hasher.Verify("correct horse", tt.encoded)
+			assert.ErrorIs(t, err, tt.err)
+			assert.False(t, ok)
+			assert.True(t, hasher.NeedsRehash(tt.encoded))
+		})
+	}
+}
+
+func TestScrypt_InvalidN(t *testing.T) {
+	_, err := (&Scrypt{N: 1000}).Hash("correct horse")
+	assert.ErrorIs(t, err, ErrInvalidScryptN)
+}
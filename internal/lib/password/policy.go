@@ -0,0 +1,131 @@
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BcryptMaxLength is the number of bytes bcrypt uses, the rest of a longer password is ignored
+const BcryptMaxLength = 72
+
+// Codes of the policy violations
+const (
+	CodeTooShort     = "too_short"
+	CodeTooLong      = "too_long"
+	CodeTooSimple    = "too_simple"
+	CodeContainEmail = "contains_email"
+	CodeBreached     = "breached"
+)
+
+// Violation is a rule of the policy the password breaks
+type Violation struct {
+	Code    string
+	Message string
+}
+
+// BreachedChecker tells if the password is known from data breaches
+type BreachedChecker interface {
+	IsBreached(password string) bool
+}
+
+// Policy decides if a password is strong enough. Zero values disable the rules
+type Policy struct {
+	// MinLength is in characters
+	MinLength int
+	// MaxLength is in bytes, as hashing algorithms (bcrypt) count them
+	MaxLength int
+	// MinClasses is how many of character classes (lower, upper case letters, digits, symbols) are required
+	MinClasses int
+	// ForbidEmail rejects passwords containing the email or its local part
+	ForbidEmail bool
+	Breached    BreachedChecker
+}
+
+// Check returns every rule the password of the user with the email breaks
+func (p *Policy) Check(password string, email string) []Violation {
+	var violations []Violation
+
+	if p.MinLength > 0 && utf8.RuneCountInString(password) < p.MinLength {
+		violations = append(violations, Violation{
+			Code:    CodeTooShort,
+			Message: fmt.Sprintf("password must be at least %d characters long", p.MinLength),
+		})
+	}
+
+	if p.MaxLength > 0 && len(password) > p.MaxLength {
+		violations = append(violations, Violation{
+			Code:    CodeTooLong,
+			Message: fmt.Sprintf("password must be at most %d bytes long", p.MaxLength),
+		})
+	}
+
+	if p.MinClasses > 0 && characterClasses(password) < p.MinClasses {
+		violations = append(violations, Violation{
+			Code:    CodeTooSimple,
+			Message: fmt.Sprintf("password must contain at least %d of lower case, upper case letters, digits and symbols", p.MinClasses),
+		})
+	}
+
+	if p.ForbidEmail && containsEmail(password, email) {
+		violations = append(violations, Violation{
+			Code:    CodeContainEmail,
+			Message: "password must not contain the email",
+		})
+	}
+
+	if p.Breached != nil && p.Breached.IsBreached(password) {
+		violations = append(violations, Violation{
+			Code:    CodeBreached,
+			Message: "password is known from data breaches",
+		})
+	}
+
+	return violations
+}
+
+func characterClasses(password string) int {
+	var lower, upper, digit, symbol bool
+
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = true
+		case unicode.IsUpper(r):
+			upper = true
+		case unicode.IsDigit(r):
+			digit = true
+		default:
+			symbol = true
+		}
+	}
+
+	classes := 0
+	for _, ok := range []bool{lower, upper, digit, symbol} {
+		if ok {
+			classes++
+		}
+	}
+
+	return classes
+}
+
+// minEmailPart is the shortest local part of the email worth looking for, shorter ones match too often
+const minEmailPart = 3
+
+func containsEmail(password string, email string) bool {
+	password = strings.ToLower(password)
+	email = strings.ToLower(email)
+
+	if email == "" {
+		return false
+	}
+	if strings.Contains(password, email) {
+		return true
+	}
+
+	local, _, _ := strings.Cut(email, "@")
+
+	return len(local) >= minEmailPart && strings.Contains(password, local)
+}
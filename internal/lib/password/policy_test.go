@@ -0,0 +1,68 @@
+package password
+
+import (
+	"github.com/stretchr/testify/assert"
+	"strings"
+	"testing"
+)
+
+type fakeBreached map[string]bool
+
+func (b fakeBreached) IsBreached(password string) bool {
+	return b[password]
+}
+
+func codes(violations []Violation) []string {
+	var result []string
+	for _, violation := range violations {
+		result = append(result, violation.Code)
+	}
+
+	return result
+}
+
+func TestPolicy_Check(t *testing.T) {
+	policy := &Policy{
+		MinLength:   10,
+		MaxLength:   BcryptMaxLength,
+		MinClasses:  3,
+		ForbidEmail: true,
+		Breached:    fakeBreached{"Password123!": true},
+	}
+
+	tests := []struct {
+		name     string
+		password string
+		email    string
+		want     []string
+	}{
+		{name: "strong", password: "correct-Horse-battery", email: "user@example.com"},
+		{name: "too short", password: "aB1!", email: "user@example.com", want: []string{CodeTooShort}},
+		{name: "too long", password: "aB1!" + strings.Repeat("x", BcryptMaxLength), email: "user@example.com", want: []string{CodeTooLong}},
+		{name: "too simple", password: "onlylowercaseletters", email: "user@example.com", want: []string{CodeTooSimple}},
+		{name: "contains local part", password: "my-Name-is-JOHNDOE", email: "johndoe@example.com", want: []string{CodeContainEmail}},
+		{name: "contains email", password: "Jd@example.com1", email: "jd@example.com", want: []string{CodeContainEmail}},
+		{name: "short local part is allowed", password: "Jd-jd-jd-jd-jd", email: "jd@example.com"},
+		{name: "breached", password: "Password123!", email: "user@example.com", want: []string{CodeBreached}},
+		{name: "several", password: "abc", email: "user@example.com", want: []string{CodeTooShort, CodeTooSimple}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, codes(policy.Check(tt.password, tt.email)))
+		})
+	}
+}
+
+func TestPolicy_Check_MinLengthInCharacters(t *testing.T) {
+	policy := &Policy{MinLength: 4}
+
+	// 4 characters, 8 bytes
+	assert.Empty(t, policy.Check("паро", ""))
+}
+
+func TestPolicy_Check_ZeroDisablesRules(t *testing.T) {
+	policy := &Policy{}
+
+	assert.Empty(t, policy.Check("a", "a@example.com"))
+}
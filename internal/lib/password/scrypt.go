@@ -0,0 +1,119 @@
+package password
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/scrypt"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+const scryptID = "scrypt"
+
+const (
+	DefaultScryptN = 1 << 15
+	DefaultScryptR = 8
+	DefaultScryptP = 1
+)
+
+var ErrInvalidScryptN = errors.New("scrypt N must be a power of two greater than 1")
+
+// Scrypt zero parameters are replaced by the defaults
+type Scrypt struct {
+	// N is the CPU/memory cost, a power of two
+	N int
+	R int
+	P int
+}
+
+func (s *Scrypt) Hash(password string) (string, error) {
+	const op = "lib.password.Scrypt.Hash"
+
+	n, r, p := s.params()
+	if n < 2 || n&(n-1) != 0 {
+		return "", fmt.Errorf("%s: %w", op, ErrInvalidScryptN)
+	}
+
+	salt, err := newSalt(defaultSaltLength)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	hash, err := scrypt.Key([]byte(password), salt, n, r, p, defaultKeyLength)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
+	}
+
+	return phc{
+		id: scryptID,
+		params: map[string]string{
+			"ln": strconv.Itoa(bits.TrailingZeros(uint(n))),
+			"r":  strconv.Itoa(r),
+			"p":  strconv.Itoa(p),
+		},
+		salt: salt,
+		hash: hash,
+	}.String(), nil
+}
+
+func (s *Scrypt) Verify(password string, encoded string) (bool, error) {
+	const op = "lib.password.Scrypt.Verify"
+
+	p, err := parsePHC(encoded, scryptID)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	ln, err := p.uint("ln", 6)
+	if err != nil || ln < 1 || ln > 62 {
+		return false, fmt.Errorf("%s: %w", op, ErrInvalidHash)
+	}
+	r, err := p.uint("r", 31)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+	parallel, err := p.uint("p", 31)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	hash, err := scrypt.Key([]byte(password), p.salt, 1<<ln, int(r), int(parallel), len(p.hash))
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return equal(hash, p.hash), nil
+}
+
+func (s *Scrypt) Owns(encoded string) bool {
+	return strings.HasPrefix(encoded, "$"+scryptID+"$")
+}
+
+func (s *Scrypt) Current(encoded string) bool {
+	p, err := parsePHC(encoded, scryptID)
+	if err != nil {
+		return false
+	}
+
+	n, r, parallel := s.params()
+
+	return p.params["ln"] == strconv.Itoa(bits.TrailingZeros(uint(n))) &&
+		p.params["r"] == strconv.Itoa(r) &&
+		p.params["p"] == strconv.Itoa(parallel)
+}
+
+func (s *Scrypt) params() (n int, r int, p int) {
+	n, r, p = s.N, s.R, s.P
+	if n == 0 {
+		n = DefaultScryptN
+	}
+	if r == 0 {
+		r = DefaultScryptR
+	}
+	if p == 0 {
+		p = DefaultScryptP
+	}
+
+	return n, r, p
+}
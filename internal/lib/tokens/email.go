@@ -0,0 +1,37 @@
+package tokens
+
+import (
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/link"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emailVerificationPurpose binds email verification tokens to the verification link
+const emailVerificationPurpose = "email verification"
+
+// EmailVerificationToken returns the token of the link which verifies the current email of the user.
+// It is spent once the email is verified, and is void if the email changes
+func (m *Manager) EmailVerificationToken(user models.User, expiresAt time.Time) string {
+	return link.Sign(m.RefreshKey, emailVerificationPurpose, strconv.FormatInt(user.UID, 10)+":"+user.Email, expiresAt)
+}
+
+// VerifyEmailVerificationToken returns the user and the email the token was issued for
+func (m *Manager) VerifyEmailVerificationToken(token string) (int64, string, error) {
+	const op = "internal.lib.tokens.VerifyEmailVerificationToken"
+
+	subject, err := link.Verify(m.RefreshKey, emailVerificationPurpose, token)
+	if err != nil {
+		return 0, "", fmt.Errorf("%s: %w: %w", op, ErrInvalidToken, err)
+	}
+
+	rawUID, email, _ := strings.Cut(subject, ":")
+	uid, err := strconv.ParseInt(rawUID, 10, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("%s: %w: %w", op, ErrInvalidToken, err)
+	}
+
+	return uid, email, nil
+}
@@ -1,8 +1,10 @@
 package tokens
 
 import (
+	"fmt"
 	"github.com/northwindman/testREST-autentification/internal/domain/models"
 	"github.com/northwindman/testREST-autentification/internal/lib/tokens/jwt"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/keys"
 	"github.com/northwindman/testREST-autentification/internal/lib/tokens/refresh"
 )
 
@@ -10,17 +12,17 @@ const (
 	AccessTokenLength = 30
 )
 
-func GenTokens(ip string, email string, secret string, accessTokenLength int) (models.Token, error) {
+func GenTokens(opts myjwt.Options, claims myjwt.Claims, key *keys.Key, accessTokenLength int) (models.Token, error) {
 	const op = "internal.lib.tokens.GenTokens"
 
 	rfToken, err := refresh.New(accessTokenLength)
 	if err != nil {
-		return models.Token{}, err
+		return models.Token{}, fmt.Errorf("%s: %w", op, err)
 	}
 
-	acToken, err := myjwt.New(ip, email, secret)
+	acToken, err := myjwt.New(opts, claims, key)
 	if err != nil {
-		return models.Token{}, err
+		return models.Token{}, fmt.Errorf("%s: %w", op, err)
 	}
 
 	return models.Token{
@@ -4,29 +4,81 @@ import (
 	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/lib/random"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/keys"
+	"time"
+)
+
+const (
+	IDLength = 16
 )
 
 var (
 	ErrEmptyClaims   = errors.New("empty claims")
 	ErrInvalidClaims = errors.New("invalid claims")
+	ErrNoSigningKey  = errors.New("no signing key")
 )
 
-// NewToken creates a new JWT token for given user
-func New(ip string, email string, secret string) (string, error) {
+// Claims of the access token. Subject carries the user's UID
+type Claims struct {
+	IP        string `json:"ip"`
+	Email     string `json:"email"`
+	SessionID string `json:"sid"`
+	ClientID  string `json:"client_id,omitempty"`
+	// Scope is a space separated list of granted scopes
+	Scope string `json:"scope,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Options describe how access tokens are issued and validated
+type Options struct {
+	Issuer   string
+	Audience string
+	TTL      time.Duration
+	// Leeway is the allowed clock skew between this service and token consumers
+	Leeway time.Duration
+	// ClientID and Scope are put in the tokens which do not have their own
+	ClientID string
+	Scope    string
+}
+
+// New creates a new JWT token with given claims signed by the key, registered claims
+// (exp, iat, nbf, jti, iss, aud) are filled in from opts
+func New(opts Options, claims Claims, key *keys.Key) (string, error) {
 	const op = "lib.token.jwt.NewAccessToken"
 
-	if secret == "" {
-		return "", fmt.Errorf("empty secret")
+	if key == nil {
+		return "", fmt.Errorf("%s: %w", op, ErrNoSigningKey)
+	}
+
+	if claims.Subject == "" {
+		return "", fmt.Errorf("%s: empty subject", op)
+	}
+
+	jti, err := random.NewSecret(IDLength)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
-	token := jwt.New(jwt.SigningMethodHS512)
+	now := time.Now()
+
+	claims.ID = jti
+	if claims.ClientID == "" {
+		claims.ClientID = opts.ClientID
+	}
+	if claims.Scope == "" {
+		claims.Scope = opts.Scope
+	}
+	claims.Issuer = opts.Issuer
+	claims.Audience = jwt.ClaimStrings{opts.Audience}
+	claims.IssuedAt = jwt.NewNumericDate(now)
+	claims.NotBefore = jwt.NewNumericDate(now)
+	claims.ExpiresAt = jwt.NewNumericDate(now.Add(opts.TTL))
 
-	claims := token.Claims.(jwt.MapClaims)
-	claims["ip"] = ip
-	claims["email"] = email
+	token := jwt.NewWithClaims(key.SigningMethod(), claims)
+	token.Header["kid"] = key.ID
 
-	tokenString, err := token.SignedString([]byte(secret))
+	tokenString, err := token.SignedString(key.Private)
 	if err != nil {
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
@@ -34,7 +86,8 @@ func New(ip string, email string, secret string) (string, error) {
 	return tokenString, nil
 }
 
-// GetClaims returns jwt.MapClaims for check fields
+// GetClaims returns jwt.MapClaims for check fields. The signature is NOT verified,
+// so the claims must not be trusted for authentication or to look anything up
 func GetClaims(tokenString string) (jwt.MapClaims, error) {
 	const op = "lib.token.jwt.GetClaims"
 
@@ -52,6 +105,10 @@ func GetClaims(tokenString string) (jwt.MapClaims, error) {
 		if !emailOk || email == "" {
 			return nil, fmt.Errorf("%s: %w", op, ErrEmptyClaims)
 		}
+		sid, sidOk := claims["sid"].(string)
+		if !sidOk || sid == "" {
+			return nil, fmt.Errorf("%s: %w", op, ErrEmptyClaims)
+		}
 
 		return claims, nil
 	}
@@ -59,39 +116,88 @@ func GetClaims(tokenString string) (jwt.MapClaims, error) {
 	return nil, fmt.Errorf("%s: %w", op, ErrInvalidClaims)
 }
 
-// ParseToken check if token is valid and original
-func ParseToken(tokenString string, secret string) (models.User, error) {
+// ParseToken check if token is valid and signed by one of the ring's keys, is issued
+// by opts.Issuer for opts.Audience and is not expired
+func ParseToken(tokenString string, ring *keys.Ring, opts Options) (*Claims, error) {
 	const op = "lib.token.jwt.Parse"
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if token.Method.Alg() != jwt.SigningMethodHS512.Alg() {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
+	claims, err := parse(tokenString, ring, opts, false)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
 
-		return []byte(secret), nil
-	})
+	return claims, nil
+}
+
+// ParseExpiredToken works as ParseToken but accepts tokens which are already expired.
+// It is used to refresh tokens, where the access token is usually outdated
+func ParseExpiredToken(tokenString string, ring *keys.Ring, opts Options) (*Claims, error) {
+	const op = "lib.token.jwt.ParseExpired"
+
+	claims, err := parse(tokenString, ring, opts, true)
 	if err != nil {
-		return models.User{}, fmt.Errorf("%s: %w", op, err)
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return claims, nil
+}
+
+func parse(tokenString string, ring *keys.Ring, opts Options, allowExpired bool) (*Claims, error) {
+	claims := &Claims{}
+
+	parserOpts := []jwt.ParserOption{
+		jwt.WithIssuer(opts.Issuer),
+		jwt.WithAudience(opts.Audience),
+		jwt.WithLeeway(opts.Leeway),
+		jwt.WithIssuedAt(),
+		jwt.WithExpirationRequired(),
+	}
+
+	if allowExpired {
+		// Claims are decoded before validation, so the clock can be stopped at the expiry time
+		parserOpts = append(parserOpts, jwt.WithTimeFunc(func() time.Time {
+			now := time.Now()
+			if claims.ExpiresAt != nil && claims.ExpiresAt.Before(now) {
+				return claims.ExpiresAt.Time
+			}
+
+			return now
+		}))
 	}
 
-	var user models.User
+	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if ip, ipOk := claims["ip"].(string); ipOk {
-			user.IP = ip
-		} else {
-			return models.User{}, fmt.Errorf("%s: invalid or missing 'ip' claim", op)
+		key, err := ring.Lookup(kid)
+		if err != nil {
+			return nil, err
 		}
 
-		if email, emailOk := claims["email"].(string); emailOk {
-			user.Email = email
-		} else {
-			return models.User{}, fmt.Errorf("%s: invalid or missing 'email' claim", op)
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 
-	} else {
-		return models.User{}, fmt.Errorf("%s: %w", op, ErrInvalidClaims)
+		return key.Public(), nil
+	}, parserOpts...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !token.Valid {
+		return nil, ErrInvalidClaims
+	}
+
+	if claims.IP == "" {
+		return nil, errors.New("invalid or missing 'ip' claim")
+	}
+
+	if claims.Email == "" {
+		return nil, errors.New("invalid or missing 'email' claim")
+	}
+
+	if claims.Subject == "" {
+		return nil, errors.New("invalid or missing 'sub' claim")
 	}
 
-	return user, nil
+	return claims, nil
 }
@@ -1,31 +1,59 @@
 package myjwt
 
 import (
-	"fmt"
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/keys"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"testing"
+	"time"
 )
 
+func testOptions() Options {
+	return Options{
+		Issuer:   "test-issuer",
+		Audience: "test-audience",
+		TTL:      15 * time.Minute,
+		Leeway:   30 * time.Second,
+	}
+}
+
+func testClaims(ip string, email string, sessionID string) Claims {
+	claims := Claims{
+		IP:        ip,
+		Email:     email,
+		SessionID: sessionID,
+	}
+	claims.Subject = "1"
+
+	return claims
+}
+
+func testKey(t *testing.T) *keys.Key {
+	key, err := keys.Generate(keys.AlgES256)
+	require.NoError(t, err)
+
+	return key
+}
+
 // Test function New from this package
 
 func TestNewAccessToken_Success(t *testing.T) {
 	ip := "127.0.0.1"
 	email := "test@example.com"
-	secret := "mysecretkey"
+	sessionID := "session-id"
+	key := testKey(t)
 
-	tokenString, err := New(ip, email, secret)
+	tokenString, err := New(testOptions(), testClaims(ip, email, sessionID), key)
 
 	assert.NoError(t, err)
 	assert.NotEmpty(t, tokenString)
 
-	// Check that token can parsed with same secret
+	// Check that token can parsed with the public key
 	parsedToken, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(secret), nil
-	})
+		assert.Equal(t, key.ID, token.Header["kid"])
+		return key.Public(), nil
+	}, jwt.WithValidMethods([]string{keys.AlgES256}))
 
 	assert.NoError(t, err)
 	assert.True(t, parsedToken.Valid)
@@ -34,36 +62,60 @@ func TestNewAccessToken_Success(t *testing.T) {
 	if claims, ok := parsedToken.Claims.(jwt.MapClaims); ok && parsedToken.Valid {
 		assert.Equal(t, ip, claims["ip"])
 		assert.Equal(t, email, claims["email"])
+		assert.Equal(t, sessionID, claims["sid"])
+		assert.Equal(t, "1", claims["sub"])
+		assert.Equal(t, "test-issuer", claims["iss"])
+		assert.NotEmpty(t, claims["jti"])
+		assert.NotEmpty(t, claims["exp"])
+		assert.NotEmpty(t, claims["iat"])
+		assert.NotEmpty(t, claims["nbf"])
 	} else {
 		t.Fatal("claims not valid")
 	}
 }
 
+func TestNewAccessToken_AllAlgorithms(t *testing.T) {
+	for _, alg := range []string{keys.AlgRS256, keys.AlgES256, keys.AlgEdDSA} {
+		t.Run(alg, func(t *testing.T) {
+			key, err := keys.Generate(alg)
+			require.NoError(t, err)
+
+			tokenString, err := New(testOptions(), testClaims("127.0.0.1", "test@example.com", "session-id"), key)
+			require.NoError(t, err)
+
+			claims, err := ParseToken(tokenString, keys.NewRing(key), testOptions())
+			require.NoError(t, err)
+			assert.Equal(t, "session-id", claims.SessionID)
+		})
+	}
+}
+
 func TestNewAccessToken_SigningError(t *testing.T) {
 	ip := "127.0.0.1"
 	email := "test@example.com"
-	secret := "" // empty secret
+	sessionID := "session-id"
 
-	tokenString, err := New(ip, email, secret)
+	tokenString, err := New(testOptions(), testClaims(ip, email, sessionID), nil) // no key
 
 	assert.Error(t, err)
 	assert.Equal(t, "", tokenString)
 }
 
-func TestNewAccessToken_InvalidSecret(t *testing.T) {
+func TestNewAccessToken_InvalidKey(t *testing.T) {
 	ip := "127.0.0.1"
 	email := "test@example.com"
-	secret := "mysecretkey"
-	invalidSecret := "wrongsecret"
+	sessionID := "session-id"
+	key := testKey(t)
+	invalidKey := testKey(t)
 
-	tokenString, err := New(ip, email, secret)
+	tokenString, err := New(testOptions(), testClaims(ip, email, sessionID), key)
 
 	assert.NoError(t, err)
 	assert.NotEmpty(t, tokenString)
 
-	// Parsing with invalid secret
+	// Parsing with invalid key
 	parsedToken, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(invalidSecret), nil
+		return invalidKey.Public(), nil
 	})
 
 	assert.Error(t, err)
@@ -73,15 +125,16 @@ func TestNewAccessToken_InvalidSecret(t *testing.T) {
 func TestNewAccessToken_EmptyEmail(t *testing.T) {
 	ip := "127.0.0.1"
 	email := "" // Empty email
-	secret := "mysecretkey"
+	sessionID := "session-id"
+	key := testKey(t)
 
-	tokenString, err := New(ip, email, secret)
+	tokenString, err := New(testOptions(), testClaims(ip, email, sessionID), key)
 
 	assert.NoError(t, err)
 	assert.NotEmpty(t, tokenString)
 
 	parsedToken, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		return []byte(secret), nil
+		return key.Public(), nil
 	})
 
 	assert.NoError(t, err)
@@ -108,6 +161,7 @@ func TestGetClaims_Success(t *testing.T) {
 	assert.NotNil(t, claims)
 	assert.Equal(t, "127.0.0.1", claims["ip"])
 	assert.Equal(t, "test@example.com", claims["email"])
+	assert.Equal(t, "session-id", claims["sid"])
 }
 
 func TestGetClaims_InvalidToken(t *testing.T) {
@@ -133,6 +187,7 @@ func createValidToken(t *testing.T, secret string) string {
 	claims := token.Claims.(jwt.MapClaims)
 	claims["ip"] = "127.0.0.1"
 	claims["email"] = "test@example.com"
+	claims["sid"] = "session-id"
 
 	tokenString, err := token.SignedString([]byte(secret))
 	assert.NoError(t, err)
@@ -153,14 +208,14 @@ func createTokenWithEmptyClaims(t *testing.T) string {
 // Test function ParseToken from this package
 
 func TestParseToken_Success(t *testing.T) {
-	secret := "mysecret"
+	key := testKey(t)
 	userEmail := "test@example.com"
 	userIP := "192.168.1.1"
 
-	tokenString, err := createTestToken(userEmail, userIP, secret)
+	tokenString, err := createTestToken(userEmail, userIP, key)
 	assert.NoError(t, err)
 
-	parsedUser, err := ParseToken(tokenString, secret)
+	parsedUser, err := ParseToken(tokenString, keys.NewRing(key), testOptions())
 	assert.NoError(t, err)
 
 	assert.Equal(t, userEmail, parsedUser.Email)
@@ -168,66 +223,244 @@ func TestParseToken_Success(t *testing.T) {
 }
 
 func TestParseToken_InvalidAlgorithm(t *testing.T) {
-	secret := "mysecret"
+	key := testKey(t)
 	userEmail := "test@example.com"
 	userIP := "192.168.1.1"
 
 	token := jwt.New(jwt.SigningMethodHS256)
+	token.Header["kid"] = key.ID
 	claims := token.Claims.(jwt.MapClaims)
 	claims["email"] = userEmail
 	claims["ip"] = userIP
 
-	tokenString, err := token.SignedString([]byte(secret))
+	tokenString, err := token.SignedString([]byte("mysecret"))
 	assert.NoError(t, err)
 
-	_, err = ParseToken(tokenString, secret)
+	_, err = ParseToken(tokenString, keys.NewRing(key), testOptions())
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "unexpected signing method")
 }
 
+func TestParseToken_UnknownKey(t *testing.T) {
+	tokenString, err := createTestToken("test@example.com", "192.168.1.1", testKey(t))
+	require.NoError(t, err)
+
+	_, err = ParseToken(tokenString, keys.NewRing(testKey(t)), testOptions())
+	assert.ErrorIs(t, err, keys.ErrKeyNotFound)
+}
+
 func TestParseToken_InvalidToken(t *testing.T) {
-	secret := "mysecret"
+	key := testKey(t)
 
-	_, err := ParseToken("invalidTokenString", secret)
+	_, err := ParseToken("invalidTokenString", keys.NewRing(key), testOptions())
 	assert.Error(t, err)
 }
 
 func TestParseToken_EmptyClaims(t *testing.T) {
-	secret := "mysecret"
+	key := testKey(t)
 
-	token := jwt.New(jwt.SigningMethodHS512)
-	tokenString, err := token.SignedString([]byte(secret))
+	token := jwt.New(key.SigningMethod())
+	token.Header["kid"] = key.ID
+	tokenString, err := token.SignedString(key.Private)
 	assert.NoError(t, err)
 
-	_, err = ParseToken(tokenString, secret)
+	_, err = ParseToken(tokenString, keys.NewRing(key), testOptions())
 	assert.Error(t, err)
 }
 
 func TestParseToken_InvalidEmailIPType(t *testing.T) {
-	secret := "mysecret"
+	key := testKey(t)
 
-	token := jwt.New(jwt.SigningMethodHS512)
+	token := jwt.New(key.SigningMethod())
+	token.Header["kid"] = key.ID
 	claims := token.Claims.(jwt.MapClaims)
 	claims["email"] = 123
 	claims["ip"] = true
 
-	tokenString, err := token.SignedString([]byte(secret))
+	tokenString, err := token.SignedString(key.Private)
 	assert.NoError(t, err)
 
-	_, err = ParseToken(tokenString, secret)
+	_, err = ParseToken(tokenString, keys.NewRing(key), testOptions())
 	assert.Error(t, err)
 }
 
-func createTestToken(email, ip, secret string) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS512)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["email"] = email
-	claims["ip"] = ip
+func TestNewAccessToken_EmptySubject(t *testing.T) {
+	claims := testClaims("127.0.0.1", "test@example.com", "session-id")
+	claims.Subject = ""
 
-	tokenString, err := token.SignedString([]byte(secret))
-	if err != nil {
-		return "", err
-	}
+	tokenString, err := New(testOptions(), claims, testKey(t))
+
+	assert.Error(t, err)
+	assert.Empty(t, tokenString)
+}
+
+func TestParseToken_Expired(t *testing.T) {
+	key := testKey(t)
+	opts := testOptions()
+	opts.TTL = -time.Hour
+
+	tokenString, err := New(opts, testClaims("192.168.1.1", "test@example.com", "session-id"), key)
+	require.NoError(t, err)
+
+	_, err = ParseToken(tokenString, keys.NewRing(key), testOptions())
+	assert.ErrorIs(t, err, jwt.ErrTokenExpired)
+}
+
+func TestParseToken_ExpiredWithinLeeway(t *testing.T) {
+	key := testKey(t)
+	opts := testOptions()
+	opts.TTL = -10 * time.Second
+
+	tokenString, err := New(opts, testClaims("192.168.1.1", "test@example.com", "session-id"), key)
+	require.NoError(t, err)
+
+	_, err = ParseToken(tokenString, keys.NewRing(key), testOptions())
+	assert.NoError(t, err)
+}
 
-	return tokenString, nil
+func TestParseToken_WrongIssuer(t *testing.T) {
+	key := testKey(t)
+	opts := testOptions()
+	opts.Issuer = "another-issuer"
+
+	tokenString, err := New(opts, testClaims("192.168.1.1", "test@example.com", "session-id"), key)
+	require.NoError(t, err)
+
+	_, err = ParseToken(tokenString, keys.NewRing(key), testOptions())
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidIssuer)
+}
+
+func TestParseToken_WrongAudience(t *testing.T) {
+	key := testKey(t)
+	opts := testOptions()
+	opts.Audience = "another-audience"
+
+	tokenString, err := New(opts, testClaims("192.168.1.1", "test@example.com", "session-id"), key)
+	require.NoError(t, err)
+
+	_, err = ParseToken(tokenString, keys.NewRing(key), testOptions())
+	assert.ErrorIs(t, err, jwt.ErrTokenInvalidAudience)
+}
+
+func TestParseExpiredToken_Success(t *testing.T) {
+	key := testKey(t)
+
+	tokenString := createExpiredToken(t, testOptions(), key)
+
+	claims, err := ParseExpiredToken(tokenString, keys.NewRing(key), testOptions())
+	require.NoError(t, err)
+	assert.Equal(t, "session-id", claims.SessionID)
+}
+
+func TestParseExpiredToken_WrongIssuer(t *testing.T) {
+	key := testKey(t)
+	opts := testOptions()
+	opts.Issuer = "another-issuer"
+
+	tokenString := createExpiredToken(t, opts, key)
+
+	_, err := ParseExpiredToken(tokenString, keys.NewRing(key), testOptions())
+	assert.Error(t, err)
+}
+
+func createExpiredToken(t *testing.T, opts Options, key *keys.Key) string {
+	claims := testClaims("192.168.1.1", "test@example.com", "session-id")
+	claims.ID = "jti"
+	claims.Issuer = opts.Issuer
+	claims.Audience = jwt.ClaimStrings{opts.Audience}
+	claims.IssuedAt = jwt.NewNumericDate(time.Now().Add(-2 * time.Hour))
+	claims.NotBefore = claims.IssuedAt
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour))
+
+	token := jwt.NewWithClaims(key.SigningMethod(), claims)
+	token.Header["kid"] = key.ID
+
+	tokenString, err := token.SignedString(key.Private)
+	require.NoError(t, err)
+
+	return tokenString
+}
+
+func createTestToken(email, ip string, key *keys.Key) (string, error) {
+	return New(testOptions(), testClaims(ip, email, "session-id"), key)
+}
+
+func TestParseToken_RotatedKey(t *testing.T) {
+	old := testKey(t)
+	ring := keys.NewRing(old)
+
+	tokenString, err := createTestToken("test@example.com", "192.168.1.1", ring.Active())
+	require.NoError(t, err)
+
+	ring.Rotate(testKey(t), time.Hour)
+
+	// Token signed before rotation is verified by the retired key
+	_, err = ParseToken(tokenString, ring, testOptions())
+	assert.NoError(t, err)
+}
+
+// Fuzz tests, run with go test -fuzz=FuzzGetClaims or -fuzz=FuzzParseToken
+
+func FuzzGetClaims(f *testing.F) {
+	key, err := keys.Generate(keys.AlgES256)
+	require.NoError(f, err)
+
+	valid, err := New(testOptions(), testClaims("127.0.0.1", "test@example.com", "session-id"), key)
+	require.NoError(f, err)
+
+	f.Add(valid)
+	f.Add("")
+	f.Add("invalid.token.string")
+	f.Add("eyJhbGciOiJub25lIn0.eyJpcCI6MX0.")
+	f.Add("eyJhbGciOiJub25lIn0.eyJpcCI6IjEiLCJlbWFpbCI6WyJhIl0sInNpZCI6bnVsbH0.")
+
+	f.Fuzz(func(t *testing.T, tokenString string) {
+		claims, err := GetClaims(tokenString)
+		if err != nil {
+			assert.Nil(t, claims)
+			return
+		}
+
+		for _, name := range []string{"ip", "email", "sid"} {
+			value, ok := claims[name].(string)
+			assert.True(t, ok, "claim %s must be a string", name)
+			assert.NotEmpty(t, value, "claim %s must not be empty", name)
+		}
+	})
+}
+
+func FuzzParseToken(f *testing.F) {
+	key, err := keys.Generate(keys.AlgES256)
+	require.NoError(f, err)
+	ring := keys.NewRing(key)
+
+	valid, err := New(testOptions(), testClaims("127.0.0.1", "test@example.com", "session-id"), key)
+	require.NoError(f, err)
+
+	expiredOpts := testOptions()
+	expiredOpts.TTL = -time.Hour
+	expired, err := New(expiredOpts, testClaims("127.0.0.1", "test@example.com", "session-id"), key)
+	require.NoError(f, err)
+
+	f.Add(valid)
+	f.Add(expired)
+	f.Add(valid[:len(valid)-2])
+	f.Add("")
+	f.Add("invalid.token.string")
+	f.Add("eyJhbGciOiJub25lIiwidHlwIjoiSldUIn0.eyJpcCI6IjEiLCJlbWFpbCI6ImEiLCJzdWIiOiIxIn0.")
+
+	f.Fuzz(func(t *testing.T, tokenString string) {
+		claims, err := ParseToken(tokenString, ring, testOptions())
+		if err != nil {
+			assert.Nil(t, claims)
+			return
+		}
+
+		// Only tokens signed by the ring's key can be valid
+		assert.NotEmpty(t, claims.IP)
+		assert.NotEmpty(t, claims.Email)
+		assert.NotEmpty(t, claims.Subject)
+		require.NotNil(t, claims.ExpiresAt)
+		assert.True(t, claims.ExpiresAt.After(time.Now().Add(-testOptions().Leeway)))
+	})
 }
@@ -0,0 +1,105 @@
+package keys
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	keyFileExt = ".pem"
+)
+
+// LoadDir builds a ring from PEM files of the directory. The most recent file holds the active
+// key, every older key verifies tokens until retireAfter passes since the next key appeared.
+// If the directory has no keys, a new one is generated there
+func LoadDir(dir string, alg string, retireAfter time.Duration) (*Ring, error) {
+	const op = "lib.tokens.keys.LoadDir"
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*"+keyFileExt))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if len(paths) == 0 {
+		key, err := GenerateToDir(dir, alg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+
+		return NewRing(key), nil
+	}
+
+	type file struct {
+		path    string
+		modTime time.Time
+	}
+
+	files := make([]file, 0, len(paths))
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		files = append(files, file{path: path, modTime: info.ModTime()})
+	}
+
+	// Newest first
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.After(files[j].modTime)
+	})
+
+	now := time.Now()
+
+	var retired []*Key
+	for i := 1; i < len(files); i++ {
+		notAfter := files[i-1].modTime.Add(retireAfter)
+		if !now.Before(notAfter) {
+			continue
+		}
+
+		key, err := Load(files[i].path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", op, err)
+		}
+		key.NotBefore = files[i].modTime
+		key.NotAfter = notAfter
+
+		retired = append(retired, key)
+	}
+
+	active, err := Load(files[0].path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return NewRing(active, retired...), nil
+}
+
+// GenerateToDir generates a new key for the algorithm and saves it in the directory,
+// so the key becomes the active one on the next LoadDir
+func GenerateToDir(dir string, alg string) (*Key, error) {
+	const op = "lib.tokens.keys.GenerateToDir"
+
+	key, err := Generate(alg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	data, err := key.MarshalPEM()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = os.WriteFile(filepath.Join(dir, key.ID+keyFileExt), data, 0o600); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,219 @@
+package keys
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"os"
+	"time"
+)
+
+const (
+	AlgRS256 = "RS256"
+	AlgES256 = "ES256"
+	AlgEdDSA = "EdDSA"
+
+	RSAKeyBits = 2048
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported signing algorithm")
+	ErrUnsupportedKey       = errors.New("unsupported key type")
+	ErrInvalidPEM           = errors.New("invalid PEM data")
+)
+
+// Key is an asymmetric key for signing access tokens
+type Key struct {
+	// ID is put in the "kid" header of the tokens signed with the key
+	ID        string
+	Algorithm string
+	Private   crypto.Signer
+	// NotBefore and NotAfter bound the time the key verifies tokens, zero values mean no bound
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// Generate creates a new key for the algorithm
+func Generate(alg string) (*Key, error) {
+	const op = "lib.tokens.keys.Generate"
+
+	var (
+		private crypto.Signer
+		err     error
+	)
+
+	switch alg {
+	case AlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, RSAKeyBits)
+	case AlgES256:
+		private, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case AlgEdDSA:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	default:
+		return nil, fmt.Errorf("%s: %w: %s", op, ErrUnsupportedAlgorithm, alg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	key, err := newKey(private)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// Load reads a PEM encoded private key (PKCS#8, PKCS#1 or SEC 1) from the file
+func Load(path string) (*Key, error) {
+	const op = "lib.tokens.keys.Load"
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	key, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// LoadOrGenerate loads the key from the file, if the file does not exist
+// a new key for the algorithm is generated and saved there
+func LoadOrGenerate(path string, alg string) (*Key, error) {
+	const op = "lib.tokens.keys.LoadOrGenerate"
+
+	if _, err := os.Stat(path); err == nil {
+		return Load(path)
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	key, err := Generate(alg)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	data, err := key.MarshalPEM()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = os.WriteFile(path, data, 0o600); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// Parse decodes a PEM encoded private key
+func Parse(data []byte) (*Key, error) {
+	const op = "lib.tokens.keys.Parse"
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: %w", op, ErrInvalidPEM)
+	}
+
+	var (
+		private any
+		err     error
+	)
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		private, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		private, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", op, ErrUnsupportedKey)
+	}
+
+	key, err := newKey(signer)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
+}
+
+// MarshalPEM encodes the private key in PKCS#8 PEM
+func (k *Key) MarshalPEM() ([]byte, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(k.Private)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// Public returns the public part of the key for tokens verification
+func (k *Key) Public() crypto.PublicKey {
+	return k.Private.Public()
+}
+
+// ValidAt reports if the key can verify tokens at the moment
+func (k *Key) ValidAt(t time.Time) bool {
+	if !k.NotBefore.IsZero() && t.Before(k.NotBefore) {
+		return false
+	}
+
+	return k.NotAfter.IsZero() || t.Before(k.NotAfter)
+}
+
+// SigningMethod returns the jwt signing method of the key's algorithm
+func (k *Key) SigningMethod() jwt.SigningMethod {
+	return jwt.GetSigningMethod(k.Algorithm)
+}
+
+func newKey(private crypto.Signer) (*Key, error) {
+	var alg string
+
+	switch pk := private.(type) {
+	case *rsa.PrivateKey:
+		alg = AlgRS256
+	case *ecdsa.PrivateKey:
+		if pk.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("%w: curve %s", ErrUnsupportedKey, pk.Curve.Params().Name)
+		}
+		alg = AlgES256
+	case ed25519.PrivateKey:
+		alg = AlgEdDSA
+	default:
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedKey, private)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	thumbprint := sha256.Sum256(der)
+
+	return &Key{
+		ID:        base64.RawURLEncoding.EncodeToString(thumbprint[:])[:16],
+		Algorithm: alg,
+		Private:   private,
+	}, nil
+}
@@ -0,0 +1,58 @@
+package keys
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+)
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		alg string
+		kty string
+	}{
+		{AlgRS256, "RSA"},
+		{AlgES256, "EC"},
+		{AlgEdDSA, "OKP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.alg, func(t *testing.T) {
+			key, err := Generate(tt.alg)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.alg, key.Algorithm)
+			assert.NotEmpty(t, key.ID)
+			assert.Equal(t, tt.alg, key.SigningMethod().Alg())
+
+			jwk := key.JWK()
+			assert.Equal(t, tt.kty, jwk.Kty)
+			assert.Equal(t, key.ID, jwk.Kid)
+			assert.Equal(t, "sig", jwk.Use)
+		})
+	}
+}
+
+func TestGenerate_UnsupportedAlgorithm(t *testing.T) {
+	_, err := Generate("HS512")
+	assert.ErrorIs(t, err, ErrUnsupportedAlgorithm)
+}
+
+func TestLoadOrGenerate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "signing.pem")
+
+	generated, err := LoadOrGenerate(path, AlgEdDSA)
+	require.NoError(t, err)
+
+	loaded, err := LoadOrGenerate(path, AlgEdDSA)
+	require.NoError(t, err)
+
+	assert.Equal(t, generated.ID, loaded.ID)
+	assert.Equal(t, generated.Algorithm, loaded.Algorithm)
+}
+
+func TestParse_InvalidPEM(t *testing.T) {
+	_, err := Parse([]byte("not a pem"))
+	assert.ErrorIs(t, err, ErrInvalidPEM)
+}
@@ -0,0 +1,100 @@
+package keys
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrKeyNotFound = errors.New("key not found")
+)
+
+// Ring holds the keys of the service: the active one signs new tokens, while retired keys
+// keep verifying tokens signed before rotation until their NotAfter. It is safe for concurrent use
+type Ring struct {
+	mu      sync.RWMutex
+	active  *Key
+	retired []*Key
+}
+
+func NewRing(active *Key, retired ...*Key) *Ring {
+	return &Ring{
+		active:  active,
+		retired: retired,
+	}
+}
+
+// Active returns the key for signing new tokens
+func (r *Ring) Active() *Key {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.active
+}
+
+// Lookup returns the key for verification of a token signed with kid
+func (r *Ring) Lookup(kid string) (*Key, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+
+	for _, key := range r.keys() {
+		if key.ID == kid && key.ValidAt(now) {
+			return key, nil
+		}
+	}
+
+	return nil, ErrKeyNotFound
+}
+
+// Rotate makes next the active key. The previous active key is retired: it keeps verifying
+// tokens for retireAfter, which must outlive the access tokens it has signed
+func (r *Ring) Rotate(next *Key, retireAfter time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+
+	if r.active != nil {
+		r.active.NotAfter = now.Add(retireAfter)
+		r.retired = append([]*Key{r.active}, r.retired...)
+	}
+
+	r.active = next
+
+	// Drop retired keys which can't verify anything anymore
+	retired := r.retired[:0]
+	for _, key := range r.retired {
+		if key.NotAfter.IsZero() || now.Before(key.NotAfter) {
+			retired = append(retired, key)
+		}
+	}
+	r.retired = retired
+}
+
+// JWKS returns public parts of the keys of the ring which are not expired yet
+func (r *Ring) JWKS() JWKS {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+
+	jwks := JWKS{Keys: []JWK{}}
+	for _, key := range r.keys() {
+		if key.NotAfter.IsZero() || now.Before(key.NotAfter) {
+			jwks.Keys = append(jwks.Keys, key.JWK())
+		}
+	}
+
+	return jwks
+}
+
+func (r *Ring) keys() []*Key {
+	if r.active == nil {
+		return r.retired
+	}
+
+	return append([]*Key{r.active}, r.retired...)
+}
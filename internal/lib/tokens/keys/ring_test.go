@@ -0,0 +1,120 @@
+package keys
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRing_Lookup(t *testing.T) {
+	key := mustGenerate(t)
+
+	ring := NewRing(key)
+
+	found, err := ring.Lookup(key.ID)
+	require.NoError(t, err)
+	assert.Equal(t, key, found)
+
+	_, err = ring.Lookup("unknown")
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	assert.Len(t, ring.JWKS().Keys, 1)
+}
+
+func TestRing_Rotate(t *testing.T) {
+	old := mustGenerate(t)
+	next := mustGenerate(t)
+
+	ring := NewRing(old)
+	ring.Rotate(next, time.Hour)
+
+	assert.Equal(t, next, ring.Active())
+
+	// Retired key keeps verifying tokens during the overlap
+	found, err := ring.Lookup(old.ID)
+	require.NoError(t, err)
+	assert.Equal(t, old, found)
+
+	assert.Len(t, ring.JWKS().Keys, 2)
+}
+
+func TestRing_RotateWithoutOverlap(t *testing.T) {
+	old := mustGenerate(t)
+	next := mustGenerate(t)
+
+	ring := NewRing(old)
+	ring.Rotate(next, 0)
+
+	_, err := ring.Lookup(old.ID)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+
+	assert.Len(t, ring.JWKS().Keys, 1)
+}
+
+func TestRing_LookupNotYetValid(t *testing.T) {
+	key := mustGenerate(t)
+	key.NotBefore = time.Now().Add(time.Hour)
+
+	ring := NewRing(mustGenerate(t), key)
+
+	_, err := ring.Lookup(key.ID)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func TestLoadDir(t *testing.T) {
+	dir := t.TempDir()
+
+	// Empty directory gets a new key
+	ring, err := LoadDir(dir, AlgES256, time.Hour)
+	require.NoError(t, err)
+	first := ring.Active()
+	require.NotNil(t, first)
+
+	backdate(t, filepath.Join(dir, first.ID+keyFileExt), -2*time.Hour)
+
+	second, err := GenerateToDir(dir, AlgEdDSA)
+	require.NoError(t, err)
+
+	ring, err = LoadDir(dir, AlgES256, time.Hour)
+	require.NoError(t, err)
+
+	assert.Equal(t, second.ID, ring.Active().ID)
+
+	_, err = ring.Lookup(first.ID)
+	assert.NoError(t, err)
+}
+
+func TestLoadDir_SkipsRetiredKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := GenerateToDir(dir, AlgES256)
+	require.NoError(t, err)
+	backdate(t, filepath.Join(dir, first.ID+keyFileExt), -3*time.Hour)
+
+	second, err := GenerateToDir(dir, AlgES256)
+	require.NoError(t, err)
+	backdate(t, filepath.Join(dir, second.ID+keyFileExt), -2*time.Hour)
+
+	ring, err := LoadDir(dir, AlgES256, time.Hour)
+	require.NoError(t, err)
+
+	assert.Equal(t, second.ID, ring.Active().ID)
+
+	_, err = ring.Lookup(first.ID)
+	assert.ErrorIs(t, err, ErrKeyNotFound)
+}
+
+func mustGenerate(t *testing.T) *Key {
+	key, err := Generate(AlgES256)
+	require.NoError(t, err)
+
+	return key
+}
+
+func backdate(t *testing.T, path string, d time.Duration) {
+	modTime := time.Now().Add(d)
+	require.NoError(t, os.Chtimes(path, modTime, modTime))
+}
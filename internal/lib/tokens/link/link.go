@@ -0,0 +1,65 @@
+// Package link signs short-lived tokens put in links of emails. A token carries its subject and expiry,
+// so it needs no storage, and is bound to the purpose it was signed for
+package link
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid link token")
+	ErrTokenExpired = errors.New("link token expired")
+)
+
+// Sign returns the token of the subject valid until expiresAt, "payload.signature" in URL-safe base64
+func Sign(key []byte, purpose string, subject string, expiresAt time.Time) string {
+	payload := binary.BigEndian.AppendUint64(nil, uint64(expiresAt.Unix()))
+	payload = append(payload, subject...)
+
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(mac(key, purpose, payload))
+}
+
+// Verify returns the subject of the token signed for the purpose with the key
+func Verify(key []byte, purpose string, token string) (string, error) {
+	encodedPayload, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil || len(payload) < 8 {
+		return "", ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if !hmac.Equal(signature, mac(key, purpose, payload)) {
+		return "", ErrInvalidToken
+	}
+
+	expiresAt := time.Unix(int64(binary.BigEndian.Uint64(payload)), 0)
+	if time.Now().After(expiresAt) {
+		return "", ErrTokenExpired
+	}
+
+	return string(payload[8:]), nil
+}
+
+// mac signs the payload for the purpose, so a token of one kind of link is not accepted by another
+func mac(key []byte, purpose string, payload []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(purpose))
+	h.Write([]byte{0})
+	h.Write(payload)
+
+	return h.Sum(nil)
+}
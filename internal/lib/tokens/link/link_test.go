@@ -0,0 +1,53 @@
+package link
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"strings"
+	"testing"
+	"time"
+)
+
+var key = []byte("0123456789abcdef0123456789abcdef")
+
+func TestSignVerify(t *testing.T) {
+	token := Sign(key, "revoke", "session-id", time.Now().Add(time.Hour))
+
+	assert.NotContains(t, token, "=")
+	assert.Equal(t, 1, strings.Count(token, "."))
+
+	subject, err := Verify(key, "revoke", token)
+	require.NoError(t, err)
+	assert.Equal(t, "session-id", subject)
+}
+
+func TestVerify_Errors(t *testing.T) {
+	expiresAt := time.Now().Add(time.Hour)
+	token := Sign(key, "revoke", "session-id", expiresAt)
+	payload, signature, _ := strings.Cut(token, ".")
+	otherPayload, _, _ := strings.Cut(Sign(key, "revoke", "other-id", expiresAt), ".")
+
+	tests := []struct {
+		name    string
+		key     []byte
+		purpose string
+		token   string
+		err     error
+	}{
+		{"other key", []byte("fedcba9876543210fedcba9876543210"), "revoke", token, ErrInvalidToken},
+		{"other purpose", key, "reset", token, ErrInvalidToken},
+		{"tampered", key, "revoke", otherPayload + "." + signature, ErrInvalidToken},
+		{"no signature", key, "revoke", payload, ErrInvalidToken},
+		{"short payload", key, "revoke", "AAAA." + signature, ErrInvalidToken},
+		{"garbage", key, "revoke", "!!!.???", ErrInvalidToken},
+		{"expired", key, "revoke", Sign(key, "revoke", "session-id", time.Now().Add(-time.Second)), ErrTokenExpired},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			subject, err := Verify(tt.key, tt.purpose, tt.token)
+			assert.ErrorIs(t, err, tt.err)
+			assert.Empty(t, subject)
+		})
+	}
+}
@@ -0,0 +1,29 @@
+package tokens
+
+import (
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/refresh"
+)
+
+const PasswordResetTokenLength = 32
+
+// passwordResetPurpose separates hashes of reset tokens from hashes of refresh tokens made with the same key
+const passwordResetPurpose = "password reset"
+
+// NewPasswordResetToken returns a random token of the password reset link and the hash to store it with
+func (m *Manager) NewPasswordResetToken() (string, []byte, error) {
+	const op = "internal.lib.tokens.NewPasswordResetToken"
+
+	token, err := refresh.New(PasswordResetTokenLength)
+	if err != nil {
+		return "", nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return token, m.HashPasswordResetToken(token), nil
+}
+
+// HashPasswordResetToken returns the hash the password reset of the token is stored with.
+// The key of refresh tokens is reused with the purpose, so the hash never matches a refresh token
+func (m *Manager) HashPasswordResetToken(token string) []byte {
+	return refresh.Hash(m.RefreshKey, passwordResetPurpose+"\x00"+token)
+}
@@ -0,0 +1,18 @@
+package tokens_test
+
+import (
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/tokenstest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+)
+
+func TestManager_HashPasswordResetToken(t *testing.T) {
+	manager := tokenstest.NewManager(t)
+
+	token, tokenHash, err := manager.NewPasswordResetToken()
+	require.NoError(t, err)
+
+	assert.Equal(t, tokenHash, manager.HashPasswordResetToken(token))
+	assert.NotEqual(t, manager.HashRefreshToken(token), tokenHash, "reset and refresh tokens are hashed apart")
+}
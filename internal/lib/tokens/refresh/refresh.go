@@ -1,20 +1,29 @@
 package refresh
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
 	"fmt"
 )
 
+const (
+	// MinKeyLength of the HMAC key, shorter keys weaken the hashes
+	MinKeyLength = 32
+)
+
 var (
 	ErrInvalidTokenLength = errors.New("invalid token length")
+	ErrShortKey           = fmt.Errorf("refresh token key must be at least %d bytes", MinKeyLength)
 )
 
-// New create new token string
+// New create new opaque token of length random bytes, the token is URL-safe base64 without padding
 func New(length int) (string, error) {
 	const op = "lib.tokens.refresh.New"
 
-	if length <= 0 {
+	if length < 0 {
 		return "", fmt.Errorf(op+": %w", ErrInvalidTokenLength)
 	}
 
@@ -24,5 +33,26 @@ func New(length int) (string, error) {
 		return "", fmt.Errorf("%s: %w", op, err)
 	}
 
-	return string(tokenBytes), nil
+	return base64.RawURLEncoding.EncodeToString(tokenBytes), nil
+}
+
+// Hash returns HMAC-SHA256 of the token. Tokens are random, so unlike passwords they need
+// no salt or slow hashing, and the same token always has the same hash to look it up by
+func Hash(key []byte, token string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(token))
+
+	return mac.Sum(nil)
+}
+
+// NewKey returns a random HMAC key
+func NewKey() ([]byte, error) {
+	const op = "lib.tokens.refresh.NewKey"
+
+	key := make([]byte, MinKeyLength)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return key, nil
 }
@@ -1,6 +1,7 @@
 package refresh
 
 import (
+	"encoding/base64"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"testing"
@@ -13,7 +14,9 @@ func TestNew_Success(t *testing.T) {
 
 	require.NoError(t, err)
 
-	assert.Equal(t, length, len(token))
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	require.NoError(t, err)
+	assert.Equal(t, length, len(decoded))
 }
 
 func TestNew_ZeroLength(t *testing.T) {
@@ -35,3 +38,22 @@ func TestNew_NegativeLength(t *testing.T) {
 
 	assert.Empty(t, token)
 }
+
+func TestHash(t *testing.T) {
+	key := []byte("0123456789abcdef0123456789abcdef")
+
+	hash := Hash(key, "token")
+
+	assert.Len(t, hash, 32)
+	assert.Equal(t, hash, Hash(key, "token"), "hash must be deterministic")
+	assert.NotEqual(t, hash, Hash(key, "other token"))
+	assert.NotEqual(t, hash, Hash([]byte("fedcba9876543210fedcba9876543210"), "token"))
+}
+
+func TestNewKey(t *testing.T) {
+	key, err := NewKey()
+
+	require.NoError(t, err)
+
+	assert.Len(t, key, MinKeyLength)
+}
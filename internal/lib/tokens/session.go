@@ -0,0 +1,107 @@
+package tokens
+
+import (
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/lib/random"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/jwt"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/keys"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/link"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/refresh"
+	"strconv"
+	"time"
+)
+
+const (
+	SessionIDLength = 24
+)
+
+// Manager issues sessions and their pairs of tokens
+type Manager struct {
+	Access     myjwt.Options
+	RefreshTTL time.Duration
+	Keys       *keys.Ring
+	// RefreshKey is the HMAC key of refresh token hashes and of link tokens
+	RefreshKey []byte
+}
+
+func NewManager(access myjwt.Options, refreshTTL time.Duration, ring *keys.Ring, refreshKey []byte) *Manager {
+	return &Manager{
+		Access:     access,
+		RefreshTTL: refreshTTL,
+		Keys:       ring,
+		RefreshKey: refreshKey,
+	}
+}
+
+// HashRefreshToken returns the hash the session of the refresh token is stored with
+func (m *Manager) HashRefreshToken(token string) []byte {
+	return refresh.Hash(m.RefreshKey, token)
+}
+
+// NewSession creates a session of the user and issues its first pair of tokens
+func (m *Manager) NewSession(user models.User, ip string, userAgent string) (models.Session, models.Token, error) {
+	const op = "internal.lib.tokens.NewSession"
+
+	id, err := random.NewSecret(SessionIDLength)
+	if err != nil {
+		return models.Session{}, models.Token{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	session := models.Session{
+		ID:        id,
+		UID:       user.UID,
+		IP:        ip,
+		UserAgent: userAgent,
+	}
+
+	token, err := m.Rotate(&session, user.Email)
+	if err != nil {
+		return models.Session{}, models.Token{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return session, token, nil
+}
+
+// Rotate issues a new pair of tokens for the session signed with the active key, replaces its
+// refresh hash and prolongs it for RefreshTTL
+func (m *Manager) Rotate(session *models.Session, email string) (models.Token, error) {
+	const op = "internal.lib.tokens.Rotate"
+
+	claims := myjwt.Claims{
+		IP:        session.IP,
+		Email:     email,
+		SessionID: session.ID,
+	}
+	claims.Subject = strconv.FormatInt(session.UID, 10)
+
+	token, err := GenTokens(m.Access, claims, m.Keys.Active(), AccessTokenLength)
+	if err != nil {
+		return models.Token{}, fmt.Errorf("%s: %w", op, err)
+	}
+
+	session.RefreshHash = m.HashRefreshToken(token.RefreshToken)
+	session.ExpiresAt = time.Now().Add(m.RefreshTTL)
+
+	return token, nil
+}
+
+// revokeLinkPurpose binds revoke link tokens to the "this wasn't me" link
+const revokeLinkPurpose = "session revoke link"
+
+// RevokeLinkToken returns the token of the link which revokes the session, valid while the session is
+func (m *Manager) RevokeLinkToken(session models.Session) string {
+	return link.Sign(m.RefreshKey, revokeLinkPurpose, session.ID, session.ExpiresAt)
+}
+
+// VerifyRevokeLinkToken returns the ID of the session the revoke link token was issued for
+func (m *Manager) VerifyRevokeLinkToken(token string) (string, error) {
+	const op = "internal.lib.tokens.VerifyRevokeLinkToken"
+
+	id, err := link.Verify(m.RefreshKey, revokeLinkPurpose, token)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w: %w", op, ErrInvalidToken, err)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,53 @@
+// Package tokenstest provides the token manager and the user every handler test starts with
+package tokenstest
+
+import (
+	"context"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens"
+	myjwt "github.com/northwindman/testREST-autentification/internal/lib/tokens/jwt"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/keys"
+	"github.com/northwindman/testREST-autentification/internal/storage/memory"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+const (
+	// IP the user registered from, httptest requests come from it too
+	IP    = "192.0.2.1"
+	Email = "test@example.com"
+)
+
+// Options of access tokens issued by NewManager
+var Options = myjwt.Options{
+	Issuer:   "test",
+	Audience: "test",
+	TTL:      time.Minute,
+	ClientID: "web",
+}
+
+// NewManager returns a manager of tokens signed by a new ES256 key
+func NewManager(t testing.TB) *tokens.Manager {
+	return NewManagerWith(t, Options)
+}
+
+// NewManagerWith returns a manager of tokens with the options signed by a new ES256 key
+func NewManagerWith(t testing.TB, opts myjwt.Options) *tokens.Manager {
+	key, err := keys.Generate(keys.AlgES256)
+	require.NoError(t, err)
+
+	return tokens.NewManager(opts, time.Hour, keys.NewRing(key), []byte("test refresh key"))
+}
+
+// NewStorage returns a memory storage with the user registered with Email and the password hash
+func NewStorage(t testing.TB, passHash []byte) (*memory.Storage, models.User) {
+	s := memory.New()
+
+	uid, err := s.SaveUser(context.Background(), IP, Email, passHash, "")
+	require.NoError(t, err)
+	user, err := s.GetUserByID(context.Background(), uid)
+	require.NoError(t, err)
+
+	return s, user
+}
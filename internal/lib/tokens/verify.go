@@ -0,0 +1,67 @@
+package tokens
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/lib/tokens/jwt"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidToken    = errors.New("invalid token")
+	ErrTokenRevoked    = errors.New("token revoked")
+	ErrSessionInactive = errors.New("session is revoked or expired")
+)
+
+// SessionChecker provides the state needed to decide if an access token is still active
+type SessionChecker interface {
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	GetSession(ctx context.Context, id string) (models.Session, error)
+}
+
+// Verify parses the access token and checks that neither the token nor its session was revoked
+func (m *Manager) Verify(ctx context.Context, tokenString string, checker SessionChecker) (*myjwt.Claims, error) {
+	const op = "internal.lib.tokens.Verify"
+
+	claims, err := myjwt.ParseToken(tokenString, m.Keys, m.Access)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w: %w", op, ErrInvalidToken, err)
+	}
+
+	revoked, err := checker.IsTokenRevoked(ctx, claims.ID)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if revoked {
+		return nil, fmt.Errorf("%s: %w", op, ErrTokenRevoked)
+	}
+
+	session, err := checker.GetSession(ctx, claims.SessionID)
+	if errors.Is(err, storage.ErrSessionNotFound) {
+		return nil, fmt.Errorf("%s: %w", op, ErrSessionInactive)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+	if session.RevokedAt != nil || time.Now().After(session.ExpiresAt) {
+		return nil, fmt.Errorf("%s: %w", op, ErrSessionInactive)
+	}
+
+	return claims, nil
+}
+
+// IsInactive reports if Verify failed because the token is not active, rather than
+// because of an internal error
+func IsInactive(err error) bool {
+	return errors.Is(err, ErrInvalidToken) || errors.Is(err, ErrTokenRevoked) || errors.Is(err, ErrSessionInactive)
+}
+
+// IsAccessToken reports if the token looks like a JWT access token rather than an opaque refresh token.
+// It tells only the format, the token still has to be verified
+func IsAccessToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
@@ -0,0 +1,62 @@
+// Package useragent describes User-Agent headers for people, e.g. "Chrome on Windows"
+package useragent
+
+import (
+	"strings"
+)
+
+// Unknown is the description of an empty or unrecognized header
+const Unknown = "Unknown device"
+
+// Rules are checked in order, so more specific tokens go first: Edge and Opera also send "Chrome",
+// Chrome also sends "Safari", Android also sends "Linux" and iOS "Mac OS X"
+var (
+	browsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"YaBrowser/", "Yandex Browser"},
+		{"Firefox/", "Firefox"},
+		{"FxiOS/", "Firefox"},
+		{"CriOS/", "Chrome"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+		{"okhttp/", "Android app"},
+	}
+	systems = []struct{ token, name string }{
+		{"Windows", "Windows"},
+		{"Android", "Android"},
+		{"iPhone", "iOS"},
+		{"iPad", "iPadOS"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// Describe returns an approximate, human readable description of the User-Agent header
+func Describe(userAgent string) string {
+	browser := match(userAgent, browsers)
+	system := match(userAgent, systems)
+
+	switch {
+	case browser != "" && system != "":
+		return browser + " on " + system
+	case browser != "":
+		return browser
+	case system != "":
+		return system
+	default:
+		return Unknown
+	}
+}
+
+func match(userAgent string, rules []struct{ token, name string }) string {
+	for _, rule := range rules {
+		if strings.Contains(userAgent, rule.token) {
+			return rule.name
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,628 @@
+package memory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Storage keeps everything in memory, so it is lost on restart. Use it for tests and local development
+type Storage struct {
+	mu sync.RWMutex
+
+	lastUID    int64
+	users      map[int64]models.User
+	uidByEmail map[string]int64
+	sessions   map[string]models.Session
+	// usedHashes are rotated refresh tokens by their hashes
+	usedHashes    map[string]usedToken
+	revokedTokens map[string]time.Time
+	// passwordResets are keyed by token hashes
+	passwordResets map[string]models.PasswordReset
+	// verificationEmails are times of the emails sent to users
+	verificationEmails map[int64][]time.Time
+
+	lastNotificationID int64
+	outbox             map[int64]models.Notification
+}
+
+// usedToken is a rotated refresh token of the session
+type usedToken struct {
+	sessionID string
+	usedAt    time.Time
+}
+
+func New() *Storage {
+	return &Storage{
+		users:              make(map[int64]models.User),
+		uidByEmail:         make(map[string]int64),
+		sessions:           make(map[string]models.Session),
+		usedHashes:         make(map[string]usedToken),
+		revokedTokens:      make(map[string]time.Time),
+		passwordResets:     make(map[string]models.PasswordReset),
+		verificationEmails: make(map[int64][]time.Time),
+		outbox:             make(map[int64]models.Notification),
+	}
+}
+
+// Close does nothing, it is here to satisfy storage.Storage
+func (s *Storage) Close() error {
+	return nil
+}
+
+// SaveUser create new user
+func (s *Storage) SaveUser(ctx context.Context, ip string, email string, passHash []byte, locale string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.uidByEmail[email]; ok {
+		return 0, storage.ErrAlreadyExist
+	}
+
+	s.lastUID++
+	s.users[s.lastUID] = models.User{
+		UID:      s.lastUID,
+		IP:       ip,
+		Email:    email,
+		PassHash: clone(passHash),
+		Locale:   locale,
+	}
+	s.uidByEmail[email] = s.lastUID
+
+	return s.lastUID, nil
+}
+
+// GetUser returns the user's model for the operation by email
+func (s *Storage) GetUser(ctx context.Context, email string) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	uid, ok := s.uidByEmail[email]
+	if !ok {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	return copyUser(s.users[uid]), nil
+}
+
+// GetUserByID returns the user's model by its uid
+func (s *Storage) GetUserByID(ctx context.Context, uid int64) (models.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, ok := s.users[uid]
+	if !ok {
+		return models.User{}, storage.ErrUserNotFound
+	}
+
+	return copyUser(user), nil
+}
+
+// UpdatePassword replaces the password hash of the user
+func (s *Storage) UpdatePassword(ctx context.Context, uid int64, passHash []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[uid]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+
+	user.PassHash = clone(passHash)
+	s.users[uid] = user
+
+	return nil
+}
+
+// VerifyEmail marks the email of the user verified, only if the user still has that email
+func (s *Storage) VerifyEmail(ctx context.Context, uid int64, email string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, ok := s.users[uid]
+	if !ok || user.Email != email {
+		return storage.ErrUserNotFound
+	}
+	if user.EmailVerified {
+		return storage.ErrEmailAlreadyVerified
+	}
+
+	user.EmailVerified = true
+	s.users[uid] = user
+
+	return nil
+}
+
+// SaveSession create new session of the user
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) error {
+	const op = "storage.memory.SaveSession"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[session.UID]; !ok {
+		return fmt.Errorf("%s: unknown user %d: %w", op, session.UID, storage.ErrConstraint)
+	}
+	if _, ok := s.sessions[session.ID]; ok {
+		return fmt.Errorf("%s: session %s exists: %w", op, session.ID, storage.ErrConflict)
+	}
+
+	now := time.Now()
+	session.CreatedAt = now
+	session.LastUsedAt = now
+	session.RevokedAt = nil
+
+	s.sessions[session.ID] = copySession(session)
+
+	return nil
+}
+
+// GetSession returns the session's model by its id
+func (s *Storage) GetSession(ctx context.Context, id string) (models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return models.Session{}, storage.ErrSessionNotFound
+	}
+
+	return copySession(session), nil
+}
+
+// RotateSession saves the session's new refresh token and marks the previous
+// refresh token of the family as used, unless the session was rotated or revoked meanwhile.
+// The notifications are enqueued only with a successful rotation
+func (s *Storage) RotateSession(ctx context.Context, session models.Session, usedHash []byte, outbox ...models.Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.sessions[session.ID]
+	if !ok {
+		return storage.ErrSessionNotFound
+	}
+	if stored.RevokedAt != nil || !bytes.Equal(stored.RefreshHash, usedHash) {
+		return storage.ErrStaleSession
+	}
+
+	stored.RefreshHash = clone(session.RefreshHash)
+	stored.IP = session.IP
+	stored.UserAgent = session.UserAgent
+	stored.ExpiresAt = session.ExpiresAt
+	stored.LastUsedAt = time.Now()
+
+	s.sessions[session.ID] = stored
+	s.usedHashes[string(usedHash)] = usedToken{sessionID: session.ID, usedAt: stored.LastUsedAt}
+
+	for _, notification := range outbox {
+		s.enqueue(notification)
+	}
+
+	return nil
+}
+
+// GetSessionByRefreshHash returns the active session whose current refresh token has the hash
+func (s *Storage) GetSessionByRefreshHash(ctx context.Context, hash []byte) (models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if len(hash) == 0 {
+		return models.Session{}, storage.ErrSessionNotFound
+	}
+
+	for _, session := range s.sessions {
+		if session.RevokedAt == nil && bytes.Equal(session.RefreshHash, hash) {
+			return copySession(session), nil
+		}
+	}
+
+	return models.Session{}, storage.ErrSessionNotFound
+}
+
+// GetSessionByUsedRefreshHash returns the session an already rotated refresh token with the hash belonged to
+// and when the token was rotated
+func (s *Storage) GetSessionByUsedRefreshHash(ctx context.Context, hash []byte) (models.Session, time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	used, ok := s.usedHashes[string(hash)]
+	if !ok {
+		return models.Session{}, time.Time{}, storage.ErrSessionNotFound
+	}
+
+	session, ok := s.sessions[used.sessionID]
+	if !ok {
+		return models.Session{}, time.Time{}, storage.ErrSessionNotFound
+	}
+
+	return copySession(session), used.usedAt, nil
+}
+
+// RevokeSession revokes the session together with every refresh token of its family
+func (s *Storage) RevokeSession(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.RevokedAt != nil {
+		return nil
+	}
+
+	s.sessions[id] = revoke(session, time.Now())
+
+	return nil
+}
+
+// ListSessions returns active sessions of the user, recently used first
+func (s *Storage) ListSessions(ctx context.Context, uid int64) ([]models.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	now := time.Now()
+
+	var sessions []models.Session
+	for _, session := range s.sessions {
+		if session.UID != uid || session.RevokedAt != nil || !session.ExpiresAt.After(now) {
+			continue
+		}
+		sessions = append(sessions, copySession(session))
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastUsedAt.After(sessions[j].LastUsedAt)
+	})
+
+	return sessions, nil
+}
+
+// RevokeAllSessions revokes every session of the user and returns how many were revoked
+func (s *Storage) RevokeAllSessions(ctx context.Context, uid int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	var revoked int64
+	for id, session := range s.sessions {
+		if session.UID != uid || session.RevokedAt != nil {
+			continue
+		}
+		s.sessions[id] = revoke(session, now)
+		revoked++
+	}
+
+	return revoked, nil
+}
+
+// RevokeToken puts the access token's jti to the denylist until the token expires
+func (s *Storage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.revokedTokens[jti]; !ok {
+		s.revokedTokens[jti] = expiresAt
+	}
+
+	// Expired tokens are rejected anyway, so there is no need to keep them
+	now := time.Now()
+	for revokedJTI, expires := range s.revokedTokens {
+		if expires.Before(now) {
+			delete(s.revokedTokens, revokedJTI)
+		}
+	}
+
+	return nil
+}
+
+// IsTokenRevoked reports if the access token's jti is in the denylist
+func (s *Storage) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	_, revoked := s.revokedTokens[jti]
+
+	return revoked, nil
+}
+
+// SavePasswordReset stores the pending reset
+func (s *Storage) SavePasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	const op = "storage.memory.SavePasswordReset"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[reset.UID]; !ok {
+		return fmt.Errorf("%s: unknown user %d: %w", op, reset.UID, storage.ErrConstraint)
+	}
+	if _, ok := s.passwordResets[string(reset.TokenHash)]; ok {
+		return fmt.Errorf("%s: reset exists: %w", op, storage.ErrConflict)
+	}
+
+	reset.TokenHash = clone(reset.TokenHash)
+	reset.CreatedAt = time.Now()
+	s.passwordResets[string(reset.TokenHash)] = reset
+
+	return nil
+}
+
+// CountPasswordResets returns how many resets of the user were created since the time
+func (s *Storage) CountPasswordResets(ctx context.Context, uid int64, since time.Time) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int
+	for _, reset := range s.passwordResets {
+		if reset.UID == uid && !reset.CreatedAt.Before(since) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// SaveVerificationEmail records the verification email sent to the user now
+func (s *Storage) SaveVerificationEmail(ctx context.Context, uid int64) error {
+	const op = "storage.memory.SaveVerificationEmail"
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.users[uid]; !ok {
+		return fmt.Errorf("%s: unknown user %d: %w", op, uid, storage.ErrConstraint)
+	}
+
+	s.verificationEmails[uid] = append(s.verificationEmails[uid], time.Now())
+
+	return nil
+}
+
+// CountVerificationEmails returns how many verification emails were sent to the user since the time
+func (s *Storage) CountVerificationEmails(ctx context.Context, uid int64, since time.Time) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var count int
+	for _, sentAt := range s.verificationEmails[uid] {
+		if !sentAt.Before(since) {
+			count++
+		}
+	}
+
+	return count, nil
+}
+
+// GetPasswordReset returns the unused reset by the hash of its token, it may be expired
+func (s *Storage) GetPasswordReset(ctx context.Context, tokenHash []byte) (models.PasswordReset, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	reset, ok := s.passwordResets[string(tokenHash)]
+	if !ok {
+		return models.PasswordReset{}, storage.ErrPasswordResetNotFound
+	}
+
+	reset.TokenHash = clone(reset.TokenHash)
+
+	return reset, nil
+}
+
+// ResetPassword spends the reset, sets the password and revokes all sessions of the user
+func (s *Storage) ResetPassword(ctx context.Context, tokenHash []byte, passHash []byte, outbox ...models.Notification) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	reset, ok := s.passwordResets[string(tokenHash)]
+	if !ok || !reset.ExpiresAt.After(now) {
+		return storage.ErrPasswordResetNotFound
+	}
+
+	user, ok := s.users[reset.UID]
+	if !ok {
+		return storage.ErrUserNotFound
+	}
+	user.PassHash = clone(passHash)
+	user.EmailVerified = true
+	s.users[reset.UID] = user
+
+	for hash, other := range s.passwordResets {
+		if other.UID == reset.UID {
+			delete(s.passwordResets, hash)
+		}
+	}
+
+	for id, session := range s.sessions {
+		if session.UID == reset.UID && session.RevokedAt == nil {
+			s.sessions[id] = revoke(session, now)
+		}
+	}
+
+	for _, notification := range outbox {
+		s.enqueue(notification)
+	}
+
+	return nil
+}
+
+// EnqueueNotification adds the notification to the outbox and returns its id
+func (s *Storage) EnqueueNotification(ctx context.Context, notification models.Notification) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.enqueue(notification), nil
+}
+
+func (s *Storage) enqueue(notification models.Notification) int64 {
+	s.lastNotificationID++
+
+	now := time.Now()
+	notification.ID = s.lastNotificationID
+	notification.Status = models.NotificationPending
+	notification.Attempts = 0
+	notification.NextAttemptAt = now
+	notification.LastError = ""
+	notification.CreatedAt = now
+
+	s.outbox[notification.ID] = notification
+
+	return notification.ID
+}
+
+// ClaimNotifications returns due pending notifications, the oldest first, and postpones them by the lease
+func (s *Storage) ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]models.Notification, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	var due []models.Notification
+	for _, notification := range s.outbox {
+		if notification.Status == models.NotificationPending && !notification.NextAttemptAt.After(now) {
+			due = append(due, notification)
+		}
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].ID < due[j].ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	for i := range due {
+		due[i].Attempts++
+		due[i].NextAttemptAt = now.Add(lease)
+		s.outbox[due[i].ID] = due[i]
+	}
+
+	return due, nil
+}
+
+// DeleteNotification removes the delivered notification
+func (s *Storage) DeleteNotification(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.outbox[id]; !ok {
+		return storage.ErrNotificationNotFound
+	}
+	delete(s.outbox, id)
+
+	return nil
+}
+
+// RetryNotification schedules the next attempt of the notification
+func (s *Storage) RetryNotification(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notification, ok := s.outbox[id]
+	if !ok {
+		return storage.ErrNotificationNotFound
+	}
+
+	notification.NextAttemptAt = nextAttemptAt
+	notification.LastError = lastError
+	s.outbox[id] = notification
+
+	return nil
+}
+
+// DeadLetterNotification stops delivery of the notification until it is replayed
+func (s *Storage) DeadLetterNotification(ctx context.Context, id int64, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notification, ok := s.outbox[id]
+	if !ok {
+		return storage.ErrNotificationNotFound
+	}
+
+	notification.Status = models.NotificationDead
+	notification.LastError = lastError
+	s.outbox[id] = notification
+
+	return nil
+}
+
+// ListDeadNotifications returns dead notifications, recently created first
+func (s *Storage) ListDeadNotifications(ctx context.Context, limit int) ([]models.Notification, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var dead []models.Notification
+	for _, notification := range s.outbox {
+		if notification.Status == models.NotificationDead {
+			dead = append(dead, notification)
+		}
+	}
+
+	sort.Slice(dead, func(i, j int) bool {
+		return dead[i].ID > dead[j].ID
+	})
+	if len(dead) > limit {
+		dead = dead[:limit]
+	}
+
+	return dead, nil
+}
+
+// ReplayNotification makes the dead notification pending again with fresh attempts
+func (s *Storage) ReplayNotification(ctx context.Context, id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	notification, ok := s.outbox[id]
+	if !ok {
+		return storage.ErrNotificationNotFound
+	}
+	if notification.Status != models.NotificationDead {
+		return storage.ErrNotificationNotDead
+	}
+
+	notification.Status = models.NotificationPending
+	notification.Attempts = 0
+	notification.NextAttemptAt = time.Now()
+	s.outbox[id] = notification
+
+	return nil
+}
+
+func revoke(session models.Session, at time.Time) models.Session {
+	session.RevokedAt = &at
+	session.RefreshHash = []byte{}
+
+	return session
+}
+
+// Stored models are copied in and out, so callers can't change them behind the lock
+
+func copyUser(user models.User) models.User {
+	user.PassHash = clone(user.PassHash)
+
+	return user
+}
+
+func copySession(session models.Session) models.Session {
+	session.RefreshHash = clone(session.RefreshHash)
+	if session.RevokedAt != nil {
+		revokedAt := *session.RevokedAt
+		session.RevokedAt = &revokedAt
+	}
+
+	return session
+}
+
+func clone(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+
+	return append([]byte{}, b...)
+}
@@ -0,0 +1,13 @@
+package memory
+
+import (
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"github.com/northwindman/testREST-autentification/internal/storage/storagetest"
+	"testing"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return New()
+	})
+}
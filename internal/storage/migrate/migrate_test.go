@@ -0,0 +1,68 @@
+package migrate
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoad_Ordered(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0010_add_index.up.sql":   {Data: []byte("CREATE INDEX i ON t(a);")},
+		"0002_create_t.up.sql":    {Data: []byte("CREATE TABLE t (a INT);")},
+		"0002_create_t.down.sql":  {Data: []byte("DROP TABLE t;")},
+		"0010_add_index.down.sql": {Data: []byte("DROP INDEX i;")},
+	}
+
+	migrations, err := Load(fsys)
+	require.NoError(t, err)
+	require.Len(t, migrations, 2)
+
+	assert.Equal(t, int64(2), migrations[0].Version)
+	assert.Equal(t, "create_t", migrations[0].Name)
+	assert.Equal(t, "CREATE TABLE t (a INT);", migrations[0].Up)
+	assert.Equal(t, "DROP TABLE t;", migrations[0].Down)
+	assert.Equal(t, int64(10), migrations[1].Version)
+}
+
+func TestLoad_DownIsOptional(t *testing.T) {
+	fsys := fstest.MapFS{
+		"0001_init.up.sql": {Data: []byte("CREATE TABLE t (a INT);")},
+	}
+
+	migrations, err := Load(fsys)
+	require.NoError(t, err)
+	require.Len(t, migrations, 1)
+	assert.Empty(t, migrations[0].Down)
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{
+			name: "unexpected file",
+			fsys: fstest.MapFS{"init.sql": {Data: []byte("SELECT 1;")}},
+		},
+		{
+			name: "no up script",
+			fsys: fstest.MapFS{"0001_init.down.sql": {Data: []byte("DROP TABLE t;")}},
+		},
+		{
+			name: "different names",
+			fsys: fstest.MapFS{
+				"0001_init.up.sql":    {Data: []byte("CREATE TABLE t (a INT);")},
+				"0001_other.down.sql": {Data: []byte("DROP TABLE t;")},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Load(tt.fsys)
+			assert.ErrorIs(t, err, ErrInvalidMigration)
+		})
+	}
+}
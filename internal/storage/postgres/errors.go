@@ -0,0 +1,48 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"net"
+	"strings"
+)
+
+// wrapError adds the op and the kind of the error (see storage errors), keeping the driver's error for logs
+func wrapError(op string, err error) error {
+	if kind := errorKind(err); kind != nil {
+		return fmt.Errorf("%s: %w: %w", op, kind, err)
+	}
+
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+func errorKind(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		switch {
+		case pgErr.Code == "23505": // unique_violation
+			return storage.ErrConflict
+		case strings.HasPrefix(pgErr.Code, "23"): // integrity constraint violation
+			return storage.ErrConstraint
+		case pgErr.Code == "40001", pgErr.Code == "40P01": // serialization_failure, deadlock_detected
+			return storage.ErrTransient
+		case strings.HasPrefix(pgErr.Code, "08"), // connection exception
+			strings.HasPrefix(pgErr.Code, "53"), // insufficient resources
+			pgErr.Code == "57014",               // query_canceled, e.g. statement_timeout
+			pgErr.Code == "57P01":               // admin_shutdown
+			return storage.ErrTransient
+		}
+
+		return nil
+	}
+
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || pgconn.Timeout(err) || errors.As(err, &netErr) {
+		return storage.ErrTransient
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestWrapError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		kind error
+	}{
+		{"unique violation", &pgconn.PgError{Code: "23505"}, storage.ErrConflict},
+		{"foreign key violation", &pgconn.PgError{Code: "23503"}, storage.ErrConstraint},
+		{"not null violation", &pgconn.PgError{Code: "23502"}, storage.ErrConstraint},
+		{"serialization failure", &pgconn.PgError{Code: "40001"}, storage.ErrTransient},
+		{"connection failure", &pgconn.PgError{Code: "08006"}, storage.ErrTransient},
+		{"statement timeout", &pgconn.PgError{Code: "57014"}, storage.ErrTransient},
+		{"deadline exceeded", fmt.Errorf("query: %w", context.DeadlineExceeded), storage.ErrTransient},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := wrapError("op", tt.err)
+
+			assert.ErrorIs(t, err, tt.kind)
+			assert.ErrorIs(t, err, tt.err)
+		})
+	}
+}
+
+func TestWrapError_Unknown(t *testing.T) {
+	err := wrapError("op", &pgconn.PgError{Code: "42601"}) // syntax_error
+
+	for _, kind := range []error{storage.ErrNotFound, storage.ErrConflict, storage.ErrConstraint, storage.ErrTransient} {
+		assert.False(t, errors.Is(err, kind))
+	}
+}
@@ -0,0 +1,53 @@
+-- Tables may already exist in databases created before versioned migrations,
+-- so the initial schema is idempotent and brings them up to date.
+
+CREATE TABLE IF NOT EXISTS users
+(
+	uid BIGSERIAL PRIMARY KEY,
+	ip TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	pass_hash BYTEA NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_email ON users(email);
+
+-- Tokens and secrets live in sessions now, so drop the old single-session columns
+ALTER TABLE users
+	DROP COLUMN IF EXISTS secret,
+	DROP COLUMN IF EXISTS refresh_token;
+
+CREATE TABLE IF NOT EXISTS sessions
+(
+	id TEXT PRIMARY KEY,
+	uid BIGINT NOT NULL REFERENCES users(uid) ON DELETE CASCADE,
+	refresh_hash BYTEA NOT NULL,
+	ip TEXT NOT NULL,
+	user_agent TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	last_used_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMPTZ NOT NULL,
+	revoked_at TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_sessions_uid ON sessions(uid);
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS revoked_at TIMESTAMPTZ;
+ALTER TABLE sessions DROP COLUMN IF EXISTS secret;
+
+-- Already rotated refresh tokens of the session (token family), kept to detect their reuse
+CREATE TABLE IF NOT EXISTS used_refresh_tokens
+(
+	id BIGSERIAL PRIMARY KEY,
+	session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+	token_hash BYTEA NOT NULL,
+	used_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_used_refresh_tokens_session ON used_refresh_tokens(session_id);
+
+-- Denylist of revoked access tokens, kept until the tokens expire
+CREATE TABLE IF NOT EXISTS revoked_tokens
+(
+	jti TEXT PRIMARY KEY,
+	expires_at TIMESTAMPTZ NOT NULL
+);
@@ -0,0 +1,12 @@
+-- Refresh tokens are looked up by their HMAC-SHA256 now. Sessions of the old tokens
+-- keep bcrypt hashes, which can never match again, so they are revoked
+UPDATE sessions
+SET
+	revoked_at = NOW(),
+	refresh_hash = ''::BYTEA
+WHERE revoked_at IS NULL AND octet_length(refresh_hash) <> 32;
+
+-- Revoked sessions share the empty hash, so only active ones must be unique
+CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_refresh_hash ON sessions(refresh_hash) WHERE revoked_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS idx_used_refresh_tokens_hash ON used_refresh_tokens(token_hash);
@@ -0,0 +1,2 @@
+ALTER TABLE notification_outbox DROP COLUMN IF EXISTS html;
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
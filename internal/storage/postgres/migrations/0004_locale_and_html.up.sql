@@ -0,0 +1,5 @@
+-- Emails are rendered in the user's language, empty means the default one
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale TEXT NOT NULL DEFAULT '';
+
+-- HTML alternative of the plain text body, empty for plain text notifications
+ALTER TABLE notification_outbox ADD COLUMN IF NOT EXISTS html TEXT NOT NULL DEFAULT '';
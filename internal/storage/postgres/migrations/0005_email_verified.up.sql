@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS email_verified BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Users registered before verification existed are trusted, so requiring it doesn't lock them out
+UPDATE users SET email_verified = TRUE;
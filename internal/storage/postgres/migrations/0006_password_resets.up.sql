@@ -0,0 +1,11 @@
+-- Pending password resets, keyed by the hash of the token sent to the user.
+-- A reset is deleted once used, together with the other resets of the user
+CREATE TABLE IF NOT EXISTS password_resets
+(
+	token_hash BYTEA PRIMARY KEY,
+	uid BIGINT NOT NULL REFERENCES users(uid) ON DELETE CASCADE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_password_resets_uid ON password_resets(uid, created_at);
@@ -0,0 +1,8 @@
+-- Verification emails sent on request, only to limit how many a user gets
+CREATE TABLE IF NOT EXISTS verification_emails
+(
+	uid BIGINT NOT NULL REFERENCES users(uid) ON DELETE CASCADE,
+	sent_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_verification_emails_uid ON verification_emails(uid, sent_at);
@@ -1,139 +1,929 @@
 package postgres
 
 import (
-	"database/sql"
+	"context"
+	"embed"
+	"errors"
 	"fmt"
-	_ "github.com/jackc/pgx/v5/stdlib"
-	"github.com/lib/pq"
-	_ "github.com/lib/pq"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/northwindman/testREST-autentification/internal/domain/models"
 	"github.com/northwindman/testREST-autentification/internal/storage"
+	"github.com/northwindman/testREST-autentification/internal/storage/migrate"
+	"io/fs"
+	"sort"
+	"time"
 )
 
+// migrationsLockID is the key of the advisory lock held while migrating
+const migrationsLockID = 7_284_190_511
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Options configure the connection pool. Zero values keep the pgxpool defaults
+type Options struct {
+	MaxConns          int32
+	MinConns          int32
+	MaxConnLifetime   time.Duration
+	MaxConnIdleTime   time.Duration
+	HealthCheckPeriod time.Duration
+	// QueryTimeout limits every query or transaction unless it is zero
+	QueryTimeout time.Duration
+}
+
 type Storage struct {
-	db *sql.DB
+	pool         *pgxpool.Pool
+	queryTimeout time.Duration
 }
 
-func New(storagePath string) (*Storage, error) {
+func New(ctx context.Context, storagePath string, opts Options) (*Storage, error) {
 	// Use this constant for initial place of the error(stack-trace)
 	const op = "storage.postgres.New"
 
-	db, err := sql.Open("postgres", storagePath)
+	cfg, err := pgxpool.ParseConfig(storagePath)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	_, err = db.Exec(`
-	CREATE TABLE IF NOT EXISTS users
-	(
-		uid BIGSERIAL PRIMARY KEY,
-		ip TEXT NOT NULL,
-		email TEXT UNIQUE NOT NULL,
-		pass_hash BYTEA NOT NULL,
-		secret TEXT NOT NULL,
-		refresh_token BYTEA NOT NULL
-	);
-	`)
+	if opts.MaxConns > 0 {
+		cfg.MaxConns = opts.MaxConns
+	}
+	if opts.MinConns > 0 {
+		cfg.MinConns = opts.MinConns
+	}
+	if opts.MaxConnLifetime > 0 {
+		cfg.MaxConnLifetime = opts.MaxConnLifetime
+	}
+	if opts.MaxConnIdleTime > 0 {
+		cfg.MaxConnIdleTime = opts.MaxConnIdleTime
+	}
+	if opts.HealthCheckPeriod > 0 {
+		cfg.HealthCheckPeriod = opts.HealthCheckPeriod
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, cfg)
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	_, err = db.Exec(`
-	CREATE INDEX IF NOT EXISTS idx_email ON users(email)
-	`)
+	if err = pool.Ping(ctx); err != nil {
+		pool.Close()
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Storage{pool: pool, queryTimeout: opts.QueryTimeout}, nil
+}
+
+// Migrator returns the runner of the embedded schema migrations
+func (s *Storage) Migrator() (*migrate.Migrator, error) {
+	const op = "storage.postgres.Migrator"
+
+	fsys, err := fs.Sub(migrations, "migrations")
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	_, err = db.Exec(`
-	CREATE INDEX IF NOT EXISTS idx_secret ON users(secret);
-	`)
+	// The migrator works with database/sql, its connections are still taken from the pool
+	m, err := migrate.New(stdlib.OpenDBFromPool(s.pool), fsys, migrate.WithAdvisoryLock(migrationsLockID))
 	if err != nil {
 		return nil, fmt.Errorf("%s: %w", op, err)
 	}
 
-	return &Storage{db: db}, nil
+	return m, nil
+}
+
+// Close closes the connections to DB
+func (s *Storage) Close() error {
+	s.pool.Close()
+
+	return nil
 }
 
 // SaveUser create new user in DB
-func (s *Storage) SaveUser(ip string, email string, passHash []byte, secret string, refreshToken []byte) (int64, error) {
+func (s *Storage) SaveUser(ctx context.Context, ip string, email string, passHash []byte, locale string) (int64, error) {
 	const op = "storage.postgres.SaveUser"
 
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	query := `
-		INSERT INTO users(ip, email, pass_hash, secret, refresh_token)
-		VALUES ($1, $2, $3, $4, $5)
+		INSERT INTO users(ip, email, pass_hash, locale)
+		VALUES ($1, $2, $3, $4)
 		RETURNING uid;
 	`
 
 	var uid int64
-	err := s.db.QueryRow(query, ip, email, passHash, secret, refreshToken).Scan(&uid)
+	err := s.pool.QueryRow(ctx, query, ip, email, passHash, locale).Scan(&uid)
 	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok {
-			if pqErr.Code == "23505" {
-				return 0, storage.ErrAlreadyExist
-			}
-			fmt.Println(pqErr.Code)
+		if errors.Is(errorKind(err), storage.ErrConflict) {
+			return 0, storage.ErrAlreadyExist
 		}
-		fmt.Println(err.Error())
 
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return 0, wrapError(op, err)
 	}
 
 	return uid, nil
 }
 
-// GetUser returns the user's model for the operation by email and secret
-func (s *Storage) GetUser(email string) (models.User, error) {
+// GetUser returns the user's model for the operation by email
+func (s *Storage) GetUser(ctx context.Context, email string) (models.User, error) {
 	const op = "storage.postgres.GetUser"
 
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
 	query := `
-		SELECT uid, ip, email, pass_hash, secret, refresh_token
+		SELECT uid, ip, email, pass_hash, locale, email_verified
 		FROM users
 		WHERE email = $1;
 	`
 
 	var user models.User
-	err := s.db.QueryRow(query, email).Scan(&user.UID, &user.IP, &user.Email, &user.PassHash, &user.Secret, &user.RefreshToken)
+	err := s.pool.QueryRow(ctx, query, email).Scan(&user.UID, &user.IP, &user.Email, &user.PassHash, &user.Locale, &user.EmailVerified)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, storage.ErrUserNotFound
+		}
+
+		return models.User{}, wrapError(op, err)
+	}
+
+	return user, nil
+}
+
+// GetUserByID returns the user's model by its uid
+func (s *Storage) GetUserByID(ctx context.Context, uid int64) (models.User, error) {
+	const op = "storage.postgres.GetUserByID"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT uid, ip, email, pass_hash, locale, email_verified
+		FROM users
+		WHERE uid = $1;
+	`
+
+	var user models.User
+	err := s.pool.QueryRow(ctx, query, uid).Scan(&user.UID, &user.IP, &user.Email, &user.PassHash, &user.Locale, &user.EmailVerified)
 	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok {
-			if pqErr.Code == "23505" {
-				return models.User{}, storage.ErrNotFound
-			}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.User{}, storage.ErrUserNotFound
 		}
 
-		return models.User{}, fmt.Errorf("%s: %w", op, err)
+		return models.User{}, wrapError(op, err)
 	}
 
 	return user, nil
 }
 
-// UpdateUser updates the user's data , namely the refresh token and secret
-func (s *Storage) UpdateUser(email string, ip string, secret string, refreshToken []byte) (int64, error) {
-	const op = "storage.postgres.UpdateUser"
+// UpdatePassword replaces the password hash of the user
+func (s *Storage) UpdatePassword(ctx context.Context, uid int64, passHash []byte) error {
+	const op = "storage.postgres.UpdatePassword"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
 
-	// Вообще, по поводу обновления IP я не уверен, но всё зависит от логики приложения
 	query := `
 		UPDATE users
+		SET pass_hash = $1
+		WHERE uid = $2;
+	`
+
+	tag, err := s.pool.Exec(ctx, query, passHash, uid)
+	if err != nil {
+		return wrapError(op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the email of the user verified, only if the user still has that email
+func (s *Storage) VerifyEmail(ctx context.Context, uid int64, email string) error {
+	const op = "storage.postgres.VerifyEmail"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET email_verified = TRUE
+		WHERE uid = $1 AND email = $2 AND NOT email_verified;
+	`
+
+	tag, err := s.pool.Exec(ctx, query, uid, email)
+	if err != nil {
+		return wrapError(op, err)
+	}
+	if tag.RowsAffected() > 0 {
+		return nil
+	}
+
+	// Nothing updated: either there is no such user or the email is verified already
+	var verified bool
+	err = s.pool.QueryRow(ctx, `SELECT email_verified FROM users WHERE uid = $1 AND email = $2;`, uid, email).Scan(&verified)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.ErrUserNotFound
+		}
+
+		return wrapError(op, err)
+	}
+
+	return storage.ErrEmailAlreadyVerified
+}
+
+// SaveSession create new session of the user in DB
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) error {
+	const op = "storage.postgres.SaveSession"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO sessions(id, uid, refresh_hash, ip, user_agent, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6);
+	`
+
+	_, err := s.pool.Exec(ctx, query,
+		session.ID, session.UID, session.RefreshHash, session.IP, session.UserAgent, session.ExpiresAt,
+	)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	return nil
+}
+
+// GetSession returns the session's model by its id
+func (s *Storage) GetSession(ctx context.Context, id string) (models.Session, error) {
+	const op = "storage.postgres.GetSession"
+
+	query := `
+		SELECT id, uid, refresh_hash, ip, user_agent, created_at, last_used_at, expires_at, revoked_at
+		FROM sessions
+		WHERE id = $1;
+	`
+
+	return s.querySession(ctx, op, query, id)
+}
+
+// GetSessionByRefreshHash returns the active session whose current refresh token has the hash
+func (s *Storage) GetSessionByRefreshHash(ctx context.Context, hash []byte) (models.Session, error) {
+	const op = "storage.postgres.GetSessionByRefreshHash"
+
+	query := `
+		SELECT id, uid, refresh_hash, ip, user_agent, created_at, last_used_at, expires_at, revoked_at
+		FROM sessions
+		WHERE refresh_hash = $1 AND revoked_at IS NULL;
+	`
+
+	return s.querySession(ctx, op, query, hash)
+}
+
+// GetSessionByUsedRefreshHash returns the session an already rotated refresh token with the hash belonged to
+// and when the token was rotated
+func (s *Storage) GetSessionByUsedRefreshHash(ctx context.Context, hash []byte) (models.Session, time.Time, error) {
+	const op = "storage.postgres.GetSessionByUsedRefreshHash"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT s.id, s.uid, s.refresh_hash, s.ip, s.user_agent, s.created_at, s.last_used_at, s.expires_at, s.revoked_at, u.used_at
+		FROM used_refresh_tokens u
+		JOIN sessions s ON s.id = u.session_id
+		WHERE u.token_hash = $1
+		LIMIT 1;
+	`
+
+	var (
+		session models.Session
+		usedAt  time.Time
+	)
+	err := s.pool.QueryRow(ctx, query, hash).Scan(
+		&session.ID, &session.UID, &session.RefreshHash, &session.IP, &session.UserAgent,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.RevokedAt, &usedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Session{}, time.Time{}, storage.ErrSessionNotFound
+		}
+
+		return models.Session{}, time.Time{}, wrapError(op, err)
+	}
+
+	return session, usedAt, nil
+}
+
+// querySession scans the single session selected by the query
+func (s *Storage) querySession(ctx context.Context, op string, query string, args ...any) (models.Session, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var session models.Session
+	err := s.pool.QueryRow(ctx, query, args...).Scan(
+		&session.ID, &session.UID, &session.RefreshHash, &session.IP, &session.UserAgent,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.RevokedAt,
+	)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.Session{}, storage.ErrSessionNotFound
+		}
+
+		return models.Session{}, wrapError(op, err)
+	}
+
+	return session, nil
+}
+
+// RotateSession saves the session's new refresh token and marks the previous
+// refresh token of the family as used. The update is guarded by the previous hash,
+// so only one of concurrent rotations of the same token succeeds. The notifications
+// are enqueued in the same transaction
+func (s *Storage) RotateSession(ctx context.Context, session models.Session, usedHash []byte, outbox ...models.Notification) error {
+	const op = "storage.postgres.RotateSession"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return wrapError(op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	query := `
+		UPDATE sessions
 		SET
-			ip = $1,
-			secret = $2,
-			refresh_token = $3
+			refresh_hash = $1,
+			ip = $2,
+			user_agent = $3,
+			expires_at = $4,
+			last_used_at = NOW()
 		WHERE
-			email = $4
-		RETURNING uid;
+			id = $5 AND refresh_hash = $6 AND revoked_at IS NULL;
+	`
+
+	tag, err := tx.Exec(ctx, query,
+		session.RefreshHash, session.IP, session.UserAgent, session.ExpiresAt, session.ID, usedHash,
+	)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		var exists bool
+		err = tx.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM sessions WHERE id = $1);`, session.ID).Scan(&exists)
+		if err != nil {
+			return wrapError(op, err)
+		}
+		if !exists {
+			return storage.ErrSessionNotFound
+		}
+
+		return storage.ErrStaleSession
+	}
+
+	query = `
+		INSERT INTO used_refresh_tokens(session_id, token_hash)
+		VALUES ($1, $2);
+	`
+
+	if _, err = tx.Exec(ctx, query, session.ID, usedHash); err != nil {
+		return wrapError(op, err)
+	}
+
+	for _, notification := range outbox {
+		if _, err = enqueue(ctx, tx, notification); err != nil {
+			return wrapError(op, err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return wrapError(op, err)
+	}
+
+	return nil
+}
+
+// RevokeSession revokes the session together with every refresh token of its family
+func (s *Storage) RevokeSession(ctx context.Context, id string) error {
+	const op = "storage.postgres.RevokeSession"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE sessions
+		SET
+			revoked_at = NOW(),
+			refresh_hash = ''::BYTEA
+		WHERE id = $1 AND revoked_at IS NULL;
+	`
+
+	if _, err := s.pool.Exec(ctx, query, id); err != nil {
+		return wrapError(op, err)
+	}
+
+	return nil
+}
+
+// ListSessions returns active sessions of the user, recently used first
+func (s *Storage) ListSessions(ctx context.Context, uid int64) ([]models.Session, error) {
+	const op = "storage.postgres.ListSessions"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, uid, refresh_hash, ip, user_agent, created_at, last_used_at, expires_at, revoked_at
+		FROM sessions
+		WHERE uid = $1 AND revoked_at IS NULL AND expires_at > NOW()
+		ORDER BY last_used_at DESC;
+	`
+
+	rows, err := s.pool.Query(ctx, query, uid)
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		err = rows.Scan(
+			&session.ID, &session.UID, &session.RefreshHash, &session.IP, &session.UserAgent,
+			&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.RevokedAt,
+		)
+		if err != nil {
+			return nil, wrapError(op, err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeAllSessions revokes every session of the user and returns how many were revoked
+func (s *Storage) RevokeAllSessions(ctx context.Context, uid int64) (int64, error) {
+	const op = "storage.postgres.RevokeAllSessions"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE sessions
+		SET
+			revoked_at = NOW(),
+			refresh_hash = ''::BYTEA
+		WHERE uid = $1 AND revoked_at IS NULL;
+	`
+
+	tag, err := s.pool.Exec(ctx, query, uid)
+	if err != nil {
+		return 0, wrapError(op, err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// RevokeToken puts the access token's jti to the denylist until the token expires
+func (s *Storage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	const op = "storage.postgres.RevokeToken"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO revoked_tokens(jti, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING;
+	`
+
+	if _, err := s.pool.Exec(ctx, query, jti, expiresAt); err != nil {
+		return wrapError(op, err)
+	}
+
+	// Expired tokens are rejected anyway, so there is no need to keep them
+	query = `
+		DELETE FROM revoked_tokens
+		WHERE expires_at < NOW();
 	`
 
+	if _, err := s.pool.Exec(ctx, query); err != nil {
+		return wrapError(op, err)
+	}
+
+	return nil
+}
+
+// IsTokenRevoked reports if the access token's jti is in the denylist
+func (s *Storage) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	const op = "storage.postgres.IsTokenRevoked"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = $1);
+	`
+
+	var revoked bool
+	if err := s.pool.QueryRow(ctx, query, jti).Scan(&revoked); err != nil {
+		return false, wrapError(op, err)
+	}
+
+	return revoked, nil
+}
+
+// SavePasswordReset stores the pending reset
+func (s *Storage) SavePasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	const op = "storage.postgres.SavePasswordReset"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO password_resets(token_hash, uid, expires_at)
+		VALUES ($1, $2, $3);
+	`
+
+	if _, err := s.pool.Exec(ctx, query, reset.TokenHash, reset.UID, reset.ExpiresAt); err != nil {
+		return wrapError(op, err)
+	}
+
+	return nil
+}
+
+// CountPasswordResets returns how many resets of the user were created since the time
+func (s *Storage) CountPasswordResets(ctx context.Context, uid int64, since time.Time) (int, error) {
+	const op = "storage.postgres.CountPasswordResets"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT COUNT(*)
+		FROM password_resets
+		WHERE uid = $1 AND created_at >= $2;
+	`
+
+	var count int
+	if err := s.pool.QueryRow(ctx, query, uid, since).Scan(&count); err != nil {
+		return 0, wrapError(op, err)
+	}
+
+	return count, nil
+}
+
+// SaveVerificationEmail records the verification email sent to the user now
+func (s *Storage) SaveVerificationEmail(ctx context.Context, uid int64) error {
+	const op = "storage.postgres.SaveVerificationEmail"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO verification_emails(uid)
+		VALUES ($1);
+	`
+
+	if _, err := s.pool.Exec(ctx, query, uid); err != nil {
+		return wrapError(op, err)
+	}
+
+	return nil
+}
+
+// CountVerificationEmails returns how many verification emails were sent to the user since the time
+func (s *Storage) CountVerificationEmails(ctx context.Context, uid int64, since time.Time) (int, error) {
+	const op = "storage.postgres.CountVerificationEmails"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT COUNT(*)
+		FROM verification_emails
+		WHERE uid = $1 AND sent_at >= $2;
+	`
+
+	var count int
+	if err := s.pool.QueryRow(ctx, query, uid, since).Scan(&count); err != nil {
+		return 0, wrapError(op, err)
+	}
+
+	return count, nil
+}
+
+// GetPasswordReset returns the unused reset by the hash of its token, it may be expired
+func (s *Storage) GetPasswordReset(ctx context.Context, tokenHash []byte) (models.PasswordReset, error) {
+	const op = "storage.postgres.GetPasswordReset"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT token_hash, uid, created_at, expires_at
+		FROM password_resets
+		WHERE token_hash = $1;
+	`
+
+	var reset models.PasswordReset
+	err := s.pool.QueryRow(ctx, query, tokenHash).Scan(&reset.TokenHash, &reset.UID, &reset.CreatedAt, &reset.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return models.PasswordReset{}, storage.ErrPasswordResetNotFound
+		}
+
+		return models.PasswordReset{}, wrapError(op, err)
+	}
+
+	return reset, nil
+}
+
+// ResetPassword spends the reset, sets the password and revokes all sessions of the user in one transaction
+func (s *Storage) ResetPassword(ctx context.Context, tokenHash []byte, passHash []byte, outbox ...models.Notification) error {
+	const op = "storage.postgres.ResetPassword"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return wrapError(op, err)
+	}
+	defer func() { _ = tx.Rollback(ctx) }()
+
+	// Of concurrent resets with the same token only the one which deletes it goes on
 	var uid int64
-	err := s.db.QueryRow(query, ip, secret, refreshToken, email).Scan(&uid)
+	query := `
+		DELETE FROM password_resets
+		WHERE token_hash = $1 AND expires_at > NOW()
+		RETURNING uid;
+	`
+
+	err = tx.QueryRow(ctx, query, tokenHash).Scan(&uid)
 	if err != nil {
-		if pqErr, ok := err.(*pq.Error); ok {
-			if pqErr.Code == "23505" {
-				return 0, storage.ErrNotFound
-			}
+		if errors.Is(err, pgx.ErrNoRows) {
+			return storage.ErrPasswordResetNotFound
 		}
 
-		return 0, fmt.Errorf("%s: %w", op, err)
+		return wrapError(op, err)
 	}
 
-	return uid, nil
+	queries := []struct {
+		query string
+		args  []any
+	}{
+		{`UPDATE users SET pass_hash = $1, email_verified = TRUE WHERE uid = $2;`, []any{passHash, uid}},
+		{`DELETE FROM password_resets WHERE uid = $1;`, []any{uid}},
+		{`UPDATE sessions SET revoked_at = NOW(), refresh_hash = ''::BYTEA WHERE uid = $1 AND revoked_at IS NULL;`, []any{uid}},
+	}
+
+	for _, q := range queries {
+		if _, err = tx.Exec(ctx, q.query, q.args...); err != nil {
+			return wrapError(op, err)
+		}
+	}
+
+	for _, notification := range outbox {
+		if _, err = enqueue(ctx, tx, notification); err != nil {
+			return wrapError(op, err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return wrapError(op, err)
+	}
+
+	return nil
+}
+
+// EnqueueNotification adds the notification to the outbox and returns its id
+func (s *Storage) EnqueueNotification(ctx context.Context, notification models.Notification) (int64, error) {
+	const op = "storage.postgres.EnqueueNotification"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	id, err := enqueue(ctx, s.pool, notification)
+	if err != nil {
+		return 0, wrapError(op, err)
+	}
+
+	return id, nil
+}
+
+// querier is either the pool or a transaction
+type querier interface {
+	QueryRow(ctx context.Context, query string, args ...any) pgx.Row
+}
+
+func enqueue(ctx context.Context, db querier, notification models.Notification) (int64, error) {
+	query := `
+		INSERT INTO notification_outbox(recipient, subject, body, html)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id;
+	`
+
+	var id int64
+	err := db.QueryRow(ctx, query, notification.To, notification.Subject, notification.Body, notification.HTML).Scan(&id)
+
+	return id, err
+}
+
+// ClaimNotifications returns due pending notifications, the oldest first, and postpones them by the lease.
+// Rows locked by a concurrent claim, e.g. of another instance, are skipped
+func (s *Storage) ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]models.Notification, error) {
+	const op = "storage.postgres.ClaimNotifications"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		WITH due AS (
+			SELECT id
+			FROM notification_outbox
+			WHERE status = $1 AND next_attempt_at <= NOW()
+			ORDER BY id
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		UPDATE notification_outbox o
+		SET
+			attempts = o.attempts + 1,
+			next_attempt_at = NOW() + $3::INTERVAL
+		FROM due
+		WHERE o.id = due.id
+		RETURNING o.id, o.recipient, o.subject, o.body, o.html, o.status, o.attempts, o.next_attempt_at, o.last_error, o.created_at;
+	`
+
+	notifications, err := s.queryNotifications(ctx, query, models.NotificationPending, limit, lease)
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	// RETURNING gives no order
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].ID < notifications[j].ID
+	})
+
+	return notifications, nil
+}
+
+// DeleteNotification removes the delivered notification
+func (s *Storage) DeleteNotification(ctx context.Context, id int64) error {
+	const op = "storage.postgres.DeleteNotification"
+
+	query := `
+		DELETE FROM notification_outbox
+		WHERE id = $1;
+	`
+
+	return s.updateNotification(ctx, op, query, id)
+}
+
+// RetryNotification schedules the next attempt of the notification
+func (s *Storage) RetryNotification(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error {
+	const op = "storage.postgres.RetryNotification"
+
+	query := `
+		UPDATE notification_outbox
+		SET
+			next_attempt_at = $1,
+			last_error = $2
+		WHERE id = $3;
+	`
+
+	return s.updateNotification(ctx, op, query, nextAttemptAt, lastError, id)
+}
+
+// DeadLetterNotification stops delivery of the notification until it is replayed
+func (s *Storage) DeadLetterNotification(ctx context.Context, id int64, lastError string) error {
+	const op = "storage.postgres.DeadLetterNotification"
+
+	query := `
+		UPDATE notification_outbox
+		SET
+			status = $1,
+			last_error = $2
+		WHERE id = $3;
+	`
+
+	return s.updateNotification(ctx, op, query, models.NotificationDead, lastError, id)
+}
+
+// ListDeadNotifications returns dead notifications, recently created first
+func (s *Storage) ListDeadNotifications(ctx context.Context, limit int) ([]models.Notification, error) {
+	const op = "storage.postgres.ListDeadNotifications"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, recipient, subject, body, html, status, attempts, next_attempt_at, last_error, created_at
+		FROM notification_outbox
+		WHERE status = $1
+		ORDER BY id DESC
+		LIMIT $2;
+	`
+
+	notifications, err := s.queryNotifications(ctx, query, models.NotificationDead, limit)
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	return notifications, nil
+}
+
+// ReplayNotification makes the dead notification pending again with fresh attempts
+func (s *Storage) ReplayNotification(ctx context.Context, id int64) error {
+	const op = "storage.postgres.ReplayNotification"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE notification_outbox
+		SET
+			status = $1,
+			attempts = 0,
+			next_attempt_at = NOW()
+		WHERE id = $2 AND status = $3;
+	`
+
+	tag, err := s.pool.Exec(ctx, query, models.NotificationPending, id, models.NotificationDead)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	if tag.RowsAffected() == 0 {
+		var exists bool
+		err = s.pool.QueryRow(ctx, `SELECT EXISTS(SELECT 1 FROM notification_outbox WHERE id = $1);`, id).Scan(&exists)
+		if err != nil {
+			return wrapError(op, err)
+		}
+		if !exists {
+			return storage.ErrNotificationNotFound
+		}
+
+		return storage.ErrNotificationNotDead
+	}
+
+	return nil
+}
+
+// updateNotification runs the query changing the single notification
+func (s *Storage) updateNotification(ctx context.Context, op string, query string, args ...any) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tag, err := s.pool.Exec(ctx, query, args...)
+	if err != nil {
+		return wrapError(op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return storage.ErrNotificationNotFound
+	}
+
+	return nil
+}
+
+func (s *Storage) queryNotifications(ctx context.Context, query string, args ...any) ([]models.Notification, error) {
+	rows, err := s.pool.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []models.Notification
+	for rows.Next() {
+		var n models.Notification
+		err = rows.Scan(&n.ID, &n.To, &n.Subject, &n.Body, &n.HTML, &n.Status, &n.Attempts, &n.NextAttemptAt, &n.LastError, &n.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	return notifications, rows.Err()
+}
+
+// withTimeout limits a single query or transaction by the storage's query timeout
+func (s *Storage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, s.queryTimeout)
 }
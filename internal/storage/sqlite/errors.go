@@ -0,0 +1,42 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// wrapError adds the op and the kind of the error (see storage errors), keeping the driver's error for logs
+func wrapError(op string, err error) error {
+	if kind := errorKind(err); kind != nil {
+		return fmt.Errorf("%s: %w: %w", op, kind, err)
+	}
+
+	return fmt.Errorf("%s: %w", op, err)
+}
+
+func errorKind(err error) error {
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		// Extended codes keep the primary code in the lowest byte
+		switch code := sqliteErr.Code(); {
+		case code == sqlite3.SQLITE_CONSTRAINT_UNIQUE, code == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY:
+			return storage.ErrConflict
+		case code&0xff == sqlite3.SQLITE_CONSTRAINT:
+			return storage.ErrConstraint
+		case code&0xff == sqlite3.SQLITE_BUSY, code&0xff == sqlite3.SQLITE_LOCKED:
+			return storage.ErrTransient
+		}
+
+		return nil
+	}
+
+	if errors.Is(err, context.DeadlineExceeded) {
+		return storage.ErrTransient
+	}
+
+	return nil
+}
@@ -0,0 +1,40 @@
+CREATE TABLE users
+(
+	uid INTEGER PRIMARY KEY AUTOINCREMENT,
+	ip TEXT NOT NULL,
+	email TEXT UNIQUE NOT NULL,
+	pass_hash BLOB NOT NULL
+);
+
+CREATE TABLE sessions
+(
+	id TEXT PRIMARY KEY,
+	uid INTEGER NOT NULL REFERENCES users(uid) ON DELETE CASCADE,
+	refresh_hash BLOB NOT NULL,
+	ip TEXT NOT NULL,
+	user_agent TEXT NOT NULL,
+	created_at TIMESTAMP NOT NULL,
+	last_used_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL,
+	revoked_at TIMESTAMP
+);
+
+CREATE INDEX idx_sessions_uid ON sessions(uid);
+
+-- Already rotated refresh tokens of the session (token family), kept to detect their reuse
+CREATE TABLE used_refresh_tokens
+(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	session_id TEXT NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+	token_hash BLOB NOT NULL,
+	used_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_used_refresh_tokens_session ON used_refresh_tokens(session_id);
+
+-- Denylist of revoked access tokens, kept until the tokens expire
+CREATE TABLE revoked_tokens
+(
+	jti TEXT PRIMARY KEY,
+	expires_at TIMESTAMP NOT NULL
+);
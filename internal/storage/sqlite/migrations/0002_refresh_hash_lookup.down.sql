@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_used_refresh_tokens_hash;
+DROP INDEX IF EXISTS idx_sessions_refresh_hash;
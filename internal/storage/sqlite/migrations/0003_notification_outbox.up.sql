@@ -0,0 +1,16 @@
+-- Notifications waiting for delivery. They are written in the transaction of the change
+-- they are about and delivered by the outbox workers, dead ones are kept for replay
+CREATE TABLE notification_outbox
+(
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	recipient TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	body TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending',
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at TIMESTAMP NOT NULL,
+	last_error TEXT NOT NULL DEFAULT '',
+	created_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_notification_outbox_due ON notification_outbox(status, next_attempt_at);
@@ -0,0 +1,2 @@
+ALTER TABLE notification_outbox DROP COLUMN html;
+ALTER TABLE users DROP COLUMN locale;
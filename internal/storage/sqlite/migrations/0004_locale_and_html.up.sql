@@ -0,0 +1,5 @@
+-- Emails are rendered in the user's language, empty means the default one
+ALTER TABLE users ADD COLUMN locale TEXT NOT NULL DEFAULT '';
+
+-- HTML alternative of the plain text body, empty for plain text notifications
+ALTER TABLE notification_outbox ADD COLUMN html TEXT NOT NULL DEFAULT '';
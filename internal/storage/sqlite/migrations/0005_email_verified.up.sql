@@ -0,0 +1,4 @@
+ALTER TABLE users ADD COLUMN email_verified INTEGER NOT NULL DEFAULT 0;
+
+-- Users registered before verification existed are trusted, so requiring it doesn't lock them out
+UPDATE users SET email_verified = 1;
@@ -0,0 +1,11 @@
+-- Pending password resets, keyed by the hash of the token sent to the user.
+-- A reset is deleted once used, together with the other resets of the user
+CREATE TABLE password_resets
+(
+	token_hash BLOB PRIMARY KEY,
+	uid INTEGER NOT NULL REFERENCES users(uid) ON DELETE CASCADE,
+	created_at TIMESTAMP NOT NULL,
+	expires_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_password_resets_uid ON password_resets(uid, created_at);
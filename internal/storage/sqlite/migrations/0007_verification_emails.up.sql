@@ -0,0 +1,8 @@
+-- Verification emails sent on request, only to limit how many a user gets
+CREATE TABLE verification_emails
+(
+	uid INTEGER NOT NULL REFERENCES users(uid) ON DELETE CASCADE,
+	sent_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_verification_emails_uid ON verification_emails(uid, sent_at);
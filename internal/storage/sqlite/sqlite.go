@@ -0,0 +1,934 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"github.com/northwindman/testREST-autentification/internal/storage/migrate"
+	"io/fs"
+	_ "modernc.org/sqlite"
+	"sort"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrations embed.FS
+
+// Storage keeps data in a single SQLite file. Times are written in UTC by the storage itself,
+// so they compare correctly as text
+type Storage struct {
+	db           *sql.DB
+	queryTimeout time.Duration
+}
+
+// New opens the database file, queryTimeout limits every query unless it is zero
+func New(storagePath string, queryTimeout time.Duration) (*Storage, error) {
+	// Use this constant for initial place of the error(stack-trace)
+	const op = "storage.sqlite.New"
+
+	sep := "?"
+	if strings.Contains(storagePath, "?") {
+		sep = "&"
+	}
+	dsn := storagePath + sep + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	if err = db.Ping(); err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return &Storage{db: db, queryTimeout: queryTimeout}, nil
+}
+
+// Migrator returns the runner of the embedded schema migrations
+func (s *Storage) Migrator() (*migrate.Migrator, error) {
+	const op = "storage.sqlite.Migrator"
+
+	fsys, err := fs.Sub(migrations, "migrations")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	m, err := migrate.New(s.db, fsys)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", op, err)
+	}
+
+	return m, nil
+}
+
+// Close closes the connections to DB
+func (s *Storage) Close() error {
+	return s.db.Close()
+}
+
+// SaveUser create new user in DB
+func (s *Storage) SaveUser(ctx context.Context, ip string, email string, passHash []byte, locale string) (int64, error) {
+	const op = "storage.sqlite.SaveUser"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO users(ip, email, pass_hash, locale)
+		VALUES ($1, $2, $3, $4)
+		RETURNING uid;
+	`
+
+	var uid int64
+	err := s.db.QueryRowContext(ctx, query, ip, email, passHash, locale).Scan(&uid)
+	if err != nil {
+		if errors.Is(errorKind(err), storage.ErrConflict) {
+			return 0, storage.ErrAlreadyExist
+		}
+
+		return 0, wrapError(op, err)
+	}
+
+	return uid, nil
+}
+
+// GetUser returns the user's model for the operation by email
+func (s *Storage) GetUser(ctx context.Context, email string) (models.User, error) {
+	const op = "storage.sqlite.GetUser"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT uid, ip, email, pass_hash, locale, email_verified
+		FROM users
+		WHERE email = $1;
+	`
+
+	var user models.User
+	err := s.db.QueryRowContext(ctx, query, email).Scan(&user.UID, &user.IP, &user.Email, &user.PassHash, &user.Locale, &user.EmailVerified)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, storage.ErrUserNotFound
+		}
+
+		return models.User{}, wrapError(op, err)
+	}
+
+	return user, nil
+}
+
+// GetUserByID returns the user's model by its uid
+func (s *Storage) GetUserByID(ctx context.Context, uid int64) (models.User, error) {
+	const op = "storage.sqlite.GetUserByID"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT uid, ip, email, pass_hash, locale, email_verified
+		FROM users
+		WHERE uid = $1;
+	`
+
+	var user models.User
+	err := s.db.QueryRowContext(ctx, query, uid).Scan(&user.UID, &user.IP, &user.Email, &user.PassHash, &user.Locale, &user.EmailVerified)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.User{}, storage.ErrUserNotFound
+		}
+
+		return models.User{}, wrapError(op, err)
+	}
+
+	return user, nil
+}
+
+// UpdatePassword replaces the password hash of the user
+func (s *Storage) UpdatePassword(ctx context.Context, uid int64, passHash []byte) error {
+	const op = "storage.sqlite.UpdatePassword"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET pass_hash = $1
+		WHERE uid = $2;
+	`
+
+	res, err := s.db.ExecContext(ctx, query, passHash, uid)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return wrapError(op, err)
+	}
+	if affected == 0 {
+		return storage.ErrUserNotFound
+	}
+
+	return nil
+}
+
+// VerifyEmail marks the email of the user verified, only if the user still has that email
+func (s *Storage) VerifyEmail(ctx context.Context, uid int64, email string) error {
+	const op = "storage.sqlite.VerifyEmail"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE users
+		SET email_verified = 1
+		WHERE uid = $1 AND email = $2 AND email_verified = 0;
+	`
+
+	res, err := s.db.ExecContext(ctx, query, uid, email)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return wrapError(op, err)
+	}
+	if affected > 0 {
+		return nil
+	}
+
+	// Nothing updated: either there is no such user or the email is verified already
+	var verified bool
+	err = s.db.QueryRowContext(ctx, `SELECT email_verified FROM users WHERE uid = $1 AND email = $2;`, uid, email).Scan(&verified)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrUserNotFound
+		}
+
+		return wrapError(op, err)
+	}
+
+	return storage.ErrEmailAlreadyVerified
+}
+
+// SaveSession create new session of the user in DB
+func (s *Storage) SaveSession(ctx context.Context, session models.Session) error {
+	const op = "storage.sqlite.SaveSession"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO sessions(id, uid, refresh_hash, ip, user_agent, created_at, last_used_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $6, $7);
+	`
+
+	_, err := s.db.ExecContext(ctx, query,
+		session.ID, session.UID, session.RefreshHash, session.IP, session.UserAgent, now(), session.ExpiresAt.UTC(),
+	)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	return nil
+}
+
+// GetSession returns the session's model by its id
+func (s *Storage) GetSession(ctx context.Context, id string) (models.Session, error) {
+	const op = "storage.sqlite.GetSession"
+
+	query := `
+		SELECT id, uid, refresh_hash, ip, user_agent, created_at, last_used_at, expires_at, revoked_at
+		FROM sessions
+		WHERE id = $1;
+	`
+
+	return s.querySession(ctx, op, query, id)
+}
+
+// GetSessionByRefreshHash returns the active session whose current refresh token has the hash
+func (s *Storage) GetSessionByRefreshHash(ctx context.Context, hash []byte) (models.Session, error) {
+	const op = "storage.sqlite.GetSessionByRefreshHash"
+
+	query := `
+		SELECT id, uid, refresh_hash, ip, user_agent, created_at, last_used_at, expires_at, revoked_at
+		FROM sessions
+		WHERE refresh_hash = $1 AND revoked_at IS NULL;
+	`
+
+	return s.querySession(ctx, op, query, hash)
+}
+
+// GetSessionByUsedRefreshHash returns the session an already rotated refresh token with the hash belonged to
+// and when the token was rotated
+func (s *Storage) GetSessionByUsedRefreshHash(ctx context.Context, hash []byte) (models.Session, time.Time, error) {
+	const op = "storage.sqlite.GetSessionByUsedRefreshHash"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT s.id, s.uid, s.refresh_hash, s.ip, s.user_agent, s.created_at, s.last_used_at, s.expires_at, s.revoked_at, u.used_at
+		FROM used_refresh_tokens u
+		JOIN sessions s ON s.id = u.session_id
+		WHERE u.token_hash = $1
+		LIMIT 1;
+	`
+
+	var (
+		session models.Session
+		usedAt  time.Time
+	)
+	err := s.db.QueryRowContext(ctx, query, hash).Scan(
+		&session.ID, &session.UID, &session.RefreshHash, &session.IP, &session.UserAgent,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.RevokedAt, &usedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Session{}, time.Time{}, storage.ErrSessionNotFound
+		}
+
+		return models.Session{}, time.Time{}, wrapError(op, err)
+	}
+
+	return session, usedAt, nil
+}
+
+// querySession scans the single session selected by the query
+func (s *Storage) querySession(ctx context.Context, op string, query string, args ...any) (models.Session, error) {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	var session models.Session
+	err := s.db.QueryRowContext(ctx, query, args...).Scan(
+		&session.ID, &session.UID, &session.RefreshHash, &session.IP, &session.UserAgent,
+		&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.RevokedAt,
+	)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.Session{}, storage.ErrSessionNotFound
+		}
+
+		return models.Session{}, wrapError(op, err)
+	}
+
+	return session, nil
+}
+
+// RotateSession saves the session's new refresh token and marks the previous
+// refresh token of the family as used. The update is guarded by the previous hash,
+// so only one of concurrent rotations of the same token succeeds. The notifications
+// are enqueued in the same transaction
+func (s *Storage) RotateSession(ctx context.Context, session models.Session, usedHash []byte, outbox ...models.Notification) error {
+	const op = "storage.sqlite.RotateSession"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapError(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	usedAt := now()
+
+	query := `
+		UPDATE sessions
+		SET
+			refresh_hash = $1,
+			ip = $2,
+			user_agent = $3,
+			expires_at = $4,
+			last_used_at = $5
+		WHERE
+			id = $6 AND refresh_hash = $7 AND revoked_at IS NULL;
+	`
+
+	res, err := tx.ExecContext(ctx, query,
+		session.RefreshHash, session.IP, session.UserAgent, session.ExpiresAt.UTC(), usedAt, session.ID, usedHash,
+	)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return wrapError(op, err)
+	}
+	if affected == 0 {
+		var exists bool
+		err = tx.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM sessions WHERE id = $1);`, session.ID).Scan(&exists)
+		if err != nil {
+			return wrapError(op, err)
+		}
+		if !exists {
+			return storage.ErrSessionNotFound
+		}
+
+		return storage.ErrStaleSession
+	}
+
+	query = `
+		INSERT INTO used_refresh_tokens(session_id, token_hash, used_at)
+		VALUES ($1, $2, $3);
+	`
+
+	if _, err = tx.ExecContext(ctx, query, session.ID, usedHash, usedAt); err != nil {
+		return wrapError(op, err)
+	}
+
+	for _, notification := range outbox {
+		if _, err = enqueue(ctx, tx, notification); err != nil {
+			return wrapError(op, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return wrapError(op, err)
+	}
+
+	return nil
+}
+
+// RevokeSession revokes the session together with every refresh token of its family
+func (s *Storage) RevokeSession(ctx context.Context, id string) error {
+	const op = "storage.sqlite.RevokeSession"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE sessions
+		SET
+			revoked_at = $1,
+			refresh_hash = X''
+		WHERE id = $2 AND revoked_at IS NULL;
+	`
+
+	if _, err := s.db.ExecContext(ctx, query, now(), id); err != nil {
+		return wrapError(op, err)
+	}
+
+	return nil
+}
+
+// ListSessions returns active sessions of the user, recently used first
+func (s *Storage) ListSessions(ctx context.Context, uid int64) ([]models.Session, error) {
+	const op = "storage.sqlite.ListSessions"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, uid, refresh_hash, ip, user_agent, created_at, last_used_at, expires_at, revoked_at
+		FROM sessions
+		WHERE uid = $1 AND revoked_at IS NULL AND expires_at > $2
+		ORDER BY last_used_at DESC;
+	`
+
+	rows, err := s.db.QueryContext(ctx, query, uid, now())
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+	defer rows.Close()
+
+	var sessions []models.Session
+	for rows.Next() {
+		var session models.Session
+		err = rows.Scan(
+			&session.ID, &session.UID, &session.RefreshHash, &session.IP, &session.UserAgent,
+			&session.CreatedAt, &session.LastUsedAt, &session.ExpiresAt, &session.RevokedAt,
+		)
+		if err != nil {
+			return nil, wrapError(op, err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	return sessions, nil
+}
+
+// RevokeAllSessions revokes every session of the user and returns how many were revoked
+func (s *Storage) RevokeAllSessions(ctx context.Context, uid int64) (int64, error) {
+	const op = "storage.sqlite.RevokeAllSessions"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE sessions
+		SET
+			revoked_at = $1,
+			refresh_hash = X''
+		WHERE uid = $2 AND revoked_at IS NULL;
+	`
+
+	res, err := s.db.ExecContext(ctx, query, now(), uid)
+	if err != nil {
+		return 0, wrapError(op, err)
+	}
+
+	revoked, err := res.RowsAffected()
+	if err != nil {
+		return 0, wrapError(op, err)
+	}
+
+	return revoked, nil
+}
+
+// RevokeToken puts the access token's jti to the denylist until the token expires
+func (s *Storage) RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error {
+	const op = "storage.sqlite.RevokeToken"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO revoked_tokens(jti, expires_at)
+		VALUES ($1, $2)
+		ON CONFLICT (jti) DO NOTHING;
+	`
+
+	if _, err := s.db.ExecContext(ctx, query, jti, expiresAt.UTC()); err != nil {
+		return wrapError(op, err)
+	}
+
+	// Expired tokens are rejected anyway, so there is no need to keep them
+	query = `
+		DELETE FROM revoked_tokens
+		WHERE expires_at < $1;
+	`
+
+	if _, err := s.db.ExecContext(ctx, query, now()); err != nil {
+		return wrapError(op, err)
+	}
+
+	return nil
+}
+
+// IsTokenRevoked reports if the access token's jti is in the denylist
+func (s *Storage) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	const op = "storage.sqlite.IsTokenRevoked"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT EXISTS(SELECT 1 FROM revoked_tokens WHERE jti = $1);
+	`
+
+	var revoked bool
+	if err := s.db.QueryRowContext(ctx, query, jti).Scan(&revoked); err != nil {
+		return false, wrapError(op, err)
+	}
+
+	return revoked, nil
+}
+
+// SavePasswordReset stores the pending reset
+func (s *Storage) SavePasswordReset(ctx context.Context, reset models.PasswordReset) error {
+	const op = "storage.sqlite.SavePasswordReset"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO password_resets(token_hash, uid, created_at, expires_at)
+		VALUES ($1, $2, $3, $4);
+	`
+
+	if _, err := s.db.ExecContext(ctx, query, reset.TokenHash, reset.UID, now(), reset.ExpiresAt.UTC()); err != nil {
+		return wrapError(op, err)
+	}
+
+	return nil
+}
+
+// CountPasswordResets returns how many resets of the user were created since the time
+func (s *Storage) CountPasswordResets(ctx context.Context, uid int64, since time.Time) (int, error) {
+	const op = "storage.sqlite.CountPasswordResets"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT COUNT(*)
+		FROM password_resets
+		WHERE uid = $1 AND created_at >= $2;
+	`
+
+	var count int
+	if err := s.db.QueryRowContext(ctx, query, uid, since.UTC()).Scan(&count); err != nil {
+		return 0, wrapError(op, err)
+	}
+
+	return count, nil
+}
+
+// SaveVerificationEmail records the verification email sent to the user now
+func (s *Storage) SaveVerificationEmail(ctx context.Context, uid int64) error {
+	const op = "storage.sqlite.SaveVerificationEmail"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		INSERT INTO verification_emails(uid, sent_at)
+		VALUES ($1, $2);
+	`
+
+	if _, err := s.db.ExecContext(ctx, query, uid, now()); err != nil {
+		return wrapError(op, err)
+	}
+
+	return nil
+}
+
+// CountVerificationEmails returns how many verification emails were sent to the user since the time
+func (s *Storage) CountVerificationEmails(ctx context.Context, uid int64, since time.Time) (int, error) {
+	const op = "storage.sqlite.CountVerificationEmails"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT COUNT(*)
+		FROM verification_emails
+		WHERE uid = $1 AND sent_at >= $2;
+	`
+
+	var count int
+	if err := s.db.QueryRowContext(ctx, query, uid, since.UTC()).Scan(&count); err != nil {
+		return 0, wrapError(op, err)
+	}
+
+	return count, nil
+}
+
+// GetPasswordReset returns the unused reset by the hash of its token, it may be expired
+func (s *Storage) GetPasswordReset(ctx context.Context, tokenHash []byte) (models.PasswordReset, error) {
+	const op = "storage.sqlite.GetPasswordReset"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT token_hash, uid, created_at, expires_at
+		FROM password_resets
+		WHERE token_hash = $1;
+	`
+
+	var reset models.PasswordReset
+	err := s.db.QueryRowContext(ctx, query, tokenHash).Scan(&reset.TokenHash, &reset.UID, &reset.CreatedAt, &reset.ExpiresAt)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return models.PasswordReset{}, storage.ErrPasswordResetNotFound
+		}
+
+		return models.PasswordReset{}, wrapError(op, err)
+	}
+
+	return reset, nil
+}
+
+// ResetPassword spends the reset, sets the password and revokes all sessions of the user in one transaction
+func (s *Storage) ResetPassword(ctx context.Context, tokenHash []byte, passHash []byte, outbox ...models.Notification) error {
+	const op = "storage.sqlite.ResetPassword"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return wrapError(op, err)
+	}
+	defer func() { _ = tx.Rollback() }()
+
+	resetAt := now()
+
+	// Of concurrent resets with the same token only the one which deletes it goes on
+	var uid int64
+	query := `
+		DELETE FROM password_resets
+		WHERE token_hash = $1 AND expires_at > $2
+		RETURNING uid;
+	`
+
+	err = tx.QueryRowContext(ctx, query, tokenHash, resetAt).Scan(&uid)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return storage.ErrPasswordResetNotFound
+		}
+
+		return wrapError(op, err)
+	}
+
+	queries := []struct {
+		query string
+		args  []any
+	}{
+		{`UPDATE users SET pass_hash = $1, email_verified = 1 WHERE uid = $2;`, []any{passHash, uid}},
+		{`DELETE FROM password_resets WHERE uid = $1;`, []any{uid}},
+		{`UPDATE sessions SET revoked_at = $1, refresh_hash = X'' WHERE uid = $2 AND revoked_at IS NULL;`, []any{resetAt, uid}},
+	}
+
+	for _, q := range queries {
+		if _, err = tx.ExecContext(ctx, q.query, q.args...); err != nil {
+			return wrapError(op, err)
+		}
+	}
+
+	for _, notification := range outbox {
+		if _, err = enqueue(ctx, tx, notification); err != nil {
+			return wrapError(op, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return wrapError(op, err)
+	}
+
+	return nil
+}
+
+// EnqueueNotification adds the notification to the outbox and returns its id
+func (s *Storage) EnqueueNotification(ctx context.Context, notification models.Notification) (int64, error) {
+	const op = "storage.sqlite.EnqueueNotification"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	id, err := enqueue(ctx, s.db, notification)
+	if err != nil {
+		return 0, wrapError(op, err)
+	}
+
+	return id, nil
+}
+
+// querier is either the database or a transaction
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func enqueue(ctx context.Context, db querier, notification models.Notification) (int64, error) {
+	query := `
+		INSERT INTO notification_outbox(recipient, subject, body, html, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $5)
+		RETURNING id;
+	`
+
+	var id int64
+	err := db.QueryRowContext(ctx, query, notification.To, notification.Subject, notification.Body, notification.HTML, now()).Scan(&id)
+
+	return id, err
+}
+
+// ClaimNotifications returns due pending notifications, the oldest first, and postpones them by the lease
+func (s *Storage) ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]models.Notification, error) {
+	const op = "storage.sqlite.ClaimNotifications"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	claimedAt := now()
+
+	// SQLite serializes writers, so the select and update can't race with another claim
+	query := `
+		UPDATE notification_outbox
+		SET
+			attempts = attempts + 1,
+			next_attempt_at = $1
+		WHERE id IN (
+			SELECT id
+			FROM notification_outbox
+			WHERE status = $2 AND next_attempt_at <= $3
+			ORDER BY id
+			LIMIT $4
+		)
+		RETURNING id, recipient, subject, body, html, status, attempts, next_attempt_at, last_error, created_at;
+	`
+
+	notifications, err := s.queryNotifications(ctx, query, claimedAt.Add(lease), models.NotificationPending, claimedAt, limit)
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	// RETURNING gives no order
+	sort.Slice(notifications, func(i, j int) bool {
+		return notifications[i].ID < notifications[j].ID
+	})
+
+	return notifications, nil
+}
+
+// DeleteNotification removes the delivered notification
+func (s *Storage) DeleteNotification(ctx context.Context, id int64) error {
+	const op = "storage.sqlite.DeleteNotification"
+
+	query := `
+		DELETE FROM notification_outbox
+		WHERE id = $1;
+	`
+
+	return s.updateNotification(ctx, op, query, id)
+}
+
+// RetryNotification schedules the next attempt of the notification
+func (s *Storage) RetryNotification(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error {
+	const op = "storage.sqlite.RetryNotification"
+
+	query := `
+		UPDATE notification_outbox
+		SET
+			next_attempt_at = $1,
+			last_error = $2
+		WHERE id = $3;
+	`
+
+	return s.updateNotification(ctx, op, query, nextAttemptAt.UTC(), lastError, id)
+}
+
+// DeadLetterNotification stops delivery of the notification until it is replayed
+func (s *Storage) DeadLetterNotification(ctx context.Context, id int64, lastError string) error {
+	const op = "storage.sqlite.DeadLetterNotification"
+
+	query := `
+		UPDATE notification_outbox
+		SET
+			status = $1,
+			last_error = $2
+		WHERE id = $3;
+	`
+
+	return s.updateNotification(ctx, op, query, models.NotificationDead, lastError, id)
+}
+
+// ListDeadNotifications returns dead notifications, recently created first
+func (s *Storage) ListDeadNotifications(ctx context.Context, limit int) ([]models.Notification, error) {
+	const op = "storage.sqlite.ListDeadNotifications"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT id, recipient, subject, body, html, status, attempts, next_attempt_at, last_error, created_at
+		FROM notification_outbox
+		WHERE status = $1
+		ORDER BY id DESC
+		LIMIT $2;
+	`
+
+	notifications, err := s.queryNotifications(ctx, query, models.NotificationDead, limit)
+	if err != nil {
+		return nil, wrapError(op, err)
+	}
+
+	return notifications, nil
+}
+
+// ReplayNotification makes the dead notification pending again with fresh attempts
+func (s *Storage) ReplayNotification(ctx context.Context, id int64) error {
+	const op = "storage.sqlite.ReplayNotification"
+
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	query := `
+		UPDATE notification_outbox
+		SET
+			status = $1,
+			attempts = 0,
+			next_attempt_at = $2
+		WHERE id = $3 AND status = $4;
+	`
+
+	res, err := s.db.ExecContext(ctx, query, models.NotificationPending, now(), id, models.NotificationDead)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return wrapError(op, err)
+	}
+	if affected == 0 {
+		var exists bool
+		err = s.db.QueryRowContext(ctx, `SELECT EXISTS(SELECT 1 FROM notification_outbox WHERE id = $1);`, id).Scan(&exists)
+		if err != nil {
+			return wrapError(op, err)
+		}
+		if !exists {
+			return storage.ErrNotificationNotFound
+		}
+
+		return storage.ErrNotificationNotDead
+	}
+
+	return nil
+}
+
+// updateNotification runs the query changing the single notification
+func (s *Storage) updateNotification(ctx context.Context, op string, query string, args ...any) error {
+	ctx, cancel := s.withTimeout(ctx)
+	defer cancel()
+
+	res, err := s.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return wrapError(op, err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return wrapError(op, err)
+	}
+	if affected == 0 {
+		return storage.ErrNotificationNotFound
+	}
+
+	return nil
+}
+
+func (s *Storage) queryNotifications(ctx context.Context, query string, args ...any) ([]models.Notification, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var notifications []models.Notification
+	for rows.Next() {
+		var n models.Notification
+		err = rows.Scan(&n.ID, &n.To, &n.Subject, &n.Body, &n.HTML, &n.Status, &n.Attempts, &n.NextAttemptAt, &n.LastError, &n.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		notifications = append(notifications, n)
+	}
+
+	return notifications, rows.Err()
+}
+
+// withTimeout limits a single query or transaction by the storage's query timeout
+func (s *Storage) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+func now() time.Time {
+	return time.Now().UTC()
+}
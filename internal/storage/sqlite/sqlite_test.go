@@ -0,0 +1,68 @@
+package sqlite
+
+import (
+	"context"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"github.com/northwindman/testREST-autentification/internal/storage/storagetest"
+	"github.com/stretchr/testify/require"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStorage(t *testing.T) {
+	storagetest.Run(t, func(t *testing.T) storage.Storage {
+		return newStorage(t)
+	})
+}
+
+func TestMigrator_Down(t *testing.T) {
+	s := newStorage(t)
+	defer s.Close()
+
+	m, err := s.Migrator()
+	require.NoError(t, err)
+
+	statuses, err := m.Status(context.Background())
+	require.NoError(t, err)
+
+	rolledBack, err := m.Down(context.Background(), len(statuses))
+	require.NoError(t, err)
+	require.Equal(t, len(statuses), rolledBack)
+
+	statuses, err = m.Status(context.Background())
+	require.NoError(t, err)
+	for _, status := range statuses {
+		require.Nil(t, status.AppliedAt)
+	}
+
+	applied, err := m.Up(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, len(statuses), applied)
+}
+
+func TestStorage_CanceledContext(t *testing.T) {
+	s := newStorage(t)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.GetUser(ctx, "user@example.com")
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func newStorage(t *testing.T) *Storage {
+	t.Helper()
+
+	s, err := New(filepath.Join(t.TempDir(), "storage.db"), 5*time.Second)
+	require.NoError(t, err)
+
+	m, err := s.Migrator()
+	require.NoError(t, err)
+
+	_, err = m.Up(context.Background())
+	require.NoError(t, err)
+
+	return s
+}
@@ -1,8 +1,111 @@
 package storage
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"time"
+)
+
+// Kinds of storage errors. Backends map their driver's errors to them, so callers
+// branch with errors.Is without knowing which database is used
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrConstraint = errors.New("constraint violation")
+	// ErrTransient is a failure worth retrying later: timeout, lost connection, serialization failure
+	ErrTransient = errors.New("temporary failure")
+)
 
 var (
-	ErrAlreadyExist = errors.New("user already exist")
-	ErrNotFound     = errors.New("user not found")
+	ErrUserNotFound = fmt.Errorf("user %w", ErrNotFound)
+	ErrAlreadyExist = fmt.Errorf("user already exist: %w", ErrConflict)
+	// ErrEmailAlreadyVerified means the verification was already done, e.g. by the same link
+	ErrEmailAlreadyVerified = fmt.Errorf("email already verified: %w", ErrConflict)
+	ErrSessionNotFound      = fmt.Errorf("session %w", ErrNotFound)
+	// ErrStaleSession means the session was rotated or revoked since it was read
+	ErrStaleSession = fmt.Errorf("session was changed concurrently: %w", ErrConflict)
+
+	// ErrPasswordResetNotFound also means the reset was already used or has expired
+	ErrPasswordResetNotFound = fmt.Errorf("password reset %w", ErrNotFound)
+
+	ErrNotificationNotFound = fmt.Errorf("notification %w", ErrNotFound)
+	// ErrNotificationNotDead means only dead notifications can be replayed
+	ErrNotificationNotDead = fmt.Errorf("notification is not dead: %w", ErrConflict)
 )
+
+// Outbox keeps notifications until they are delivered. Delivery is at least once:
+// a claimed notification is handed out again if it is neither deleted nor retried in time
+type Outbox interface {
+	EnqueueNotification(ctx context.Context, notification models.Notification) (int64, error)
+	// ClaimNotifications returns up to limit pending notifications which are due, counts
+	// the attempt and hides them from other claims for the lease
+	ClaimNotifications(ctx context.Context, limit int, lease time.Duration) ([]models.Notification, error)
+	// DeleteNotification removes the delivered notification
+	DeleteNotification(ctx context.Context, id int64) error
+	RetryNotification(ctx context.Context, id int64, nextAttemptAt time.Time, lastError string) error
+	DeadLetterNotification(ctx context.Context, id int64, lastError string) error
+	// ListDeadNotifications returns dead notifications, recently created first
+	ListDeadNotifications(ctx context.Context, limit int) ([]models.Notification, error)
+	// ReplayNotification makes the dead notification pending again with fresh attempts
+	ReplayNotification(ctx context.Context, id int64) error
+}
+
+// PasswordResets keeps pending password resets by the hashes of their tokens
+type PasswordResets interface {
+	SavePasswordReset(ctx context.Context, reset models.PasswordReset) error
+	// CountPasswordResets returns how many resets of the user were created since the time
+	CountPasswordResets(ctx context.Context, uid int64, since time.Time) (int, error)
+	// GetPasswordReset returns the unused reset, it may be expired
+	GetPasswordReset(ctx context.Context, tokenHash []byte) (models.PasswordReset, error)
+	// ResetPassword spends the reset unless it has expired: it sets the password, marks the email
+	// verified as the user has just proved to own it, deletes other resets of the user and revokes
+	// all the user's sessions. The notifications are enqueued in the same transaction
+	ResetPassword(ctx context.Context, tokenHash []byte, passHash []byte, outbox ...models.Notification) error
+}
+
+// VerificationEmails records verification emails sent on request, so they can be rate limited
+type VerificationEmails interface {
+	// SaveVerificationEmail records the email sent to the user now
+	SaveVerificationEmail(ctx context.Context, uid int64) error
+	// CountVerificationEmails returns how many emails were sent to the user since the time
+	CountVerificationEmails(ctx context.Context, uid int64, since time.Time) (int, error)
+}
+
+// Storage is implemented by every storage backend. Handlers depend on the smaller
+// interfaces they need, this one is for wiring the whole service together
+type Storage interface {
+	SaveUser(ctx context.Context, ip string, email string, passHash []byte, locale string) (int64, error)
+	GetUser(ctx context.Context, email string) (models.User, error)
+	GetUserByID(ctx context.Context, uid int64) (models.User, error)
+	UpdatePassword(ctx context.Context, uid int64, passHash []byte) error
+	// VerifyEmail marks the email of the user verified, only if the user still has that email
+	VerifyEmail(ctx context.Context, uid int64, email string) error
+
+	SaveSession(ctx context.Context, session models.Session) error
+	GetSession(ctx context.Context, id string) (models.Session, error)
+	// RotateSession replaces the refresh hash only while it is still usedHash, so of concurrent
+	// rotations of the same token just one wins and the others get ErrStaleSession.
+	// The notifications are enqueued in the same transaction, only if the rotation wins
+	RotateSession(ctx context.Context, session models.Session, usedHash []byte, outbox ...models.Notification) error
+	// GetSessionByRefreshHash finds the active session by the hash of its current refresh token
+	GetSessionByRefreshHash(ctx context.Context, hash []byte) (models.Session, error)
+	// GetSessionByUsedRefreshHash finds the session by the hash of one of its rotated refresh tokens,
+	// usedAt is when the token was rotated
+	GetSessionByUsedRefreshHash(ctx context.Context, hash []byte) (session models.Session, usedAt time.Time, err error)
+	RevokeSession(ctx context.Context, id string) error
+	ListSessions(ctx context.Context, uid int64) ([]models.Session, error)
+	RevokeAllSessions(ctx context.Context, uid int64) (int64, error)
+
+	RevokeToken(ctx context.Context, jti string, expiresAt time.Time) error
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+
+	PasswordResets
+
+	VerificationEmails
+
+	Outbox
+
+	Close() error
+}
@@ -0,0 +1,676 @@
+// Package storagetest is a conformance suite every storage backend has to pass
+package storagetest
+
+import (
+	"context"
+	"fmt"
+	"github.com/northwindman/testREST-autentification/internal/domain/models"
+	"github.com/northwindman/testREST-autentification/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Precision of stored times, Postgres keeps microseconds only
+const precision = time.Millisecond
+
+var seq atomic.Int64
+
+// Run runs the suite, newStorage returns a ready to use (migrated) storage for every test.
+// The suite doesn't rely on the storage being empty, so backends may share a database
+func Run(t *testing.T, newStorage func(t *testing.T) storage.Storage) {
+	tests := []struct {
+		name string
+		test func(t *testing.T, s storage.Storage)
+	}{
+		{"SaveUser", testSaveUser},
+		{"SaveUser_AlreadyExist", testSaveUserAlreadyExist},
+		{"GetUser_NotFound", testGetUserNotFound},
+		{"UpdatePassword", testUpdatePassword},
+		{"VerifyEmail", testVerifyEmail},
+		{"SaveSession", testSaveSession},
+		{"SaveSession_UnknownUser", testSaveSessionUnknownUser},
+		{"SaveSession_Duplicate", testSaveSessionDuplicate},
+		{"GetSession_NotFound", testGetSessionNotFound},
+		{"RotateSession", testRotateSession},
+		{"RotateSession_NotFound", testRotateSessionNotFound},
+		{"RotateSession_Stale", testRotateSessionStale},
+		{"RotateSession_Concurrent", testRotateSessionConcurrent},
+		{"RotateSession_Outbox", testRotateSessionOutbox},
+		{"RevokeSession", testRevokeSession},
+		{"ListSessions", testListSessions},
+		{"RevokeAllSessions", testRevokeAllSessions},
+		{"RevokeToken", testRevokeToken},
+		{"PasswordReset", testPasswordReset},
+		{"PasswordReset_Expired", testPasswordResetExpired},
+		{"VerificationEmails", testVerificationEmails},
+		{"Outbox", testOutbox},
+		{"Outbox_DeadLetter", testOutboxDeadLetter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newStorage(t)
+			t.Cleanup(func() { _ = s.Close() })
+
+			tt.test(t, s)
+		})
+	}
+}
+
+func testSaveUser(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	email := uniqueEmail()
+
+	uid, err := s.SaveUser(ctx, "127.0.0.1", email, []byte("hash"), "ru")
+	require.NoError(t, err)
+	assert.NotZero(t, uid)
+
+	byEmail, err := s.GetUser(ctx, email)
+	require.NoError(t, err)
+	assert.Equal(t, models.User{UID: uid, IP: "127.0.0.1", Email: email, PassHash: []byte("hash"), Locale: "ru"}, byEmail)
+
+	byID, err := s.GetUserByID(ctx, uid)
+	require.NoError(t, err)
+	assert.Equal(t, byEmail, byID)
+}
+
+func testSaveUserAlreadyExist(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	email := uniqueEmail()
+
+	_, err := s.SaveUser(ctx, "127.0.0.1", email, []byte("hash"), "")
+	require.NoError(t, err)
+
+	_, err = s.SaveUser(ctx, "127.0.0.2", email, []byte("other"), "")
+	assert.ErrorIs(t, err, storage.ErrAlreadyExist)
+	assert.ErrorIs(t, err, storage.ErrConflict)
+}
+
+func testGetUserNotFound(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	_, err := s.GetUser(ctx, uniqueEmail())
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+
+	_, err = s.GetUserByID(ctx, -1)
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testUpdatePassword(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	uid := saveUser(t, s)
+
+	require.NoError(t, s.UpdatePassword(ctx, uid, []byte("new hash")))
+
+	user, err := s.GetUserByID(ctx, uid)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new hash"), user.PassHash)
+
+	err = s.UpdatePassword(ctx, -1, []byte("hash"))
+	assert.ErrorIs(t, err, storage.ErrUserNotFound)
+}
+
+func testVerifyEmail(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	uid := saveUser(t, s)
+	user, err := s.GetUserByID(ctx, uid)
+	require.NoError(t, err)
+	assert.False(t, user.EmailVerified, "new users are not verified")
+
+	assert.ErrorIs(t, s.VerifyEmail(ctx, uid, "other@example.com"), storage.ErrUserNotFound, "the email must match")
+	assert.ErrorIs(t, s.VerifyEmail(ctx, -1, user.Email), storage.ErrUserNotFound)
+
+	require.NoError(t, s.VerifyEmail(ctx, uid, user.Email))
+
+	user, err = s.GetUser(ctx, user.Email)
+	require.NoError(t, err)
+	assert.True(t, user.EmailVerified)
+
+	assert.ErrorIs(t, s.VerifyEmail(ctx, uid, user.Email), storage.ErrEmailAlreadyVerified)
+}
+
+func testSaveSession(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	uid := saveUser(t, s)
+	session := saveSession(t, s, uid, time.Now().Add(time.Hour))
+
+	got, err := s.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, session.ID, got.ID)
+	assert.Equal(t, uid, got.UID)
+	assert.Equal(t, session.RefreshHash, got.RefreshHash)
+	assert.Equal(t, session.IP, got.IP)
+	assert.Equal(t, session.UserAgent, got.UserAgent)
+	assert.WithinDuration(t, session.ExpiresAt, got.ExpiresAt, precision)
+	assert.WithinDuration(t, time.Now(), got.CreatedAt, time.Minute)
+	assert.WithinDuration(t, got.CreatedAt, got.LastUsedAt, precision)
+	assert.Nil(t, got.RevokedAt)
+}
+
+func testSaveSessionUnknownUser(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	err := s.SaveSession(ctx, models.Session{
+		ID:          uniqueID(),
+		UID:         -1,
+		RefreshHash: []byte("hash"),
+		ExpiresAt:   time.Now().Add(time.Hour),
+	})
+	assert.ErrorIs(t, err, storage.ErrConstraint)
+}
+
+func testSaveSessionDuplicate(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	uid := saveUser(t, s)
+	session := saveSession(t, s, uid, time.Now().Add(time.Hour))
+
+	err := s.SaveSession(ctx, session)
+	assert.ErrorIs(t, err, storage.ErrConflict)
+}
+
+func testGetSessionNotFound(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	_, err := s.GetSession(ctx, uniqueID())
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+	assert.ErrorIs(t, err, storage.ErrNotFound)
+}
+
+func testRotateSession(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	uid := saveUser(t, s)
+	session := saveSession(t, s, uid, time.Now().Add(time.Hour))
+	usedHash := session.RefreshHash
+
+	nextHash := []byte("next hash " + uniqueID())
+	session.RefreshHash = nextHash
+	session.IP = "10.0.0.2"
+	session.UserAgent = "other agent"
+	session.ExpiresAt = time.Now().Add(2 * time.Hour)
+
+	require.NoError(t, s.RotateSession(ctx, session, usedHash))
+
+	got, err := s.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+
+	assert.Equal(t, nextHash, got.RefreshHash)
+	assert.Equal(t, "10.0.0.2", got.IP)
+	assert.Equal(t, "other agent", got.UserAgent)
+	assert.WithinDuration(t, session.ExpiresAt, got.ExpiresAt, precision)
+	assert.False(t, got.LastUsedAt.Before(got.CreatedAt))
+
+	byHash, err := s.GetSessionByRefreshHash(ctx, nextHash)
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, byHash.ID)
+
+	_, err = s.GetSessionByRefreshHash(ctx, usedHash)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+
+	byUsedHash, usedAt, err := s.GetSessionByUsedRefreshHash(ctx, usedHash)
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, byUsedHash.ID)
+	assert.WithinDuration(t, got.LastUsedAt, usedAt, precision, "the token was used by the rotation")
+
+	_, _, err = s.GetSessionByUsedRefreshHash(ctx, nextHash)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+}
+
+func testRotateSessionNotFound(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	err := s.RotateSession(ctx, models.Session{ID: uniqueID(), ExpiresAt: time.Now()}, []byte("hash"))
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+}
+
+func testRotateSessionStale(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	uid := saveUser(t, s)
+	session := saveSession(t, s, uid, time.Now().Add(time.Hour))
+	usedHash := session.RefreshHash
+
+	session.RefreshHash = []byte("next hash")
+	require.NoError(t, s.RotateSession(ctx, session, usedHash))
+
+	// The same refresh token can't be rotated twice
+	session.RefreshHash = []byte("other hash")
+	err := s.RotateSession(ctx, session, usedHash)
+	assert.ErrorIs(t, err, storage.ErrStaleSession)
+
+	// Neither can a revoked session be rotated
+	require.NoError(t, s.RevokeSession(ctx, session.ID))
+	err = s.RotateSession(ctx, session, []byte("next hash"))
+	assert.ErrorIs(t, err, storage.ErrStaleSession)
+}
+
+func testRotateSessionConcurrent(t *testing.T, s storage.Storage) {
+	const rotations = 10
+
+	ctx := context.Background()
+
+	uid := saveUser(t, s)
+	session := saveSession(t, s, uid, time.Now().Add(time.Hour))
+	usedHash := session.RefreshHash
+	prefix := "next hash " + uniqueID()
+
+	var (
+		wg    sync.WaitGroup
+		start = make(chan struct{})
+		errs  = make([]error, rotations)
+	)
+
+	for i := 0; i < rotations; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			next := session
+			next.RefreshHash = []byte(fmt.Sprintf("%s %d", prefix, i))
+
+			<-start
+			errs[i] = s.RotateSession(ctx, next, usedHash)
+		}(i)
+	}
+
+	close(start)
+	wg.Wait()
+
+	winner := -1
+	for i, err := range errs {
+		if err == nil {
+			require.Equal(t, -1, winner, "more than one rotation succeeded")
+			winner = i
+			continue
+		}
+		assert.ErrorIs(t, err, storage.ErrStaleSession)
+	}
+	require.NotEqual(t, -1, winner, "no rotation succeeded")
+
+	got, err := s.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.Equal(t, []byte(fmt.Sprintf("%s %d", prefix, winner)), got.RefreshHash)
+
+	byUsedHash, _, err := s.GetSessionByUsedRefreshHash(ctx, usedHash)
+	require.NoError(t, err)
+	assert.Equal(t, session.ID, byUsedHash.ID)
+}
+
+func testRevokeSession(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	uid := saveUser(t, s)
+	session := saveSession(t, s, uid, time.Now().Add(time.Hour))
+
+	require.NoError(t, s.RevokeSession(ctx, session.ID))
+
+	got, err := s.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	require.NotNil(t, got.RevokedAt)
+	assert.WithinDuration(t, time.Now(), *got.RevokedAt, time.Minute)
+	assert.Empty(t, got.RefreshHash)
+
+	_, err = s.GetSessionByRefreshHash(ctx, session.RefreshHash)
+	assert.ErrorIs(t, err, storage.ErrSessionNotFound)
+
+	// Revoking again keeps the original revocation time
+	require.NoError(t, s.RevokeSession(ctx, session.ID))
+
+	again, err := s.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.True(t, got.RevokedAt.Equal(*again.RevokedAt))
+}
+
+func testListSessions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	uid := saveUser(t, s)
+	otherUID := saveUser(t, s)
+
+	older := saveSession(t, s, uid, time.Now().Add(time.Hour))
+	newer := saveSession(t, s, uid, time.Now().Add(time.Hour))
+	revoked := saveSession(t, s, uid, time.Now().Add(time.Hour))
+	saveSession(t, s, uid, time.Now().Add(-time.Hour))
+	saveSession(t, s, otherUID, time.Now().Add(time.Hour))
+
+	require.NoError(t, s.RevokeSession(ctx, revoked.ID))
+
+	// Using the older session makes it the most recently used one
+	time.Sleep(2 * precision)
+	require.NoError(t, s.RotateSession(ctx, older, older.RefreshHash))
+
+	sessions, err := s.ListSessions(ctx, uid)
+	require.NoError(t, err)
+	require.Len(t, sessions, 2)
+	assert.Equal(t, older.ID, sessions[0].ID)
+	assert.Equal(t, newer.ID, sessions[1].ID)
+}
+
+func testRevokeAllSessions(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	uid := saveUser(t, s)
+	otherUID := saveUser(t, s)
+
+	first := saveSession(t, s, uid, time.Now().Add(time.Hour))
+	saveSession(t, s, uid, time.Now().Add(time.Hour))
+	other := saveSession(t, s, otherUID, time.Now().Add(time.Hour))
+
+	require.NoError(t, s.RevokeSession(ctx, first.ID))
+
+	revoked, err := s.RevokeAllSessions(ctx, uid)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), revoked)
+
+	sessions, err := s.ListSessions(ctx, uid)
+	require.NoError(t, err)
+	assert.Empty(t, sessions)
+
+	got, err := s.GetSession(ctx, other.ID)
+	require.NoError(t, err)
+	assert.Nil(t, got.RevokedAt)
+}
+
+func testRevokeToken(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	jti := uniqueID()
+
+	revoked, err := s.IsTokenRevoked(ctx, jti)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+
+	require.NoError(t, s.RevokeToken(ctx, jti, time.Now().Add(time.Hour)))
+	require.NoError(t, s.RevokeToken(ctx, jti, time.Now().Add(time.Hour)))
+
+	revoked, err = s.IsTokenRevoked(ctx, jti)
+	require.NoError(t, err)
+	assert.True(t, revoked)
+
+	// Expired tokens are cleaned up with the next revocation
+	expired := uniqueID()
+	require.NoError(t, s.RevokeToken(ctx, expired, time.Now().Add(-time.Hour)))
+	require.NoError(t, s.RevokeToken(ctx, uniqueID(), time.Now().Add(time.Hour)))
+
+	revoked, err = s.IsTokenRevoked(ctx, expired)
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func testRotateSessionOutbox(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	uid := saveUser(t, s)
+	session := saveSession(t, s, uid, time.Now().Add(time.Hour))
+	usedHash := session.RefreshHash
+
+	to := uniqueEmail()
+	notification := models.Notification{To: to, Subject: "New sign-in", Body: "body"}
+
+	session.RefreshHash = []byte("next hash " + uniqueID())
+	require.NoError(t, s.RotateSession(ctx, session, usedHash, notification))
+
+	// The losing rotation enqueues nothing
+	err := s.RotateSession(ctx, session, usedHash, notification)
+	require.ErrorIs(t, err, storage.ErrStaleSession)
+
+	claimed := claim(t, s, to)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, "New sign-in", claimed[0].Subject)
+	assert.Equal(t, "body", claimed[0].Body)
+}
+
+func testPasswordReset(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	uid := saveUser(t, s)
+	other := saveUser(t, s)
+	session := saveSession(t, s, uid, time.Now().Add(time.Hour))
+	otherSession := saveSession(t, s, other, time.Now().Add(time.Hour))
+
+	since := time.Now().Add(-time.Minute)
+	first := models.PasswordReset{TokenHash: []byte("reset " + uniqueID()), UID: uid, ExpiresAt: time.Now().Add(time.Hour)}
+	second := models.PasswordReset{TokenHash: []byte("reset " + uniqueID()), UID: uid, ExpiresAt: time.Now().Add(time.Hour)}
+	require.NoError(t, s.SavePasswordReset(ctx, first))
+	require.NoError(t, s.SavePasswordReset(ctx, second))
+	require.NoError(t, s.SavePasswordReset(ctx, models.PasswordReset{TokenHash: []byte("reset " + uniqueID()), UID: other, ExpiresAt: time.Now().Add(time.Hour)}))
+
+	assert.ErrorIs(t, s.SavePasswordReset(ctx, models.PasswordReset{TokenHash: []byte("reset " + uniqueID()), UID: -1, ExpiresAt: time.Now()}), storage.ErrConstraint)
+
+	count, err := s.CountPasswordResets(ctx, uid, since)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	count, err = s.CountPasswordResets(ctx, uid, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, count)
+
+	got, err := s.GetPasswordReset(ctx, first.TokenHash)
+	require.NoError(t, err)
+	assert.Equal(t, uid, got.UID)
+	assert.WithinDuration(t, first.ExpiresAt, got.ExpiresAt, time.Second)
+	assert.WithinDuration(t, time.Now(), got.CreatedAt, time.Minute)
+
+	_, err = s.GetPasswordReset(ctx, []byte("unknown"))
+	assert.ErrorIs(t, err, storage.ErrPasswordResetNotFound)
+
+	to := uniqueEmail()
+	require.NoError(t, s.ResetPassword(ctx, first.TokenHash, []byte("new hash"), models.Notification{To: to, Subject: "Password changed", Body: "body"}))
+
+	user, err := s.GetUserByID(ctx, uid)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new hash"), user.PassHash)
+	assert.True(t, user.EmailVerified, "the user has proved to own the email")
+
+	stored, err := s.GetSession(ctx, session.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, stored.RevokedAt, "sessions of the user are revoked")
+	stored, err = s.GetSession(ctx, otherSession.ID)
+	require.NoError(t, err)
+	assert.Nil(t, stored.RevokedAt, "sessions of other users are kept")
+
+	assert.Len(t, claim(t, s, to), 1)
+
+	// The reset is spent, and so are the other resets of the user
+	assert.ErrorIs(t, s.ResetPassword(ctx, first.TokenHash, []byte("other hash")), storage.ErrPasswordResetNotFound)
+	assert.ErrorIs(t, s.ResetPassword(ctx, second.TokenHash, []byte("other hash")), storage.ErrPasswordResetNotFound)
+	_, err = s.GetPasswordReset(ctx, second.TokenHash)
+	assert.ErrorIs(t, err, storage.ErrPasswordResetNotFound)
+
+	count, err = s.CountPasswordResets(ctx, other, since)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count, "resets of other users are kept")
+}
+
+func testVerificationEmails(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	uid := saveUser(t, s)
+	other := saveUser(t, s)
+
+	since := time.Now().Add(-time.Minute)
+	require.NoError(t, s.SaveVerificationEmail(ctx, uid))
+	require.NoError(t, s.SaveVerificationEmail(ctx, uid))
+	require.NoError(t, s.SaveVerificationEmail(ctx, other))
+
+	assert.ErrorIs(t, s.SaveVerificationEmail(ctx, -1), storage.ErrConstraint)
+
+	count, err := s.CountVerificationEmails(ctx, uid, since)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+	count, err = s.CountVerificationEmails(ctx, uid, time.Now().Add(time.Minute))
+	require.NoError(t, err)
+	assert.Zero(t, count)
+}
+
+func testPasswordResetExpired(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	uid := saveUser(t, s)
+	reset := models.PasswordReset{TokenHash: []byte("reset " + uniqueID()), UID: uid, ExpiresAt: time.Now().Add(-time.Second)}
+	require.NoError(t, s.SavePasswordReset(ctx, reset))
+
+	// Expired resets are still found, so the caller can tell why the link doesn't work
+	_, err := s.GetPasswordReset(ctx, reset.TokenHash)
+	require.NoError(t, err)
+
+	to := uniqueEmail()
+	err = s.ResetPassword(ctx, reset.TokenHash, []byte("new hash"), models.Notification{To: to, Subject: "Password changed", Body: "body"})
+	assert.ErrorIs(t, err, storage.ErrPasswordResetNotFound)
+
+	user, err := s.GetUserByID(ctx, uid)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("hash"), user.PassHash)
+	assert.Empty(t, claim(t, s, to))
+}
+
+func testOutbox(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	to := uniqueEmail()
+
+	first, err := s.EnqueueNotification(ctx, models.Notification{To: to, Subject: "first", Body: "body", HTML: "<p>body</p>"})
+	require.NoError(t, err)
+	second, err := s.EnqueueNotification(ctx, models.Notification{To: to, Subject: "second", Body: "body"})
+	require.NoError(t, err)
+	assert.Greater(t, second, first)
+
+	claimed := claim(t, s, to)
+	require.Len(t, claimed, 2)
+	assert.Equal(t, first, claimed[0].ID)
+	assert.Equal(t, "first", claimed[0].Subject)
+	assert.Equal(t, "<p>body</p>", claimed[0].HTML)
+	assert.Equal(t, models.NotificationPending, claimed[0].Status)
+	assert.Equal(t, 1, claimed[0].Attempts)
+	assert.True(t, claimed[0].NextAttemptAt.After(time.Now().Add(30*time.Minute)), "claimed for the lease")
+	assert.Equal(t, second, claimed[1].ID)
+
+	// Claimed notifications are hidden until the lease ends or they are retried
+	assert.Empty(t, claim(t, s, to))
+
+	require.NoError(t, s.RetryNotification(ctx, first, time.Now().Add(-time.Second), "timeout"))
+	require.NoError(t, s.RetryNotification(ctx, second, time.Now().Add(time.Hour), "timeout"))
+
+	claimed = claim(t, s, to)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, first, claimed[0].ID)
+	assert.Equal(t, 2, claimed[0].Attempts)
+	assert.Equal(t, "timeout", claimed[0].LastError)
+
+	require.NoError(t, s.DeleteNotification(ctx, first))
+
+	err = s.DeleteNotification(ctx, first)
+	assert.ErrorIs(t, err, storage.ErrNotificationNotFound)
+	err = s.RetryNotification(ctx, first, time.Now(), "timeout")
+	assert.ErrorIs(t, err, storage.ErrNotificationNotFound)
+}
+
+func testOutboxDeadLetter(t *testing.T, s storage.Storage) {
+	ctx := context.Background()
+
+	to := uniqueEmail()
+
+	id, err := s.EnqueueNotification(ctx, models.Notification{To: to, Subject: "subject", Body: "body"})
+	require.NoError(t, err)
+
+	err = s.ReplayNotification(ctx, id)
+	assert.ErrorIs(t, err, storage.ErrNotificationNotDead)
+	assert.ErrorIs(t, err, storage.ErrConflict)
+
+	require.Len(t, claim(t, s, to), 1)
+	require.NoError(t, s.DeadLetterNotification(ctx, id, "550 mailbox unavailable"))
+
+	dead, err := s.ListDeadNotifications(ctx, 1000)
+	require.NoError(t, err)
+
+	var found *models.Notification
+	for i := range dead {
+		if dead[i].ID == id {
+			found = &dead[i]
+		}
+	}
+	require.NotNil(t, found, "dead notification is listed")
+	assert.Equal(t, models.NotificationDead, found.Status)
+	assert.Equal(t, "550 mailbox unavailable", found.LastError)
+	assert.Equal(t, 1, found.Attempts)
+
+	limited, err := s.ListDeadNotifications(ctx, 1)
+	require.NoError(t, err)
+	assert.Len(t, limited, 1)
+
+	require.NoError(t, s.ReplayNotification(ctx, id))
+
+	claimed := claim(t, s, to)
+	require.Len(t, claimed, 1)
+	assert.Equal(t, 1, claimed[0].Attempts, "replay starts attempts over")
+
+	err = s.ReplayNotification(ctx, -1)
+	assert.ErrorIs(t, err, storage.ErrNotificationNotFound)
+	err = s.DeadLetterNotification(ctx, -1, "")
+	assert.ErrorIs(t, err, storage.ErrNotificationNotFound)
+}
+
+// claim claims due notifications and returns the ones to the recipient, others may be left by other tests
+func claim(t *testing.T, s storage.Storage, to string) []models.Notification {
+	t.Helper()
+
+	claimed, err := s.ClaimNotifications(context.Background(), 1000, time.Hour)
+	require.NoError(t, err)
+
+	var ours []models.Notification
+	for _, notification := range claimed {
+		if notification.To == to {
+			ours = append(ours, notification)
+		}
+	}
+
+	return ours
+}
+
+func saveUser(t *testing.T, s storage.Storage) int64 {
+	t.Helper()
+
+	ctx := context.Background()
+
+	uid, err := s.SaveUser(ctx, "127.0.0.1", uniqueEmail(), []byte("hash"), "")
+	require.NoError(t, err)
+
+	return uid
+}
+
+func saveSession(t *testing.T, s storage.Storage, uid int64, expiresAt time.Time) models.Session {
+	t.Helper()
+
+	ctx := context.Background()
+
+	session := models.Session{
+		ID:          uniqueID(),
+		UID:         uid,
+		RefreshHash: []byte("hash " + uniqueID()),
+		IP:          "10.0.0.1",
+		UserAgent:   "storagetest",
+		ExpiresAt:   expiresAt,
+	}
+	require.NoError(t, s.SaveSession(ctx, session))
+
+	return session
+}
+
+func uniqueID() string {
+	return fmt.Sprintf("storagetest-%d-%d", time.Now().UnixNano(), seq.Add(1))
+}
+
+func uniqueEmail() string {
+	return uniqueID() + "@example.com"
+}